@@ -0,0 +1,284 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+/**
+ * Distinct counts grouped by several dimensions, e.g. distinct users per
+ * (country, device, app), with their rollups.
+ *
+ * An HLL is kept per tuple of dimension values that was added. Rollups over
+ * any subset of the dimensions, such as a cube or SQL grouping sets, are
+ * computed on demand by unioning those HLLs, so the records are only read
+ * once.
+ *
+ * NOTE:  an Aggregator is not safe for concurrent use.
+ */
+type Aggregator struct {
+	// an EMPTY HLL with the parameters of the groups
+	params     *Hll
+	dimensions []string
+	// the groups of all dimensions by their encoded tuple, see #groupKey()
+	groups map[string]*aggregateGroup
+}
+
+/**
+ * A tuple of dimension values and the HLL of its records.
+ */
+type aggregateGroup struct {
+	values []string
+	hll    *Hll
+}
+
+/**
+ * A row of a rollup: the values of the dimensions that were grouped by, the
+ * distinct count of the records with those values and their HLL in the
+ * format of Hll#ToBytes().
+ */
+type AggregateRow struct {
+	Values      map[string]string
+	Cardinality uint
+	Sketch      []byte
+}
+
+/**
+ * @param prototype the HLL whose parameters the groups use. It is not
+ *        modified and its contents are ignored.
+ * @param dimensions the names of the dimensions, in the order in which
+ *        their values are passed to #Add().
+ */
+func NewAggregator(prototype *Hll, dimensions ...string) (*Aggregator, error) {
+	for i, dimension := range dimensions {
+		if slices.Contains(dimensions[:i], dimension) {
+			return nil, fmt.Errorf("duplicate dimension %q", dimension)
+		}
+	}
+
+	this := &Aggregator{}
+	this.params = prototype.emptyCopy()
+	this.dimensions = slices.Clone(dimensions)
+	this.groups = make(map[string]*aggregateGroup)
+	return this, nil
+}
+
+/**
+ * Encodes a tuple of values as a map key, each value prefixed by its length
+ * so that any strings can be told apart.
+ */
+func groupKey(values []string) string {
+	var key []byte
+	for _, value := range values {
+		key = binary.AppendUvarint(key, uint64(len(value)))
+		key = append(key, value...)
+	}
+	return string(key)
+}
+
+/**
+ * @return the group of the values, created if necessary.
+ */
+func (this *Aggregator) group(values []string) (*aggregateGroup, error) {
+	if len(values) != len(this.dimensions) {
+		return nil, fmt.Errorf("%d values for %d dimensions", len(values), len(this.dimensions))
+	}
+	key := groupKey(values)
+	group, ok := this.groups[key]
+	if !ok {
+		group = &aggregateGroup{slices.Clone(values), this.params.emptyCopy()}
+		this.groups[key] = group
+	}
+	return group, nil
+}
+
+/**
+ * Adds a record.
+ *
+ * @param values the values of the dimensions of the record, in the order of
+ *        #NewAggregator().
+ * @param rawValue the id of the record, already hashed, see Hll#Add().
+ */
+func (this *Aggregator) Add(values []string, rawValue uint64) error {
+	group, err := this.group(values)
+	if err != nil {
+		return err
+	}
+	group.hll.Add(rawValue)
+	return nil
+}
+
+/**
+ * Unions the HLL of records with the given dimension values, e.g. one that
+ * was computed elsewhere.
+ */
+func (this *Aggregator) Merge(values []string, other *Hll) error {
+	if err := this.params.checkCompatible(other); err != nil {
+		return err
+	}
+	group, err := this.group(values)
+	if err != nil {
+		return err
+	}
+	group.hll.Union(other)
+	return nil
+}
+
+/**
+ * @return the rollups over every subset of the dimensions, from all of
+ *         them to none (the grand total), see #GroupingSets().
+ */
+func (this *Aggregator) Cube() []AggregateRow {
+	sets := make([][]string, 0, 1<<len(this.dimensions))
+	for mask := (1 << len(this.dimensions)) - 1; mask >= 0; mask-- {
+		var set []string
+		for i, dimension := range this.dimensions {
+			if mask&(1<<i) != 0 {
+				set = append(set, dimension)
+			}
+		}
+		sets = append(sets, set)
+	}
+	// NOTE:  the sets are valid by construction
+	rows, _ := this.GroupingSets(sets...)
+	return rows
+}
+
+/**
+ * Computes the rollups over the given sets of dimensions, like the
+ * GROUPING SETS of SQL.
+ *
+ * Every rollup is unioned from the smallest one computed before that groups
+ * by a superset of its dimensions, or from the groups of all dimensions.
+ *
+ * @param sets the names of the dimensions of each rollup. An empty set is
+ *        the grand total.
+ * @return the rows of the rollups in the order of <code>sets</code>, and
+ *         those of a rollup ordered by their values.
+ */
+func (this *Aggregator) GroupingSets(sets ...[]string) ([]AggregateRow, error) {
+	// the indices of the dimensions of every set
+	indices := make([][]int, len(sets))
+	for i, set := range sets {
+		for _, dimension := range set {
+			index := slices.Index(this.dimensions, dimension)
+			if index < 0 {
+				return nil, fmt.Errorf("unknown dimension %q", dimension)
+			}
+			if slices.Contains(indices[i], index) {
+				return nil, fmt.Errorf("duplicate dimension %q", dimension)
+			}
+			indices[i] = append(indices[i], index)
+		}
+		slices.Sort(indices[i])
+	}
+
+	// NOTE:  sets with more dimensions are computed first so that they can
+	//        be the sources of those with fewer
+	order := make([]int, len(sets))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return len(indices[b]) - len(indices[a])
+	})
+
+	all := make([]int, len(this.dimensions))
+	for i := range all {
+		all[i] = i
+	}
+	rollups := make([]map[string]*aggregateGroup, len(sets))
+	for _, i := range order {
+		source, sourceIndices := this.groups, all
+		for _, j := range order {
+			if rollups[j] != nil && len(rollups[j]) < len(source) && isSubset(indices[i], indices[j]) {
+				source, sourceIndices = rollups[j], indices[j]
+			}
+		}
+		rollups[i] = this.rollup(source, sourceIndices, indices[i])
+	}
+
+	var rows []AggregateRow
+	for i, rollup := range rollups {
+		groups := make([]*aggregateGroup, 0, len(rollup))
+		for _, group := range rollup {
+			groups = append(groups, group)
+		}
+		slices.SortFunc(groups, func(a, b *aggregateGroup) int {
+			return slices.Compare(a.values, b.values)
+		})
+		for _, group := range groups {
+			row := AggregateRow{Values: make(map[string]string, len(indices[i]))}
+			for k, index := range indices[i] {
+				row.Values[this.dimensions[index]] = group.values[k]
+			}
+			row.Cardinality = group.hll.Cardinality()
+			row.Sketch = group.hll.ToBytes()
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+/**
+ * @return whether the sorted <code>subset</code> is contained in the sorted
+ *         <code>set</code>.
+ */
+func isSubset(subset []int, set []int) bool {
+	for _, index := range subset {
+		if _, found := slices.BinarySearch(set, index); !found {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * Unions groups by a subset of their dimensions.
+ *
+ * @param groups groups by the dimensions <code>groupIndices</code>.
+ * @param indices the dimensions to group by, a subset of
+ *        <code>groupIndices</code>.
+ * @return the groups by the dimensions <code>indices</code>. Their HLLs are
+ *         never shared with <code>groups</code>.
+ */
+func (this *Aggregator) rollup(groups map[string]*aggregateGroup, groupIndices []int, indices []int) map[string]*aggregateGroup {
+	// the positions of the dimensions within the values of the groups
+	positions := make([]int, len(indices))
+	for i, index := range indices {
+		positions[i], _ = slices.BinarySearch(groupIndices, index)
+	}
+
+	rollup := make(map[string]*aggregateGroup)
+	values := make([]string, len(indices))
+	for _, group := range groups {
+		for i, position := range positions {
+			values[i] = group.values[position]
+		}
+		key := groupKey(values)
+		target, ok := rollup[key]
+		if !ok {
+			target = &aggregateGroup{slices.Clone(values), this.params.emptyCopy()}
+			rollup[key] = target
+		}
+		target.hll.Union(group.hll)
+	}
+	return rollup
+}
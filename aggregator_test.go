@@ -0,0 +1,85 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"maps"
+	"math/rand"
+	"testing"
+)
+
+func TestAggregator(t *testing.T) {
+	prototype, _ := NewHll5(11, 5, 0, true, EMPTY)
+	aggregator, err := NewAggregator(prototype, "country", "device", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type record struct {
+		values   []string
+		rawValue uint64
+	}
+	r := rand.New(rand.NewSource(1))
+	countries, devices, apps := []string{"de", "fr", "us", "jp"}, []string{"ios", "android"}, []string{"a", "b", "c"}
+	records := make([]record, 20000)
+	for i := range records {
+		records[i] = record{[]string{countries[r.Intn(4)], devices[r.Intn(2)], apps[r.Intn(3)]}, r.Uint64()}
+		if err := aggregator.Add(records[i].values, records[i].rawValue); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows := aggregator.Cube()
+	// (4 + 1) * (2 + 1) * (3 + 1) groups over all subsets
+	if len(rows) != 60 {
+		t.Errorf("%d rows, expected 60", len(rows))
+	}
+	for _, row := range rows {
+		expected := prototype.emptyCopy()
+		for _, record := range records {
+			if row.Values["country"] == "" || row.Values["country"] == record.values[0] {
+				if row.Values["device"] == "" || row.Values["device"] == record.values[1] {
+					if row.Values["app"] == "" || row.Values["app"] == record.values[2] {
+						expected.Add(record.rawValue)
+					}
+				}
+			}
+		}
+		sketch, err := NewHllFromBytes(row.Sketch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !maps.Equal(registersOf(sketch), registersOf(expected)) || row.Cardinality != expected.Cardinality() {
+			t.Errorf("%v: cardinality %d, expected %d", row.Values, row.Cardinality, expected.Cardinality())
+		}
+	}
+
+	rows, err = aggregator.GroupingSets([]string{"app"}, []string{"app", "country"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3+12+1 || rows[0].Values["app"] != "a" || len(rows[15].Values) != 0 {
+		t.Errorf("grouping sets not in order: %v", rows)
+	}
+
+	if _, err := aggregator.GroupingSets([]string{"os"}); err == nil {
+		t.Errorf("unknown dimension accepted")
+	}
+	if err := aggregator.Add([]string{"de"}, 1); err == nil {
+		t.Errorf("record with missing dimensions accepted")
+	}
+}
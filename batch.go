@@ -0,0 +1,138 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"fmt"
+	"iter"
+)
+
+const (
+	// number of values that #AddSeq() buffers for #AddMany()
+	ADD_SEQ_BATCH_SIZE = 4096
+)
+
+/**
+ * Adds all <code>rawValues</code> to the HLL. The result is the same as
+ * calling #Add() for each of them in order, including the points at which
+ * the HLL is promoted, but the storage is sized for the batch up front and
+ * every representation is filled by a loop of its own.
+ *
+ * @param rawValues the values to be added, already hashed, see #Add().
+ */
+func (this *Hll) AddMany(rawValues []uint64) {
+	for len(rawValues) > 0 {
+		switch this.hllType {
+		case EMPTY:
+			this.Add(rawValues[0])
+			rawValues = rawValues[1:]
+		case EXPLICIT:
+			rawValues = this.addManyExplicit(rawValues)
+		case SPARSE:
+			rawValues = this.addManySparse(rawValues)
+		case FULL:
+			this.addManyProbabilistic(rawValues)
+			return
+		default:
+			panic(fmt.Sprintf("Unsupported HLL type %d", this.hllType))
+		}
+	}
+}
+
+/**
+ * Adds all values of <code>seq</code> to the HLL, in batches, see
+ * #AddMany().
+ */
+func (this *Hll) AddSeq(seq iter.Seq[uint64]) {
+	batch := make([]uint64, 0, ADD_SEQ_BATCH_SIZE)
+	for rawValue := range seq {
+		batch = append(batch, rawValue)
+		if len(batch) == cap(batch) {
+			this.AddMany(batch)
+			batch = batch[:0]
+		}
+	}
+	this.AddMany(batch)
+}
+
+/**
+ * Adds values to an EXPLICIT HLL until it is promoted.
+ *
+ * @return the values that were not added yet.
+ */
+func (this *Hll) addManyExplicit(rawValues []uint64) []uint64 {
+	explicitStorage := this.explicitStorage
+	// NOTE:  the set holds at most one more than the threshold, at which
+	//        point it is promoted
+	explicitStorage.ensureCapacity(min(explicitStorage.Size()+uint(len(rawValues)), this.explicitThreshold+1))
+
+	for i, rawValue := range rawValues {
+		this.addRawExplicit(rawValue)
+		if explicitStorage.Size() > this.explicitThreshold {
+			this.promoteExplicit()
+			return rawValues[i+1:]
+		}
+	}
+	return nil
+}
+
+/**
+ * Adds values to a SPARSE HLL until it is promoted.
+ *
+ * @return the values that were not added yet.
+ */
+func (this *Hll) addManySparse(rawValues []uint64) []uint64 {
+	sparseStorage := this.sparseProbabilisticStorage
+
+	log2m, mBitsMask, pwMaxMask := this.sparseLog2m(), this.sparseMBitsMask, this.pwMaxMask /*for performance*/
+	for i, rawValue := range rawValues {
+		substreamValue := rawValue >> log2m
+		if substreamValue == 0 {
+			continue
+		}
+		j := uint32(rawValue & mBitsMask)
+		p_w := byte(1 + leastSignificantBit(substreamValue|pwMaxMask))
+		if this.checkpointed && p_w > sparseStorage.get(j) {
+			this.markDirty(j & uint32(this.mBitsMask))
+		}
+		sparseStorage.setMax(j, p_w)
+		if sparseStorage.sizeExceeds(this.sparseThreshold) {
+			this.promoteSparse()
+			return rawValues[i+1:]
+		}
+	}
+	return nil
+}
+
+/**
+ * Adds values to a FULL HLL.
+ */
+func (this *Hll) addManyProbabilistic(rawValues []uint64) {
+	probabilisticStorage := this.probabilisticStorage
+	log2m, mBitsMask, pwMaxMask := this.log2m, this.mBitsMask, this.pwMaxMask /*for performance*/
+	for _, rawValue := range rawValues {
+		substreamValue := rawValue >> log2m
+		if substreamValue == 0 {
+			continue
+		}
+		p_w := byte(1 + leastSignificantBit(substreamValue|pwMaxMask))
+		if previous := byte(probabilisticStorage.getAndSetMaxRegister(rawValue&mBitsMask, uint64(p_w))); p_w > previous {
+			this.registerChanged(previous, p_w)
+			this.markDirty(uint32(rawValue & mBitsMask))
+		}
+	}
+}
@@ -19,6 +19,11 @@
 
 package hll
 
+import (
+    "sync"
+    "sync/atomic"
+)
+
 const(
     // rather than doing division to determine how a bit index fits into 64bit
     // words (i.e. longs), bit shifting is used
@@ -107,8 +112,18 @@ func (this *BitVector) setRegister(registerIndex uint64, value uint64) {
      * @see #setRegister(long, long)
      * @see java.lang.Math#max(long, long)
      */
-// NOTE:  if this changes then setRegister() must change
 func (this *BitVector)setMaxRegister(registerIndex uint64, value uint64) bool {
+    return (value >= this.getAndSetMaxRegister(registerIndex, value))
+}
+
+/**
+     * Same as #setMaxRegister() but returns the value the register held before,
+     * so that callers can account for the change.
+     *
+     * @return the previous value of the register.
+     */
+// NOTE:  if this changes then setRegister() must change
+func (this *BitVector) getAndSetMaxRegister(registerIndex uint64, value uint64) uint64 {
     bitIndex := registerIndex * this.registerWidth
     firstWordIndex := bitIndex >> LOG2_BITS_PER_WORD/*aka (bitIndex / BITS_PER_WORD)*/
     secondWordIndex := (bitIndex + this.registerWidth - 1) >> LOG2_BITS_PER_WORD/*see above*/
@@ -142,7 +157,109 @@ func (this *BitVector)setMaxRegister(registerIndex uint64, value uint64) bool {
         }
     } /* else -- the register value is greater (or equal) so nothing needs to be done */
 
-    return (value >= registerValue)
+    return registerValue
+}
+
+/**
+     * Same as #getAndSetMaxRegister() but safe to call concurrently with itself and
+     * with #cloneAtomic(). The words are updated by compare-and-swap so
+     * that concurrent updates of other registers of the same word are not
+     * lost.
+     *
+     * @param  registerIndex the index of the register whose value is to be set.
+     * @param  value the value to set in the register if and only if this value
+     *         is greater than the current value in the register
+     * @param  spanLock held while a register that spans two words is updated,
+     *         as those cannot be swapped at once. It must be the same for all
+     *         updates of the register.
+     */
+func (this *BitVector) setMaxRegisterAtomic(registerIndex uint64, value uint64, spanLock sync.Locker) {
+    bitIndex := registerIndex * this.registerWidth
+    firstWordIndex := bitIndex >> LOG2_BITS_PER_WORD/*aka (bitIndex / BITS_PER_WORD)*/
+    secondWordIndex := (bitIndex + this.registerWidth - 1) >> LOG2_BITS_PER_WORD/*see above*/
+    bitRemainder := bitIndex & BITS_PER_WORD_MASK/*aka (bitIndex % BITS_PER_WORD)*/
+
+    words := this.words/*for convenience/performance*/
+    if firstWordIndex == secondWordIndex {
+        for {
+            word := atomic.LoadUint64(&words[firstWordIndex])
+            if value <= (word >> bitRemainder) & this.registerMask {
+                return
+            }
+            updated := (word &^ (this.registerMask << bitRemainder)) | (value << bitRemainder)
+            if atomic.CompareAndSwapUint64(&words[firstWordIndex], word, updated) {
+                return
+            }
+        }
+    }
+
+    /* else -- register spans words */
+    // NOTE:  only updates of this register change its bits and they are
+    //        serialized by the lock, so the register cannot change between
+    //        the read and the swaps below. The swaps only retry because of
+    //        updates of the other registers in the words.
+    spanLock.Lock()
+    defer spanLock.Unlock()
+
+    registerValue := ((atomic.LoadUint64(&words[firstWordIndex]) >> bitRemainder) | (atomic.LoadUint64(&words[secondWordIndex]) << (BITS_PER_WORD - bitRemainder))) & this.registerMask
+    if value <= registerValue {
+        return
+    }
+    for {
+        word := atomic.LoadUint64(&words[firstWordIndex])
+        updated := (word & ((1 << bitRemainder) - 1)) | (value << bitRemainder)
+        if atomic.CompareAndSwapUint64(&words[firstWordIndex], word, updated) {
+            break
+        }
+    }
+    for {
+        word := atomic.LoadUint64(&words[secondWordIndex])
+        updated := (word &^ (this.registerMask >> (BITS_PER_WORD - bitRemainder))) | (value >> (BITS_PER_WORD - bitRemainder))
+        if atomic.CompareAndSwapUint64(&words[secondWordIndex], word, updated) {
+            break
+        }
+    }
+}
+
+/**
+     * Reads a register that is updated concurrently by
+     * #setMaxRegisterAtomic().
+     *
+     * @param  registerIndex the index of the register to read
+     * @param  spanLock the lock that the updates of the register hold, which
+     *         is taken if the register spans two words so that they are read
+     *         consistently.
+     * @return the value at the specified register index
+     */
+func (this *BitVector) getRegisterAtomic(registerIndex uint64, spanLock sync.Locker) uint64 {
+    bitIndex := registerIndex * this.registerWidth
+    firstWordIndex := bitIndex >> LOG2_BITS_PER_WORD/*aka (bitIndex / BITS_PER_WORD)*/
+    secondWordIndex := (bitIndex + this.registerWidth - 1) >> LOG2_BITS_PER_WORD/*see above*/
+    bitRemainder := bitIndex & BITS_PER_WORD_MASK/*aka (bitIndex % BITS_PER_WORD)*/
+
+    if firstWordIndex == secondWordIndex {
+        return (atomic.LoadUint64(&this.words[firstWordIndex]) >> bitRemainder) & this.registerMask
+    }
+
+    /* else -- register spans words */
+    spanLock.Lock()
+    defer spanLock.Unlock()
+    return ((atomic.LoadUint64(&this.words[firstWordIndex]) >> bitRemainder) | (atomic.LoadUint64(&this.words[secondWordIndex]) << (BITS_PER_WORD - bitRemainder))) & this.registerMask
+}
+
+/**
+     * Creates a copy of this vector while it is updated by
+     * #setMaxRegisterAtomic(). Each word is loaded atomically and the
+     * registers spanning two words are read under their lock, as loading
+     * the two words separately could combine the high bits of one value with
+     * the low bits of another, see #unionWithAtomic().
+     *
+     * @param  spanLock returns the lock that the updates of a register hold.
+     */
+func (this *BitVector) cloneAtomic(spanLock func(registerIndex uint64) sync.Locker) *BitVector {
+    c := NewBitVector(uint(this.registerWidth), this.count)
+    c.unionWithAtomic(this, spanLock)
+    return c
 }
 
 /**
@@ -156,6 +273,13 @@ func (this *BitVector) Clone() *BitVector {
     return c
 }
 
+/**
+     * @return the number of bytes held by the backing words of this vector.
+     */
+func (this *BitVector) sizeInBytes() uint {
+    return uint(len(this.words)) * BYTES_PER_WORD
+}
+
 /**
      * @param  registerIndex the index of the register whose value is to be
      *         retrieved.  This cannot be negative.
@@ -183,45 +307,28 @@ func (this *BitVector) getRegister(registerIndex uint64) uint64 {
      *         with index zero. This will never be <code>null</code>.
      */
 func (this *BitVector)sum() (float64, int) {
-    registerWidth := this.registerWidth;
-    words := this.words;
-    registerMask := this.registerMask;
-
-    // register setup
-    wordIndex := 0
-    remainingWordBits := uint64(BITS_PER_WORD)
-    word := words[wordIndex];
+    counts := make([]uint32, 1 << this.registerWidth)
+    this.histogram(counts)
 
     // compute the "indicator function" -- sum(2^(-M[j])) where M[j] is the
     // 'j'th register value
     sum := float64(0)
-    numberOfZeroes := 0/*"V" in the paper*/
-    var register uint64
-    for registerIndex := uint(0);registerIndex < this.count; registerIndex +=1{
-        if(remainingWordBits >= registerWidth) {
-            register = word & registerMask;
-
-            // shift to the next register
-            word >>= registerWidth;
-            remainingWordBits -= registerWidth;
-        } else { /*insufficient bits remaining in current word*/
-            wordIndex++/*move to the next word*/;
-
-            register = (word | (words[wordIndex] << remainingWordBits)) & registerMask;
-
-            // shift to the next partial register (word)
-            word = words[wordIndex] >> (registerWidth - remainingWordBits);
-            remainingWordBits += BITS_PER_WORD - registerWidth;
-        }
-        sum += 1.0 / float64(uint64(1) << register)
-        if(register == 0){
-            numberOfZeroes += 1
-        }
+    for register, count := range counts {
+        sum += float64(count) / float64(uint64(1) << uint(register))
     }
 
-    return sum, numberOfZeroes
+    return sum, int(counts[0])/*"V" in the paper*/
 }
 
+/**
+ * Iterates over all registers of a BitVector, one at a time.
+ *
+ * NOTE:  this is not specialized like the kernels of bit_vector_kernels.go:
+ *        it already extracts each register with a shift and a mask, and
+ *        #ToBytes(), its remaining user, writes every register anyway. The
+ *        callers that only need the non-zero registers use
+ *        BitVector#forEachNonZero() instead, which skips zero words.
+ */
 type BitVectorIterator struct {
     bitVector *BitVector
     // register setup
@@ -0,0 +1,257 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+/**
+ * The layout of the registers within one word of a BitVector. As registers
+ * are packed back to back the layout repeats every registerWidth /
+ * gcd(registerWidth, 64) words.
+ */
+type registerLanes struct {
+	// the bits of the registers that lie entirely within the word
+	mask uint64
+	// the top bit of each of those registers
+	high uint64
+	// bit index, within the word, of the register that starts in this word
+	// and ends in the next one, 0 if there is none
+	spanBit uint64
+}
+
+var (
+	// REGISTER_LANES[w] holds the layout of the words of a period of a
+	// BitVector with registers of width w
+	REGISTER_LANES = [MAXIMUM_REGWIDTH_PARAM + 1][]registerLanes{}
+)
+
+func init() {
+	for registerWidth := uint64(MINIMUM_REGWIDTH_PARAM); registerWidth <= MAXIMUM_REGWIDTH_PARAM; registerWidth++ {
+		period := registerWidth / uint64(1<<bits.TrailingZeros64(registerWidth)) /*aka registerWidth / gcd(registerWidth, 64)*/
+		lanes := make([]registerLanes, period)
+		for wordIndex := range lanes {
+			firstBit := uint64(wordIndex) * BITS_PER_WORD
+			// the first register starting in this word
+			bitIndex := (firstBit + registerWidth - 1) / registerWidth * registerWidth
+			for ; bitIndex+registerWidth <= firstBit+BITS_PER_WORD; bitIndex += registerWidth {
+				lanes[wordIndex].mask |= ((1 << registerWidth) - 1) << (bitIndex - firstBit)
+				lanes[wordIndex].high |= 1 << (bitIndex - firstBit + registerWidth - 1)
+			}
+			if bitIndex < firstBit+BITS_PER_WORD {
+				lanes[wordIndex].spanBit = bitIndex - firstBit
+			}
+		}
+		REGISTER_LANES[registerWidth] = lanes
+	}
+}
+
+/**
+ * Computes the register-wise maximum of the registers of two words that lie
+ * entirely within the words, all at once.
+ *
+ * For the lower registerWidth - 1 bits of every register, (x | high) -
+ * (y &^ high) leaves the top bit of the register set if and only if x >= y,
+ * without borrowing from the neighbouring register. The top bits of x and y
+ * then decide the comparison where they differ.
+ *
+ * @param  lanes the layout of the words.
+ * @return the maxima, with all bits outside lanes#mask zero.
+ */
+func maxRegisters(x uint64, y uint64, lanes *registerLanes, registerWidth uint64, registerMask uint64) uint64 {
+	x, y = x&lanes.mask, y&lanes.mask
+	lowGreaterOrEqual := (x | lanes.high) - (y &^ lanes.high)
+	greaterOrEqual := ((x &^ y) | (^(x ^ y) & lowGreaterOrEqual)) & lanes.high
+	// spread the top bit over the whole register, which cannot carry as
+	// every product is at most the register mask
+	selectX := (greaterOrEqual >> (registerWidth - 1)) * registerMask
+	return (x & selectX) | (y &^ selectX)
+}
+
+/**
+ * Sets every register of this vector to the maximum of itself and the
+ * register of the same index in <code>other</code>. This is equivalent to
+ * but much more performant than:<p/>
+ *
+ * <pre>for i := 0; i < count; i++ { vector.setMaxRegister(i, other.getRegister(i)) }</pre>
+ *
+ * @param other a vector with the same register width and count. This cannot
+ *        be <code>nil</code>.
+ */
+func (this *BitVector) unionWith(other *BitVector) {
+	registerWidth, registerMask := this.registerWidth, this.registerMask /*for performance*/
+	words, otherWords := this.words, other.words[:len(this.words)]
+	lanes := REGISTER_LANES[registerWidth]
+
+	if len(lanes) == 1 {
+		// 1, 2, 4 and 8 bits: no register spans words
+		lane := &lanes[0]
+		for wordIndex, word := range words {
+			if otherWord := otherWords[wordIndex]; otherWord != word {
+				words[wordIndex] = maxRegisters(word, otherWord, lane, registerWidth, registerMask)
+			}
+		}
+		return
+	}
+
+	for wordIndex, word := range words {
+		otherWord := otherWords[wordIndex]
+		if otherWord == word {
+			continue
+		}
+		lane := &lanes[wordIndex%len(lanes)]
+		words[wordIndex] = maxRegisters(word, otherWord, lane, registerWidth, registerMask) | (word &^ lane.mask)
+	}
+
+	// NOTE:  the spanning registers were left as they were above
+	for wordIndex := range words {
+		lane := &lanes[wordIndex%len(lanes)]
+		if lane.spanBit == 0 {
+			continue
+		}
+		registerIndex := (uint64(wordIndex)*BITS_PER_WORD + lane.spanBit) / registerWidth
+		if registerIndex >= uint64(this.count) {
+			break
+		}
+		this.setMaxRegister(registerIndex, other.getRegister(registerIndex))
+	}
+}
+
+/**
+ * Like #unionWith(), but <code>other</code> may be updated concurrently by
+ * BitVector#setMaxRegisterAtomic(). Its words are loaded atomically and the
+ * registers spanning two words are read under their lock, see
+ * #getRegisterAtomic().
+ *
+ * @param spanLock returns the lock that the updates of a register hold.
+ */
+func (this *BitVector) unionWithAtomic(other *BitVector, spanLock func(registerIndex uint64) sync.Locker) {
+	registerWidth, registerMask := this.registerWidth, this.registerMask /*for performance*/
+	words, otherWords := this.words, other.words[:len(this.words)]
+	lanes := REGISTER_LANES[registerWidth]
+
+	for wordIndex, word := range words {
+		otherWord := atomic.LoadUint64(&otherWords[wordIndex])
+		if otherWord == word {
+			continue
+		}
+		lane := &lanes[wordIndex%len(lanes)]
+		words[wordIndex] = maxRegisters(word, otherWord, lane, registerWidth, registerMask) | (word &^ lane.mask)
+	}
+	if len(lanes) == 1 {
+		// 1, 2, 4 and 8 bits: no register spans words
+		return
+	}
+
+	// NOTE:  the spanning registers were left as they were above
+	for wordIndex := range words {
+		lane := &lanes[wordIndex%len(lanes)]
+		if lane.spanBit == 0 {
+			continue
+		}
+		registerIndex := (uint64(wordIndex)*BITS_PER_WORD + lane.spanBit) / registerWidth
+		if registerIndex >= uint64(this.count) {
+			break
+		}
+		this.setMaxRegister(registerIndex, other.getRegisterAtomic(registerIndex, spanLock(registerIndex)))
+	}
+}
+
+/**
+ * Calls <code>fn</code> for every non-zero register of this vector, in
+ * ascending index order. Words without a set register inside are skipped
+ * at once, unlike with a BitVectorIterator.
+ */
+func (this *BitVector) forEachNonZero(fn func(registerIndex uint64, registerValue uint64)) {
+	registerWidth, registerMask := this.registerWidth, this.registerMask /*for performance*/
+	count := uint64(this.count)
+	lanes := REGISTER_LANES[registerWidth]
+
+	for wordIndex, word := range this.words {
+		lane := &lanes[wordIndex%len(lanes)]
+		firstBit := uint64(wordIndex) * BITS_PER_WORD
+		if word&lane.mask != 0 {
+			// the first register starting in this word
+			registerIndex := (firstBit + registerWidth - 1) / registerWidth
+			for bitIndex := registerIndex*registerWidth - firstBit; bitIndex+registerWidth <= BITS_PER_WORD; bitIndex += registerWidth {
+				// NOTE:  the bits past the last register are zero
+				if registerValue := (word >> bitIndex) & registerMask; registerValue != 0 {
+					fn(registerIndex, registerValue)
+				}
+				registerIndex++
+			}
+		}
+		if lane.spanBit != 0 {
+			registerIndex := (firstBit + lane.spanBit) / registerWidth
+			if registerIndex >= count {
+				return
+			}
+			if registerValue := this.getRegister(registerIndex); registerValue != 0 {
+				fn(registerIndex, registerValue)
+			}
+		}
+	}
+}
+
+/**
+ * Counts the registers of this vector per register value.
+ *
+ * @param counts the counts to add to, indexed by register value. It must
+ *        hold at least 2^registerWidth counts.
+ */
+func (this *BitVector) histogram(counts []uint32) {
+	counts = counts[:1<<this.registerWidth]
+	words := this.words /*for convenience/performance*/
+	switch this.registerWidth {
+	case 8:
+		for _, word := range words {
+			for shift := 0; shift < BITS_PER_WORD; shift += 8 {
+				counts[byte(word>>shift)]++
+			}
+		}
+	case 4:
+		for _, word := range words {
+			for shift := 0; shift < BITS_PER_WORD; shift += 4 {
+				counts[(word>>shift)&0xf]++
+			}
+		}
+	default:
+		registerWidth, registerMask := this.registerWidth, this.registerMask
+		for wordIndex, word := range words {
+			lane := &REGISTER_LANES[registerWidth][wordIndex%len(REGISTER_LANES[registerWidth])]
+			// NOTE:  zero words are common and need no per-register work
+			if word&lane.mask == 0 {
+				counts[0] += uint32(bits.OnesCount64(lane.mask) / int(registerWidth))
+			} else {
+				for lanes := lane.mask; lanes != 0; {
+					shift := bits.TrailingZeros64(lanes)
+					counts[(word>>shift)&registerMask]++
+					lanes &^= registerMask << shift
+				}
+			}
+			if lane.spanBit != 0 && wordIndex+1 < len(words) {
+				counts[((word>>lane.spanBit)|(words[wordIndex+1]<<(BITS_PER_WORD-lane.spanBit)))&registerMask]++
+			}
+		}
+	}
+
+	// NOTE:  the padding of the last word was counted as unset registers
+	counts[0] -= uint32(uint64(len(words))*BITS_PER_WORD/this.registerWidth) - uint32(this.count)
+}
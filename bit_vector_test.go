@@ -0,0 +1,130 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func randBitVector(r *rand.Rand, width uint, count uint) *BitVector {
+	vector := NewBitVector(width, count)
+	for i := uint64(0); i < uint64(count); i++ {
+		// mostly small values as in a real HLL, and some zero runs
+		if r.Intn(4) != 0 {
+			vector.setRegister(i, uint64(r.Intn(1<<width)))
+		}
+	}
+	return vector
+}
+
+// the register-at-a-time union that unionWith() replaces
+func unionScalar(this *BitVector, other *BitVector) {
+	for i := uint64(0); i < uint64(this.count); i++ {
+		this.setMaxRegister(i, other.getRegister(i))
+	}
+}
+
+func histogramScalar(vector *BitVector) []uint32 {
+	counts := make([]uint32, 1<<vector.registerWidth)
+	it := NewBitVectorIterator(vector)
+	for it.HasNext() {
+		counts[it.Next()]++
+	}
+	return counts
+}
+
+func TestBitVectorKernels(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for width := uint(MINIMUM_REGWIDTH_PARAM); width <= MAXIMUM_REGWIDTH_PARAM; width++ {
+		for _, count := range []uint{1, 7, 16, 63, 64, 65, 1000, 2048} {
+			a, b := randBitVector(r, width, count), randBitVector(r, width, count)
+
+			expected := a.Clone()
+			unionScalar(expected, b)
+			a.unionWith(b)
+			if !slices.Equal(a.words, expected.words) {
+				t.Errorf("width %d, count %d: unionWith differs from the scalar union", width, count)
+			}
+
+			counts := make([]uint32, 1<<width)
+			a.histogram(counts)
+			if expected := histogramScalar(a); !slices.Equal(counts, expected) {
+				t.Errorf("width %d, count %d: histogram %v, expected %v", width, count, counts, expected)
+			}
+
+			// mostly zero words, as in a FULL HLL that was just promoted
+			sparse := NewBitVector(width, count)
+			for i := uint(0); i < count/16+1; i++ {
+				sparse.setRegister(uint64(r.Intn(int(count))), uint64(r.Intn(1<<width)))
+			}
+			for _, vector := range []*BitVector{a, sparse} {
+				var registers, expected []uint64
+				vector.forEachNonZero(func(registerIndex uint64, registerValue uint64) {
+					registers = append(registers, registerIndex, registerValue)
+				})
+				it := NewBitVectorIterator(vector)
+				for registerIndex := uint64(0); it.HasNext(); registerIndex++ {
+					if registerValue := it.Next(); registerValue != 0 {
+						expected = append(expected, registerIndex, registerValue)
+					}
+				}
+				if !slices.Equal(registers, expected) {
+					t.Errorf("width %d, count %d: forEachNonZero differs from the iterator", width, count)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkBitVectorUnion(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	for _, width := range []uint{4, 5, 6, 8} {
+		x, y := randBitVector(r, width, 1<<14), randBitVector(r, width, 1<<14)
+		b.Run(fmt.Sprintf("scalar/regwidth=%d", width), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				unionScalar(x.Clone(), y)
+			}
+		})
+		b.Run(fmt.Sprintf("swar/regwidth=%d", width), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				x.Clone().unionWith(y)
+			}
+		})
+	}
+}
+
+func BenchmarkBitVectorHistogram(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	for _, width := range []uint{4, 5, 6, 8} {
+		x := randBitVector(r, width, 1<<14)
+		b.Run(fmt.Sprintf("iterator/regwidth=%d", width), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				histogramScalar(x)
+			}
+		})
+		b.Run(fmt.Sprintf("kernel/regwidth=%d", width), func(b *testing.B) {
+			counts := make([]uint32, 1<<width)
+			for i := 0; i < b.N; i++ {
+				clear(counts)
+				x.histogram(counts)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/l0vest0rm/hll"
+)
+
+// NOTE:  the Redis and DataSketches formats are converted register by
+//        register. Both index registers by low bits of a hash and set them
+//        to the length of a run of zero bits of it plus one, so the
+//        estimates carry over, but
+//        the sketches only union with natively built ones if the values
+//        were hashed the same way.
+
+const (
+	// Redis' HYLL string: the magic, the encoding, 3 unused bytes and the
+	// cached cardinality, followed by the registers
+	REDIS_MAGIC             = "HYLL"
+	REDIS_HEADER_BYTE_COUNT = 16
+	REDIS_DENSE             = 0
+	REDIS_SPARSE            = 1
+	REDIS_LOG2M             = 14
+	REDIS_REGWIDTH          = 6
+
+	// DataSketches' HLL preamble of an HLL_8 sketch in HLL mode
+	DATASKETCHES_PREAMBLE_INTS = 10
+	DATASKETCHES_SER_VER       = 1
+	DATASKETCHES_FAMILY_ID     = 7
+	DATASKETCHES_HLL_MODE      = 2
+	DATASKETCHES_HLL_8         = 2
+	DATASKETCHES_EMPTY_FLAG    = 4
+	// the HIP accumulator of a converted sketch is meaningless
+	DATASKETCHES_OUT_OF_ORDER_FLAG = 16
+	DATASKETCHES_HEADER_BYTE_COUNT = 40
+	DATASKETCHES_MINIMUM_LOG2M     = 4
+	DATASKETCHES_MAXIMUM_LOG2M     = 21
+	DATASKETCHES_MAXIMUM_REGISTER  = 63
+	// the width of the registers of a sketch read from DataSketches
+	DATASKETCHES_REGWIDTH = 6
+)
+
+var formats = []string{"raw", "hex", "base64", "redis", "datasketches"}
+
+/**
+ * Serializes <code>h</code> in the given format.
+ */
+func encode(h *hll.Hll, format string) ([]byte, error) {
+	switch format {
+	case "raw":
+		return h.ToBytes(), nil
+	case "hex":
+		return []byte(h.ToHexString() + "\n"), nil
+	case "base64":
+		return []byte(base64.StdEncoding.EncodeToString(h.ToBytes()) + "\n"), nil
+	case "redis":
+		return encodeRedis(h)
+	case "datasketches":
+		return encodeDataSketches(h)
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(formats, ", "))
+	}
+}
+
+/**
+ * Deserializes an HLL in the given format, or in the one that the data
+ * looks like for "auto".
+ *
+ * @return the HLL and the format it was read from.
+ */
+func decode(data []byte, format string) (*hll.Hll, string, error) {
+	if format == "auto" {
+		format = detect(data)
+	}
+	var h *hll.Hll
+	var err error
+	switch format {
+	case "raw":
+		h, err = hll.NewHllFromBytes(data)
+	case "hex":
+		h, err = hll.NewHllFromHexString(string(data))
+	case "base64":
+		var raw []byte
+		if raw, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err == nil {
+			h, err = hll.NewHllFromBytes(raw)
+		}
+	case "redis":
+		h, err = decodeRedis(data)
+	case "datasketches":
+		h, err = decodeDataSketches(data)
+	default:
+		return nil, "", fmt.Errorf("unknown format %q, expected auto or one of %s", format, strings.Join(formats, ", "))
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", format, err)
+	}
+	return h, format, nil
+}
+
+/**
+ * @return the format that <code>data</code> most likely is in.
+ */
+func detect(data []byte) string {
+	if bytes.HasPrefix(data, []byte(REDIS_MAGIC)) {
+		return "redis"
+	}
+	if len(data) >= DATASKETCHES_HEADER_BYTE_COUNT && data[1] == DATASKETCHES_SER_VER && data[2] == DATASKETCHES_FAMILY_ID {
+		return "datasketches"
+	}
+	// NOTE:  the first byte of the storage format is the schema version and
+	//        the type, neither of which is zero
+	if len(data) > 0 && data[0]>>4 != 0 && data[0]&0xf != 0 && data[0] < 0x80 && !isText(data) {
+		return "raw"
+	}
+	text := strings.TrimSpace(string(data))
+	if strings.HasPrefix(text, hll.HEX_PREFIX) {
+		return "hex"
+	}
+	if _, err := hex.DecodeString(text); err == nil {
+		return "hex"
+	}
+	if _, err := base64.StdEncoding.DecodeString(text); err == nil {
+		return "base64"
+	}
+	return "raw"
+}
+
+func isText(data []byte) bool {
+	for _, b := range data {
+		if b < ' ' && b != '\n' && b != '\r' && b != '\t' || b > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// ------------------------------------------------------------------------
+// Redis
+
+/**
+ * Writes a dense HYLL string with an invalidated cached cardinality, so
+ * that Redis recomputes it on the next PFCOUNT.
+ */
+func encodeRedis(h *hll.Hll) ([]byte, error) {
+	registers := h.Registers()
+	if len(registers) != 1<<REDIS_LOG2M {
+		return nil, fmt.Errorf("redis: needs log2m %d, not %d", REDIS_LOG2M, bitsFor(len(registers)))
+	}
+
+	data := make([]byte, REDIS_HEADER_BYTE_COUNT+(len(registers)*REDIS_REGWIDTH+7)/8)
+	copy(data, REDIS_MAGIC)
+	data[4] = REDIS_DENSE
+	// the most significant bit of the cached cardinality marks it stale
+	data[15] = 0x80
+	dense := data[REDIS_HEADER_BYTE_COUNT:]
+	for i, registerValue := range registers {
+		if registerValue >= 1<<REDIS_REGWIDTH {
+			return nil, fmt.Errorf("redis: register %d is %d, more than %d bits hold", i, registerValue, REDIS_REGWIDTH)
+		}
+		bit := i * REDIS_REGWIDTH
+		dense[bit/8] |= registerValue << (bit % 8)
+		if bit%8 > 8-REDIS_REGWIDTH {
+			dense[bit/8+1] |= registerValue >> (8 - bit%8)
+		}
+	}
+	return data, nil
+}
+
+/**
+ * Reads a dense or sparse HYLL string.
+ */
+func decodeRedis(data []byte) (*hll.Hll, error) {
+	if len(data) < REDIS_HEADER_BYTE_COUNT || !bytes.HasPrefix(data, []byte(REDIS_MAGIC)) {
+		return nil, errors.New("not a HYLL string")
+	}
+	registers := make([]byte, 1<<REDIS_LOG2M)
+	body := data[REDIS_HEADER_BYTE_COUNT:]
+
+	switch data[4] {
+	case REDIS_DENSE:
+		if len(body) < (len(registers)*REDIS_REGWIDTH+7)/8 {
+			return nil, errors.New("truncated dense registers")
+		}
+		for i := range registers {
+			bit := i * REDIS_REGWIDTH
+			value := uint(body[bit/8]) >> (bit % 8)
+			if bit/8+1 < len(body) {
+				value |= uint(body[bit/8+1]) << (8 - bit%8)
+			}
+			registers[i] = byte(value & (1<<REDIS_REGWIDTH - 1))
+		}
+	case REDIS_SPARSE:
+		// opcodes: 00xxxxxx skips x+1 registers, 01xxxxxx yyyyyyyy skips
+		// xy+1 registers and 1vvvvvxx sets x+1 registers to v+1
+		index := 0
+		for i := 0; i < len(body); i++ {
+			opcode := body[i]
+			var run int
+			var value byte
+			switch {
+			case opcode&0xc0 == 0:
+				run = int(opcode&0x3f) + 1
+			case opcode&0xc0 == 0x40:
+				if i+1 >= len(body) {
+					return nil, errors.New("truncated sparse registers")
+				}
+				i++
+				run = (int(opcode&0x3f)<<8 | int(body[i])) + 1
+			default:
+				run = int(opcode&0x3) + 1
+				value = (opcode>>2)&0x1f + 1
+			}
+			if index+run > len(registers) {
+				return nil, errors.New("sparse registers overflow")
+			}
+			for j := index; j < index+run; j++ {
+				registers[j] = value
+			}
+			index += run
+		}
+	default:
+		return nil, fmt.Errorf("unknown encoding %d", data[4])
+	}
+	return hll.NewHllFromRegisters(REDIS_LOG2M, REDIS_REGWIDTH, registers)
+}
+
+// ------------------------------------------------------------------------
+// DataSketches
+
+/**
+ * Writes an updatable HLL_8 sketch in HLL mode, little-endian.
+ */
+func encodeDataSketches(h *hll.Hll) ([]byte, error) {
+	registers := h.Registers()
+	log2m := bitsFor(len(registers))
+	if log2m < DATASKETCHES_MINIMUM_LOG2M || log2m > DATASKETCHES_MAXIMUM_LOG2M {
+		return nil, fmt.Errorf("datasketches: log2m must be within [%d, %d], not %d", DATASKETCHES_MINIMUM_LOG2M, DATASKETCHES_MAXIMUM_LOG2M, log2m)
+	}
+
+	data := make([]byte, DATASKETCHES_HEADER_BYTE_COUNT+len(registers))
+	data[0] = DATASKETCHES_PREAMBLE_INTS
+	data[1] = DATASKETCHES_SER_VER
+	data[2] = DATASKETCHES_FAMILY_ID
+	data[3] = byte(log2m)
+	data[5] = DATASKETCHES_OUT_OF_ORDER_FLAG
+	data[7] = DATASKETCHES_HLL_MODE | DATASKETCHES_HLL_8<<2
+
+	// NOTE:  the current minimum of an HLL_8 sketch is always zero
+	var kxq0, kxq1 float64
+	zeroes := 0
+	for _, registerValue := range registers {
+		if registerValue == 0 {
+			zeroes++
+		}
+		if registerValue < 32 {
+			kxq0 += 1 / float64(uint64(1)<<registerValue)
+		} else {
+			kxq1 += 1 / float64(uint64(1)<<registerValue)
+		}
+	}
+	if zeroes == len(registers) {
+		data[5] |= DATASKETCHES_EMPTY_FLAG
+	}
+	binary.LittleEndian.PutUint64(data[16:], math.Float64bits(kxq0))
+	binary.LittleEndian.PutUint64(data[24:], math.Float64bits(kxq1))
+	binary.LittleEndian.PutUint32(data[32:], uint32(zeroes))
+	copy(data[DATASKETCHES_HEADER_BYTE_COUNT:], registers)
+	return data, nil
+}
+
+/**
+ * Reads an HLL_8 sketch in HLL mode. Sketches in the LIST or SET mode of
+ * small cardinalities and HLL_4 or HLL_6 sketches are not supported.
+ */
+func decodeDataSketches(data []byte) (*hll.Hll, error) {
+	if len(data) < DATASKETCHES_HEADER_BYTE_COUNT || data[1] != DATASKETCHES_SER_VER || data[2] != DATASKETCHES_FAMILY_ID {
+		return nil, errors.New("not an HLL sketch")
+	}
+	if data[0] != DATASKETCHES_PREAMBLE_INTS || data[7]&0x3 != DATASKETCHES_HLL_MODE || (data[7]>>2)&0x3 != DATASKETCHES_HLL_8 {
+		return nil, fmt.Errorf("only HLL_8 sketches in HLL mode are supported (mode byte %#x)", data[7])
+	}
+	log2m := uint(data[3])
+	if log2m < DATASKETCHES_MINIMUM_LOG2M || log2m > DATASKETCHES_MAXIMUM_LOG2M || len(data) < DATASKETCHES_HEADER_BYTE_COUNT+1<<log2m {
+		return nil, fmt.Errorf("truncated sketch of lgK %d", log2m)
+	}
+
+	registers := data[DATASKETCHES_HEADER_BYTE_COUNT : DATASKETCHES_HEADER_BYTE_COUNT+1<<log2m]
+	for i, registerValue := range registers {
+		if registerValue > DATASKETCHES_MAXIMUM_REGISTER {
+			return nil, fmt.Errorf("register %d is %d", i, registerValue)
+		}
+	}
+	return hll.NewHllFromRegisters(log2m, DATASKETCHES_REGWIDTH, registers)
+}
+
+/**
+ * @return log2(n) for a power of two.
+ */
+func bitsFor(n int) int {
+	log2 := 0
+	for 1<<log2 < n {
+		log2++
+	}
+	return log2
+}
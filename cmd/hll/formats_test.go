@@ -0,0 +1,199 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/l0vest0rm/hll"
+)
+
+func TestFormats(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, count := range []int{0, 100, 100000} {
+		h, _ := hll.NewHll(14, 6)
+		for i := 0; i < count; i++ {
+			h.Add(r.Uint64())
+		}
+
+		for _, format := range formats {
+			data, err := encode(h, format)
+			if err != nil {
+				t.Fatalf("%d values, %s: %s", count, format, err)
+			}
+			decoded, detected, err := decode(data, "auto")
+			if err != nil {
+				t.Fatalf("%d values, %s: %s", count, format, err)
+			}
+			if detected != format {
+				t.Errorf("%d values: %s detected as %s", count, format, detected)
+			}
+			// NOTE:  EXPLICIT values only carry over as registers
+			expected := h
+			if format == "redis" || format == "datasketches" {
+				expected, _ = hll.NewHllFromRegisters(14, 6, h.Registers())
+			}
+			if decoded.Cardinality() != expected.Cardinality() || fmt.Sprint(decoded.Registers()) != fmt.Sprint(h.Registers()) {
+				t.Errorf("%d values, %s: cardinality %d, expected %d", count, format, decoded.Cardinality(), expected.Cardinality())
+			}
+		}
+	}
+
+	small, _ := hll.NewHll(11, 5)
+	if _, err := encode(small, "redis"); err == nil {
+		t.Errorf("redis accepted log2m 11")
+	}
+}
+
+// NOTE:  neither a Redis server nor DataSketches is available to build the
+//        golden blobs below, so they were laid out by hand from the
+//        published formats: Redis' hyperloglog.c and DataSketches'
+//        PreambleUtil. They should be replaced by captured blobs once those
+//        are at hand.
+
+func TestRedisGolden(t *testing.T) {
+	// PFADD k a b c then GET k: the sparse registers that hyperloglog.c
+	// derives from MurmurHash64A with seed 0xadc83b19, and the cached
+	// cardinality marked stale by the PFADD
+	golden, _ := hex.DecodeString("48594c4c01000000000000000000008060f38050b1844bfb80425a")
+
+	h, format, err := decode(golden, "auto")
+	if err != nil || format != "redis" {
+		t.Fatalf("decoded as %s: %v", format, err)
+	}
+	set := map[int]byte{}
+	for i, registerValue := range h.Registers() {
+		if registerValue != 0 {
+			set[i] = registerValue
+		}
+	}
+	if fmt.Sprint(set) != "map[8436:1 12711:2 15780:1]" {
+		t.Errorf("registers %v", set)
+	}
+
+	// the same registers densely: 6 bits each, least significant bit first
+	dense, err := encode(h, "redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dense[:REDIS_HEADER_BYTE_COUNT], []byte("HYLL\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80")) {
+		t.Errorf("dense header %x", dense[:REDIS_HEADER_BYTE_COUNT])
+	}
+	registerBytes := map[int]byte{}
+	for i, b := range dense[REDIS_HEADER_BYTE_COUNT:] {
+		if b != 0 {
+			registerBytes[i] = b
+		}
+	}
+	if fmt.Sprint(registerBytes) != "map[6327:1 9533:8 11835:1]" {
+		t.Errorf("dense register bytes %v", registerBytes)
+	}
+}
+
+func TestDataSketchesGolden(t *testing.T) {
+	// an HLL_8 sketch of lgK 4 in HLL mode
+	registers := []byte{0, 1, 0, 2, 0, 0, 3, 1, 0, 0, 0, 0, 5, 0, 0, 1}
+	golden := []byte{
+		// preInts, serVer, familyId, lgK, lgArr, flags, curMin, mode
+		// (HLL mode | HLL_8 << 2)
+		10, 1, 7, 4, 0, 0, 0, 0x0a,
+		// HIP accumulator 12.5
+		0, 0, 0, 0, 0, 0, 0x29, 0x40,
+		// kxq0 = 10 + 3/2 + 1/4 + 1/8 + 1/32 = 11.90625
+		0, 0, 0, 0, 0, 0xd0, 0x27, 0x40,
+		// kxq1
+		0, 0, 0, 0, 0, 0, 0, 0,
+		// numAtCurMin, auxCount
+		10, 0, 0, 0, 0, 0, 0, 0,
+	}
+	golden = append(golden, registers...)
+
+	h, format, err := decode(golden, "auto")
+	if err != nil || format != "datasketches" {
+		t.Fatalf("decoded as %s: %v", format, err)
+	}
+	if !bytes.Equal(h.Registers(), registers) {
+		t.Errorf("registers %v", h.Registers())
+	}
+
+	// NOTE:  the HIP accumulator is not carried over, which the
+	//        out-of-order flag tells DataSketches
+	encoded, err := encode(h, "datasketches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := slices.Clone(golden)
+	expected[5] = DATASKETCHES_OUT_OF_ORDER_FLAG
+	clear(expected[8:16])
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("encoded as\n%x, expected\n%x", encoded, expected)
+	}
+}
+
+func TestRedisSparse(t *testing.T) {
+	// XZERO of 1000 registers, VAL 3 x2, ZERO of 64 and VAL 1 x1, then an
+	// XZERO over the rest
+	sparse := []byte{0x40 | 0x3, 0xe7, 0x80 | 2<<2 | 1, 0x3f, 0x80}
+	rest := 1<<REDIS_LOG2M - 1000 - 2 - 64 - 1
+	sparse = append(sparse, 0x40|byte((rest-1)>>8), byte(rest-1))
+	data := append([]byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80"), sparse...)
+
+	h, err := decodeRedis(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registers := h.Registers()
+	set := map[int]byte{}
+	for i, registerValue := range registers {
+		if registerValue != 0 {
+			set[i] = registerValue
+		}
+	}
+	if fmt.Sprint(set) != "map[1000:3 1001:3 1066:1]" {
+		t.Errorf("registers %v", set)
+	}
+
+	if _, err := decodeRedis(append(data, 0x3f)); err == nil {
+		t.Errorf("overflowing sparse registers accepted")
+	}
+}
+
+func TestParse(t *testing.T) {
+	flags := flag.NewFlagSet("merge", flag.ContinueOnError)
+	output := flags.String("o", "-", "")
+	args, err := parse(flags, []string{"a", "b", "-o", "out", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(args) != "[a b c]" || *output != "out" {
+		t.Errorf("arguments %v, output %s", args, *output)
+	}
+}
+
+func TestDecodeHeader(t *testing.T) {
+	h, _ := hll.NewHll6(12, 5, 0, true, hll.EMPTY, 25)
+	header := decodeHeader(h.ToBytes())
+	if header.log2m != 12 || header.regwidth != 5 || header.explicitThreshold() != "off" || !header.sparseon || header.sparsePrecision != 25 || !bytes.Equal(header.bytes, h.ToBytes()[:4]) {
+		t.Errorf("header %+v", header)
+	}
+}
@@ -0,0 +1,135 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/l0vest0rm/hll"
+)
+
+/**
+ * The fields of the header of the storage format, see
+ * hll/schema_version.go.
+ */
+type header struct {
+	bytes           []byte
+	version         int
+	hllType         hll.Type
+	log2m           uint
+	regwidth        uint
+	explicitCutoff  int
+	sparseon        bool
+	sparsePrecision uint
+}
+
+/**
+ * Decodes the header of a serialized HLL, which must be valid.
+ */
+func decodeHeader(data []byte) header {
+	this := header{}
+	this.version = int(data[0] >> hll.NIBBLE_BITS)
+	this.hllType = hll.Type(data[0] & hll.NIBBLE_MASK)
+	this.regwidth = uint(data[1]>>hll.LOG2_REGISTER_COUNT_BITS) + 1
+	this.log2m = uint(data[1] & hll.LOG2_REGISTER_COUNT_MASK)
+	this.explicitCutoff = int(data[2] & hll.EXPLICIT_CUTOFF_MASK)
+	this.sparseon = data[2]&(1<<hll.EXPLICIT_CUTOFF_BITS) != 0
+	byteCount := hll.HEADER_BYTE_COUNT
+	if this.version >= hll.SPARSE_PRECISION_SCHEMA_VERSION {
+		byteCount = hll.SPARSE_PRECISION_HEADER_BYTE_COUNT
+		this.sparsePrecision = uint(data[3])
+	}
+	this.bytes = data[:byteCount]
+	return this
+}
+
+/**
+ * @return the EXPLICIT promotion threshold as the expthresh parameter of
+ *         NewHll5() describes it.
+ */
+func (this header) explicitThreshold() string {
+	switch this.explicitCutoff {
+	case hll.EXPLICIT_OFF:
+		return "off"
+	case hll.EXPLICIT_AUTO:
+		return "auto"
+	default:
+		return fmt.Sprintf("%d values", 1<<(this.explicitCutoff-1))
+	}
+}
+
+func inspect(args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	format := flags.String("format", "auto", "input format: auto, "+strings.Join(formats, ", "))
+	args, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+
+	sketches, sketchFormats, err := readSketches(args, *format)
+	if err != nil {
+		return err
+	}
+	for i, h := range sketches {
+		if len(sketches) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s:\n", args[i])
+		}
+		describe(h, sketchFormats[i])
+	}
+	return nil
+}
+
+func describe(h *hll.Hll, format string) {
+	data := h.ToBytes()
+	header := decodeHeader(data)
+	fmt.Printf("format:       %s\n", format)
+	if format != "raw" {
+		fmt.Printf("              (the rest describes the sketch converted to raw)\n")
+	}
+	fmt.Printf("bytes:        %d\n", len(data))
+	fmt.Printf("header:       % x\n", header.bytes)
+	fmt.Printf("version:      %d\n", header.version)
+	fmt.Printf("type:         %s\n", header.hllType)
+	fmt.Printf("log2m:        %d (%d registers)\n", header.log2m, 1<<header.log2m)
+	fmt.Printf("regwidth:     %d\n", header.regwidth)
+	fmt.Printf("expthresh:    %s\n", header.explicitThreshold())
+	fmt.Printf("sparseon:     %t\n", header.sparseon)
+	if header.sparsePrecision != 0 {
+		fmt.Printf("sparse p':    %d\n", header.sparsePrecision)
+	}
+	fmt.Printf("cardinality:  %d\n", h.Cardinality())
+	fmt.Printf("std error:    %.2f%%\n", 100*h.StandardError())
+
+	histogram := h.RegisterHistogram()
+	set := uint64(0)
+	var counts []string
+	for registerValue, count := range histogram {
+		if registerValue > 0 {
+			set += count
+		}
+		if count > 0 {
+			counts = append(counts, fmt.Sprintf("%d:%d", registerValue, count))
+		}
+	}
+	fmt.Printf("registers:    %d of %d set\n", set, 1<<header.log2m)
+	fmt.Printf("histogram:    %s\n", strings.Join(counts, " "))
+}
@@ -0,0 +1,263 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+// hll builds, counts, merges, inspects and converts serialized HLLs.
+//
+//	hll build -log2m 14 -regwidth 6 -o visitors.hll < visitors.txt
+//	hll count visitors.hll
+//	hll merge monday.hll tuesday.hll -o week.hll
+//	hll inspect week.hll
+//	hll convert -to redis week.hll -o week.redis
+//
+// Sketches are read in the storage format of Hll#ToBytes() (raw), as
+// PostgreSQL hex, base64, Redis HYLL strings or DataSketches HLL_8 sketches,
+// which is detected unless -format is given. "-" or no file is stdin.
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/l0vest0rm/hll"
+)
+
+const usage = `usage: hll <command> [flags] [files]
+
+commands:
+  build    hash stdin into a new sketch
+  count    print the cardinality of each sketch
+  merge    union sketches into one
+  inspect  describe the header, parameters and registers of sketches
+  convert  change the format of a sketch
+
+run "hll <command> -h" for the flags of a command
+`
+
+var commands = map[string]func(args []string) error{
+	"build":   build,
+	"count":   count,
+	"merge":   merge,
+	"inspect": inspect,
+	"convert": convert,
+}
+
+func main() {
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := commands[os.Args[1]](os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "hll %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+/**
+ * Parses flags that may come before, between or after the positional
+ * arguments, as in <code>merge a b -o out</code>.
+ *
+ * @return the positional arguments.
+ */
+func parse(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+func readInput(name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(name)
+}
+
+func writeOutput(name string, data []byte) error {
+	if name == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
+
+/**
+ * Reads and decodes the sketches of the files, stdin if there are none.
+ */
+func readSketches(names []string, format string) ([]*hll.Hll, []string, error) {
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	sketches := make([]*hll.Hll, len(names))
+	formats := make([]string, len(names))
+	for i, name := range names {
+		data, err := readInput(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if sketches[i], formats[i], err = decode(data, format); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return sketches, formats, nil
+}
+
+func build(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	log2m := flags.Uint("log2m", 11, "log-base-2 of the number of registers")
+	regwidth := flags.Uint("regwidth", 5, "number of bits per register")
+	expthresh := flags.Int("expthresh", -1, "EXPLICIT promotion threshold, -1 for auto, 0 to disable")
+	sparseon := flags.Bool("sparseon", true, "use the SPARSE representation")
+	seed := flags.Uint("seed", 0, "murmur3 seed used to hash the input")
+	input := flags.String("input", "lines", "lines: hash every line, int64: hash 8-byte little-endian integers like hll_hash_bigint(), hashed: add 8-byte little-endian hashes as they are")
+	format := flags.String("format", "raw", "output format: "+strings.Join(formats, ", "))
+	output := flags.String("o", "-", "output file")
+	if args, err := parse(flags, args); err != nil {
+		return err
+	} else if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %v, the input is read from stdin", args)
+	}
+
+	h, err := hll.NewHll5(*log2m, *regwidth, *expthresh, *sparseon, hll.EMPTY)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReaderSize(os.Stdin, 1<<16)
+	switch *input {
+	case "lines":
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			h.Add(hll.HashBytes(scanner.Bytes(), uint32(*seed)))
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	case "int64", "hashed":
+		var value [8]byte
+		for {
+			if _, err := io.ReadFull(reader, value[:]); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("reading 8-byte values: %w", err)
+			}
+			if *input == "int64" {
+				h.Add(hll.HashBytes(value[:], uint32(*seed)))
+			} else {
+				h.Add(binary.LittleEndian.Uint64(value[:]))
+			}
+		}
+	default:
+		return fmt.Errorf("unknown input %q, expected lines, int64 or hashed", *input)
+	}
+
+	data, err := encode(h, *format)
+	if err != nil {
+		return err
+	}
+	return writeOutput(*output, data)
+}
+
+func count(args []string) error {
+	flags := flag.NewFlagSet("count", flag.ContinueOnError)
+	format := flags.String("format", "auto", "input format: auto, "+strings.Join(formats, ", "))
+	args, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+
+	sketches, _, err := readSketches(args, *format)
+	if err != nil {
+		return err
+	}
+	for i, h := range sketches {
+		if len(args) > 1 {
+			fmt.Printf("%d\t%s\n", h.Cardinality(), args[i])
+		} else {
+			fmt.Println(h.Cardinality())
+		}
+	}
+	return nil
+}
+
+func merge(args []string) error {
+	flags := flag.NewFlagSet("merge", flag.ContinueOnError)
+	inputFormat := flags.String("format", "auto", "input format: auto, "+strings.Join(formats, ", "))
+	outputFormat := flags.String("to", "raw", "output format: "+strings.Join(formats, ", "))
+	output := flags.String("o", "-", "output file")
+	args, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("no sketches to merge")
+	}
+
+	sketches, _, err := readSketches(args, *inputFormat)
+	if err != nil {
+		return err
+	}
+	result := sketches[0]
+	expected := decodeHeader(result.ToBytes())
+	for i, h := range sketches[1:] {
+		if header := decodeHeader(h.ToBytes()); header.log2m != expected.log2m || header.regwidth != expected.regwidth {
+			return fmt.Errorf("%s: log2m %d and regwidth %d differ from log2m %d and regwidth %d of %s", args[i+1], header.log2m, header.regwidth, expected.log2m, expected.regwidth, args[0])
+		}
+		result.Union(h)
+	}
+
+	data, err := encode(result, *outputFormat)
+	if err != nil {
+		return err
+	}
+	return writeOutput(*output, data)
+}
+
+func convert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	from := flags.String("from", "auto", "input format: auto, "+strings.Join(formats, ", "))
+	to := flags.String("to", "hex", "output format: "+strings.Join(formats, ", "))
+	output := flags.String("o", "-", "output file")
+	args, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return errors.New("convert takes a single sketch")
+	}
+
+	sketches, _, err := readSketches(args, *from)
+	if err != nil {
+		return err
+	}
+	data, err := encode(sketches[0], *to)
+	if err != nil {
+		return err
+	}
+	return writeOutput(*output, data)
+}
@@ -0,0 +1,506 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+// hllbench measures the accuracy, speed and size of HLLs over a grid of
+// parameters and writes one CSV row per setting and target cardinality.
+//
+//	hllbench -log2m 10,12,14 -regwidth 5,6 -cardinalities 1e3,1e5,1e7 -trials 10 > bench.csv
+//	hllbench -input hashes.bin -format hashed -log2m 14 > ours.csv
+//
+// Values are seeded pseudo-random hashes unless -input is given, in which
+// case the file is replayed in every trial and the true cardinality is the
+// number of distinct hashes read so far.
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/l0vest0rm/hll"
+)
+
+var header = []string{
+	"log2m", "regwidth", "expthresh", "sparseon", "cardinality", "trials",
+	"mean_estimate", "bias", "mean_abs_rel_error", "rmse_rel_error", "std_error",
+	"type", "bytes", "explicit_bytes", "sparse_bytes", "full_bytes",
+	"inserts_per_sec", "explicit_promotion", "sparse_promotion",
+}
+
+// the representations whose serialized sizes are reported, in the order of
+// their columns
+var sizeTypes = []hll.Type{hll.EXPLICIT, hll.SPARSE, hll.FULL}
+
+/**
+ * A point of the parameter grid.
+ */
+type setting struct {
+	log2m     uint
+	regwidth  uint
+	expthresh int
+	sparseon  bool
+}
+
+/**
+ * What was measured for a setting when a trial reached a target
+ * cardinality.
+ */
+type sample struct {
+	estimate float64
+	hllType  hll.Type
+	bytes    int
+	// the serialized size of the HLL converted to each of #sizeTypes,
+	// missing if it cannot be converted
+	sizes map[hll.Type]int
+	// time spent adding since the previous target, and the adds
+	elapsed time.Duration
+	adds    int
+	// cardinalities at which the HLL left EXPLICIT and SPARSE, zero if it
+	// has not
+	explicitPromotion int
+	sparsePromotion   int
+}
+
+/**
+ * The hashes of the trials: a seeded pseudo-random stream or a file.
+ */
+type source struct {
+	// values returns the hashes of a trial. There may be fewer than the
+	// limit, or more if the hashes repeat.
+	values func(trial int, limit int) []uint64
+	// countDistinct is set if the hashes may repeat
+	countDistinct bool
+}
+
+/**
+ * Where a trial left a representation.
+ */
+type promotion struct {
+	// the number of adds up to and including the promoting one, zero if the
+	// HLL was not promoted
+	adds int
+	// the distinct values added by then
+	cardinality int
+}
+
+func main() {
+	log2ms := flag.String("log2m", "10,12,14", "comma-separated log2m values")
+	regwidths := flag.String("regwidth", "5,6", "comma-separated regwidth values")
+	expthreshs := flag.String("expthresh", "-1", "comma-separated expthresh values")
+	sparseons := flag.String("sparseon", "true", "comma-separated sparseon values")
+	cardinalities := flag.String("cardinalities", "10,100,1e3,1e4,1e5,1e6", "comma-separated target cardinalities")
+	trials := flag.Int("trials", 5, "number of trials per setting")
+	seed := flag.Int64("seed", 1, "seed of the pseudo-random hashes")
+	input := flag.String("input", "", "file of values to replay instead of pseudo-random hashes")
+	format := flag.String("format", "hashed", "format of -input: lines (hashed with murmur3), int64 (8-byte little-endian integers, hashed) or hashed (8-byte little-endian hashes)")
+	output := flag.String("o", "-", "output CSV file")
+	flag.Parse()
+
+	grid, err := parseGrid(*log2ms, *regwidths, *expthreshs, *sparseons)
+	if err != nil {
+		log.Fatal(err)
+	}
+	targets, err := parseCardinalities(*cardinalities)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	values := randomSource(*seed)
+	if *input != "" {
+		file, err := fileSource(*input, *format)
+		if err != nil {
+			log.Fatal(err)
+		}
+		values = *file
+	}
+
+	w := os.Stdout
+	if *output != "-" {
+		if w, err = os.Create(*output); err != nil {
+			log.Fatal(err)
+		}
+		defer w.Close()
+	}
+	if err := run(csv.NewWriter(w), grid, targets, *trials, values); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ------------------------------------------------------------------------
+// Flags
+
+func parseList[T any](list string, parse func(string) (T, error)) ([]T, error) {
+	var values []T
+	for _, field := range strings.Split(list, ",") {
+		value, err := parse(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func parseUint(s string) (uint, error) {
+	value, err := strconv.ParseUint(s, 10, 32)
+	return uint(value), err
+}
+
+func parseGrid(log2ms string, regwidths string, expthreshs string, sparseons string) ([]setting, error) {
+	log2mValues, err := parseList(log2ms, parseUint)
+	if err != nil {
+		return nil, fmt.Errorf("log2m: %w", err)
+	}
+	regwidthValues, err := parseList(regwidths, parseUint)
+	if err != nil {
+		return nil, fmt.Errorf("regwidth: %w", err)
+	}
+	expthreshValues, err := parseList(expthreshs, strconv.Atoi)
+	if err != nil {
+		return nil, fmt.Errorf("expthresh: %w", err)
+	}
+	sparseonValues, err := parseList(sparseons, strconv.ParseBool)
+	if err != nil {
+		return nil, fmt.Errorf("sparseon: %w", err)
+	}
+
+	var grid []setting
+	for _, log2m := range log2mValues {
+		for _, regwidth := range regwidthValues {
+			for _, expthresh := range expthreshValues {
+				for _, sparseon := range sparseonValues {
+					s := setting{log2m, regwidth, expthresh, sparseon}
+					if _, err := s.newHll(); err != nil {
+						return nil, err
+					}
+					grid = append(grid, s)
+				}
+			}
+		}
+	}
+	return grid, nil
+}
+
+/**
+ * Parses cardinalities such as 1000 or 1e6 and sorts them.
+ */
+func parseCardinalities(list string) ([]int, error) {
+	targets, err := parseList(list, func(s string) (int, error) {
+		value, err := strconv.ParseFloat(s, 64)
+		if err != nil || value < 1 || value != math.Trunc(value) {
+			return 0, fmt.Errorf("invalid cardinality %q", s)
+		}
+		return int(value), nil
+	})
+	slices.Sort(targets)
+	return slices.Compact(targets), err
+}
+
+func (this setting) newHll() (*hll.Hll, error) {
+	return hll.NewHll5(this.log2m, this.regwidth, this.expthresh, this.sparseon, hll.EMPTY)
+}
+
+// ------------------------------------------------------------------------
+// Sources
+
+func randomSource(seed int64) source {
+	return source{values: func(trial int, limit int) []uint64 {
+		r := rand.New(rand.NewSource(seed + int64(trial)))
+		values := make([]uint64, limit)
+		for i := range values {
+			values[i] = r.Uint64()
+		}
+		return values
+	}}
+}
+
+/**
+ * Reads the values of the file up front, so that reading does not count
+ * towards the insert throughput.
+ */
+func fileSource(name string, format string) (*source, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var values []uint64
+	reader := bufio.NewReaderSize(file, 1<<16)
+	switch format {
+	case "lines":
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			values = append(values, hll.HashBytes(scanner.Bytes(), 0))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	case "int64", "hashed":
+		var value [8]byte
+		for {
+			if _, err := io.ReadFull(reader, value[:]); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if format == "int64" {
+				values = append(values, hll.HashBytes(value[:], 0))
+			} else {
+				values = append(values, binary.LittleEndian.Uint64(value[:]))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected lines, int64 or hashed", format)
+	}
+
+	return &source{values: func(trial int, limit int) []uint64 {
+		return values
+	}, countDistinct: true}, nil
+}
+
+// ------------------------------------------------------------------------
+// Measurement
+
+func run(w *csv.Writer, grid []setting, targets []int, trials int, values source) error {
+	if trials < 1 {
+		return errors.New("trials must be at least 1")
+	}
+	w.Write(header)
+	for _, s := range grid {
+		// samples[t][i] is trial t at targets[i]
+		samples := make([][]sample, trials)
+		for trial := range samples {
+			samples[trial] = measure(s, targets, values.values(trial, targets[len(targets)-1]), values.countDistinct)
+		}
+		for i, target := range targets {
+			var reached []sample
+			for _, trialSamples := range samples {
+				if i < len(trialSamples) {
+					reached = append(reached, trialSamples[i])
+				}
+			}
+			if len(reached) == 0 {
+				// NOTE:  the input ran out
+				break
+			}
+			w.Write(row(s, target, reached))
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+/**
+ * Adds the values of a trial until every target cardinality is reached or
+ * the values run out. Only the adds are timed: where the targets are
+ * reached and the promotions happen is worked out beforehand by #replay().
+ *
+ * @return a sample per target that was reached.
+ */
+func measure(s setting, targets []int, values []uint64, countDistinct bool) []sample {
+	ends, explicitPromotion, sparsePromotion := replay(s, targets, values, countDistinct)
+
+	h, _ := s.newHll()
+	var samples []sample
+	added := 0
+	for _, end := range ends {
+		start := time.Now()
+		for _, value := range values[added:end] {
+			h.Add(value)
+		}
+		elapsed := time.Since(start)
+
+		samples = append(samples, sample{
+			estimate:          float64(h.Cardinality()),
+			hllType:           h.Type(),
+			bytes:             len(h.ToBytes()),
+			sizes:             serializedSizes(h),
+			elapsed:           elapsed,
+			adds:              end - added,
+			explicitPromotion: explicitPromotion.by(end),
+			sparsePromotion:   sparsePromotion.by(end),
+		})
+		added = end
+	}
+	return samples
+}
+
+/**
+ * @return the length of #ToBytes() of a copy of the HLL converted to each of
+ *         #sizeTypes that it can be converted to.
+ */
+func serializedSizes(h *hll.Hll) map[hll.Type]int {
+	sizes := make(map[hll.Type]int)
+	for _, hllType := range sizeTypes {
+		converted := h.Clone()
+		var err error
+		switch hllType {
+		case hll.EXPLICIT:
+			err = converted.ToExplicit()
+		case hll.SPARSE:
+			err = converted.ToSparse()
+		case hll.FULL:
+			converted.ToFull()
+		}
+		if err == nil {
+			sizes[hllType] = len(converted.ToBytes())
+		}
+	}
+	return sizes
+}
+
+/**
+ * Adds the values of a trial to an untimed HLL.
+ *
+ * @return the number of values after which every target that is reached is
+ *         reached, and where the HLL left EXPLICIT and SPARSE.
+ */
+func replay(s setting, targets []int, values []uint64, countDistinct bool) ([]int, promotion, promotion) {
+	h, _ := s.newHll()
+	// NOTE:  pseudo-random hashes are taken to be distinct, those of a file
+	//        are counted
+	var distinct map[uint64]struct{}
+	if countDistinct {
+		distinct = make(map[uint64]struct{})
+	}
+	var ends []int
+	var explicitPromotion, sparsePromotion promotion
+	cardinality := 0
+	hllType := h.Type()
+
+	for i, value := range values {
+		if len(ends) == len(targets) {
+			break
+		}
+		h.Add(value)
+		if distinct == nil {
+			cardinality++
+		} else if _, seen := distinct[value]; !seen {
+			distinct[value] = struct{}{}
+			cardinality++
+		}
+
+		if t := h.Type(); t != hllType {
+			switch hllType {
+			case hll.EXPLICIT:
+				explicitPromotion = promotion{i + 1, cardinality}
+			case hll.SPARSE:
+				sparsePromotion = promotion{i + 1, cardinality}
+			}
+			hllType = t
+		}
+		for len(ends) < len(targets) && cardinality >= targets[len(ends)] {
+			ends = append(ends, i+1)
+		}
+	}
+	return ends, explicitPromotion, sparsePromotion
+}
+
+/**
+ * @return the cardinality of the promotion if it happened within the first
+ *         <code>adds</code> adds, zero otherwise.
+ */
+func (this promotion) by(adds int) int {
+	if this.adds == 0 || this.adds > adds {
+		return 0
+	}
+	return this.cardinality
+}
+
+/**
+ * Aggregates the samples of the trials at a target cardinality.
+ */
+func row(s setting, target int, samples []sample) []string {
+	var estimates, relativeErrors, absErrors, squaredErrors, bytes float64
+	var elapsed time.Duration
+	adds := 0
+	sizes, sized := make(map[hll.Type]float64), make(map[hll.Type]int)
+	for _, sample := range samples {
+		relativeError := (sample.estimate - float64(target)) / float64(target)
+		estimates += sample.estimate
+		relativeErrors += relativeError
+		absErrors += math.Abs(relativeError)
+		squaredErrors += relativeError * relativeError
+		bytes += float64(sample.bytes)
+		for hllType, size := range sample.sizes {
+			sizes[hllType] += float64(size)
+			sized[hllType]++
+		}
+		elapsed += sample.elapsed
+		adds += sample.adds
+	}
+	n := float64(len(samples))
+	last := samples[len(samples)-1]
+
+	columns := []string{
+		fmt.Sprint(s.log2m),
+		fmt.Sprint(s.regwidth),
+		fmt.Sprint(s.expthresh),
+		fmt.Sprint(s.sparseon),
+		fmt.Sprint(target),
+		fmt.Sprint(len(samples)),
+		formatFloat(estimates / n),
+		formatFloat(relativeErrors / n),
+		formatFloat(absErrors / n),
+		formatFloat(math.Sqrt(squaredErrors / n)),
+		formatFloat(1.04 / math.Sqrt(float64(uint(1)<<s.log2m))),
+		last.hllType.String(),
+		formatFloat(bytes / n),
+	}
+	// NOTE:  a size is averaged over the trials whose HLL could be
+	//        converted, and left empty if none could
+	for _, hllType := range sizeTypes {
+		if sized[hllType] == 0 {
+			columns = append(columns, "")
+		} else {
+			columns = append(columns, formatFloat(sizes[hllType]/float64(sized[hllType])))
+		}
+	}
+	return append(columns,
+		formatFloat(float64(adds)/max(elapsed.Seconds(), 1e-9)),
+		formatPromotion(last.explicitPromotion),
+		formatPromotion(last.sparsePromotion),
+	)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', 6, 64)
+}
+
+/**
+ * @return the cardinality at which the HLL was promoted, empty if it was
+ *         not yet.
+ */
+func formatPromotion(cardinality int) string {
+	if cardinality == 0 {
+		return ""
+	}
+	return fmt.Sprint(cardinality)
+}
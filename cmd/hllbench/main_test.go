@@ -0,0 +1,126 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/l0vest0rm/hll"
+)
+
+func TestRun(t *testing.T) {
+	grid, err := parseGrid("10", "5", "-1,0", "true,false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, err := parseCardinalities("1e4,10,1000,10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 3 || targets[0] != 10 || targets[2] != 10000 {
+		t.Fatalf("targets %v", targets)
+	}
+
+	var out bytes.Buffer
+	if err := run(csv.NewWriter(&out), grid, targets, 3, randomSource(1)); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1+len(grid)*len(targets) {
+		t.Fatalf("%d rows", len(rows))
+	}
+	column := make(map[string]int)
+	for i, name := range rows[0] {
+		column[name] = i
+	}
+
+	for _, row := range rows[1:] {
+		expthresh, sparseon := row[column["expthresh"]], row[column["sparseon"]]
+		cardinality := row[column["cardinality"]]
+		hllType := row[column["type"]]
+		rmse, _ := strconv.ParseFloat(row[column["rmse_rel_error"]], 64)
+		if rmse > 0.2 {
+			t.Errorf("%v: relative error %v", row, rmse)
+		}
+		if row[column["full_bytes"]] == "" || (sparseon == "false") != (row[column["sparse_bytes"]] == "") {
+			t.Errorf("%v: expected a FULL size, and a SPARSE size if it is on", row)
+		}
+		if hllType != "EXPLICIT" && row[column["explicit_bytes"]] != "" {
+			t.Errorf("%v: expected no EXPLICIT size", row)
+		}
+		switch {
+		case cardinality == "10" && expthresh == "-1":
+			if hllType != "EXPLICIT" || rmse != 0 {
+				t.Errorf("%v: expected an exact EXPLICIT HLL", row)
+			}
+			explicitBytes, _ := strconv.Atoi(row[column["explicit_bytes"]])
+			fullBytes, _ := strconv.Atoi(row[column["full_bytes"]])
+			if row[column["explicit_bytes"]] != row[column["bytes"]] || explicitBytes >= fullBytes {
+				t.Errorf("%v: expected the EXPLICIT size to be the size and smaller than FULL", row)
+			}
+		case cardinality == "10000":
+			if hllType != "FULL" {
+				t.Errorf("%v: expected a FULL HLL", row)
+			}
+			if expthresh == "-1" && row[column["explicit_promotion"]] == "" {
+				t.Errorf("%v: expected an explicit promotion", row)
+			}
+			if sparseon == "true" && row[column["sparse_promotion"]] == "" {
+				t.Errorf("%v: expected a sparse promotion", row)
+			}
+		}
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	// 100 distinct hashes, each written twice
+	var data []byte
+	for i := 0; i < 200; i++ {
+		data = binary.LittleEndian.AppendUint64(data, hll.HashString(strconv.Itoa(i%100), 0))
+	}
+	name := filepath.Join(t.TempDir(), "hashes")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	values, err := fileSource(name, "hashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grid, _ := parseGrid("10", "5", "-1", "true")
+	var out bytes.Buffer
+	if err := run(csv.NewWriter(&out), grid, []int{50, 100, 1000}, 2, *values); err != nil {
+		t.Fatal(err)
+	}
+	rows, _ := csv.NewReader(&out).ReadAll()
+	// the input runs out before 1000 distinct values
+	if len(rows) != 3 {
+		t.Fatalf("%d rows: %v", len(rows), rows)
+	}
+	if rows[2][4] != "100" || rows[2][6] != "100" {
+		t.Errorf("expected an exact count of 100, got %v", rows[2])
+	}
+}
@@ -0,0 +1,70 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+// hllhttpd serves a keyed set of HLLs over the JSON API of package hllhttp.
+//
+//	hllhttpd -addr :8080 -log2m 14 -regwidth 6 -seed 0
+//	curl -d '{"values": ["alice", "bob"]}' localhost:8080/sketches/visitors/add
+//	curl localhost:8080/sketches/visitors/cardinality
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/l0vest0rm/hll"
+	"github.com/l0vest0rm/hll/hllhttp"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8080", "address to listen on")
+	log2m := flag.Uint("log2m", 11, "log-base-2 of the number of registers")
+	regwidth := flag.Uint("regwidth", 5, "number of bits per register")
+	expthresh := flag.Int("expthresh", -1, "EXPLICIT promotion threshold, -1 for auto, 0 to disable")
+	sparseon := flag.Bool("sparseon", true, "use the SPARSE representation")
+	seed := flag.Uint("seed", 0, "murmur3 seed used to hash raw values")
+	maxBytes := flag.Uint("maxbytes", 0, "memory budget for all sketches, 0 for unbounded")
+	ttl := flag.Duration("ttl", 0, "evict keys idle for longer than this, 0 to disable")
+	flag.Parse()
+
+	store, err := hll.NewStore(hll.StoreConfig{
+		Log2m:     *log2m,
+		Regwidth:  *regwidth,
+		Expthresh: *expthresh,
+		Sparseon:  *sparseon,
+		MaxBytes:  *maxBytes,
+		TTL:       *ttl,
+		Spill: func(key string, data []byte) {
+			log.Printf("evicted key %q (%d bytes)", key, len(data))
+		},
+	})
+	if err != nil {
+		log.Fatalf("invalid parameters: %s", err)
+	}
+
+	if *ttl > 0 {
+		go func() {
+			for range time.Tick(*ttl) {
+				store.Expire()
+			}
+		}()
+	}
+
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, hllhttp.NewHandler(store, uint32(*seed))))
+}
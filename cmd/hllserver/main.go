@@ -0,0 +1,87 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+// hllserver is a local unique-count server speaking a subset of the Redis
+// protocol: PFADD, PFCOUNT, PFMERGE, GET, SET, DEL, KEYS and PING.
+//
+//	hllserver -addr 127.0.0.1:6379 -log2m 14 -regwidth 6
+//	redis-cli PFADD visitors alice bob
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"time"
+
+	"github.com/l0vest0rm/hll"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6379", "address to listen on")
+	log2m := flag.Uint("log2m", 11, "log-base-2 of the number of registers")
+	regwidth := flag.Uint("regwidth", 5, "number of bits per register")
+	expthresh := flag.Int("expthresh", -1, "EXPLICIT promotion threshold, -1 for auto, 0 to disable")
+	sparseon := flag.Bool("sparseon", true, "use the SPARSE representation")
+	seed := flag.Uint("seed", 0, "murmur3 seed used to hash PFADD elements")
+	maxBytes := flag.Uint("maxbytes", 0, "memory budget for all sketches, 0 for unbounded")
+	ttl := flag.Duration("ttl", 0, "evict keys idle for longer than this, 0 to disable")
+	flag.Parse()
+
+	config := hll.StoreConfig{
+		Log2m:     *log2m,
+		Regwidth:  *regwidth,
+		Expthresh: *expthresh,
+		Sparseon:  *sparseon,
+		MaxBytes:  *maxBytes,
+		TTL:       *ttl,
+		Spill: func(key string, data []byte) {
+			log.Printf("evicted key %q (%d bytes)", key, len(data))
+		},
+	}
+	s, err := newServer(config, uint32(*seed))
+	if err != nil {
+		log.Fatalf("invalid parameters: %s", err)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("listen %s: %s", *addr, err)
+	}
+	log.Printf("listening on %s", listener.Addr())
+
+	if *ttl > 0 {
+		go func() {
+			for range time.Tick(*ttl) {
+				s.store.Expire()
+			}
+		}()
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("accept: %s", err)
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.serve(conn); err != nil {
+				log.Printf("%s: %s", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
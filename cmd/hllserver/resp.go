@@ -0,0 +1,159 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	// limits matching the defaults of Redis
+	MAX_BULK_LENGTH   = 512 * 1024 * 1024
+	MAX_ARRAY_LENGTH  = 1024 * 1024
+	MAX_INLINE_LENGTH = 64 * 1024
+)
+
+// errProtocol is returned for malformed requests, after which the connection
+// is closed as Redis does.
+var errProtocol = errors.New("Protocol error")
+
+/**
+ * Reads RESP2 requests: either arrays of bulk strings or inline commands.
+ */
+type respReader struct {
+	r *bufio.Reader
+}
+
+func newRespReader(r io.Reader) *respReader {
+	return &respReader{r: bufio.NewReader(r)}
+}
+
+func (this *respReader) readLine() ([]byte, error) {
+	line, err := this.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("%w: too big inline request", errProtocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		// inline commands may be terminated by a bare '\n'
+		return bytes.TrimRight(line, "\n"), nil
+	}
+	return line[:len(line)-2], nil
+}
+
+/**
+ * @return the arguments of the next request, an empty slice for an empty
+ *         inline line, or io.EOF once the client is gone.
+ */
+func (this *respReader) readCommand() ([][]byte, error) {
+	line, err := this.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		if len(line) > MAX_INLINE_LENGTH {
+			return nil, fmt.Errorf("%w: too big inline request", errProtocol)
+		}
+		return bytes.Fields(line), nil
+	}
+
+	count, err := strconv.Atoi(string(line[1:]))
+	if err != nil || count > MAX_ARRAY_LENGTH {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+	args := make([][]byte, 0, max(count, 0))
+	for i := 0; i < count; i++ {
+		line, err := this.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%s'", errProtocol, line)
+		}
+		length, err := strconv.Atoi(string(line[1:]))
+		if err != nil || length < 0 || length > MAX_BULK_LENGTH {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+		arg := make([]byte, length+2)
+		if _, err := io.ReadFull(this.r, arg); err != nil {
+			return nil, err
+		}
+		if arg[length] != '\r' || arg[length+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", errProtocol)
+		}
+		args = append(args, arg[:length])
+	}
+	return args, nil
+}
+
+/**
+ * Writes RESP2 replies. Errors are sticky and reported by #flush().
+ */
+type respWriter struct {
+	w *bufio.Writer
+}
+
+func newRespWriter(w io.Writer) *respWriter {
+	return &respWriter{w: bufio.NewWriter(w)}
+}
+
+func (this *respWriter) writeSimpleString(s string) {
+	this.w.WriteByte('+')
+	this.w.WriteString(s)
+	this.w.WriteString("\r\n")
+}
+
+func (this *respWriter) writeError(s string) {
+	this.w.WriteByte('-')
+	this.w.WriteString(s)
+	this.w.WriteString("\r\n")
+}
+
+func (this *respWriter) writeInteger(n int64) {
+	this.w.WriteByte(':')
+	this.w.WriteString(strconv.FormatInt(n, 10))
+	this.w.WriteString("\r\n")
+}
+
+func (this *respWriter) writeBulk(b []byte) {
+	this.w.WriteByte('$')
+	this.w.WriteString(strconv.Itoa(len(b)))
+	this.w.WriteString("\r\n")
+	this.w.Write(b)
+	this.w.WriteString("\r\n")
+}
+
+func (this *respWriter) writeNil() {
+	this.w.WriteString("$-1\r\n")
+}
+
+func (this *respWriter) writeArrayHeader(n int) {
+	this.w.WriteByte('*')
+	this.w.WriteString(strconv.Itoa(n))
+	this.w.WriteString("\r\n")
+}
+
+func (this *respWriter) flush() error {
+	return this.w.Flush()
+}
@@ -0,0 +1,232 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/l0vest0rm/hll"
+)
+
+type command struct {
+	// minimum number of arguments, including the command name
+	arity   int
+	handler func(this *server, w *respWriter, args [][]byte)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":    {1, (*server).ping},
+		"PFADD":   {2, (*server).pfadd},
+		"PFCOUNT": {2, (*server).pfcount},
+		"PFMERGE": {2, (*server).pfmerge},
+		"GET":     {2, (*server).get},
+		"SET":     {3, (*server).set},
+		"DEL":     {2, (*server).del},
+		"KEYS":    {2, (*server).keys},
+		// sent by redis-cli and some client libraries on connect
+		"COMMAND": {1, (*server).command},
+	}
+}
+
+/**
+ * A Redis-compatible front end of an hll.Store. Elements given to PFADD are
+ * hashed with hll.HashBytes() and #seed, GET and SET exchange sketches in
+ * the storage format of hll.Hll#ToBytes().
+ */
+type server struct {
+	store  *hll.Store
+	config hll.StoreConfig
+	seed   uint32
+}
+
+func newServer(config hll.StoreConfig, seed uint32) (*server, error) {
+	store, err := hll.NewStore(config)
+	if err != nil {
+		return nil, err
+	}
+	return &server{store: store, config: config, seed: seed}, nil
+}
+
+/**
+ * Serves one client connection until it is closed or sends QUIT. A panic
+ * while serving it ends only this connection and is returned as an error.
+ */
+func (this *server) serve(conn io.ReadWriter) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	r := newRespReader(conn)
+	w := newRespWriter(conn)
+	for {
+		args, err := r.readCommand()
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				w.writeError("ERR " + err.Error())
+				w.flush()
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		name := strings.ToUpper(string(args[0]))
+		if name == "QUIT" {
+			w.writeSimpleString("OK")
+			return w.flush()
+		}
+		cmd, ok := commands[name]
+		if !ok {
+			w.writeError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		} else if len(args) < cmd.arity {
+			w.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		} else {
+			cmd.handler(this, w, args)
+		}
+
+		// NOTE:  flush only once all pipelined requests have been answered
+		if r.r.Buffered() == 0 {
+			if err := w.flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (this *server) ping(w *respWriter, args [][]byte) {
+	if len(args) > 1 {
+		w.writeBulk(args[1])
+		return
+	}
+	w.writeSimpleString("PONG")
+}
+
+func (this *server) pfadd(w *respWriter, args [][]byte) {
+	key := string(args[1])
+	changed := false
+	if len(args) == 2 {
+		// creates the key, as Redis does
+		_, exists := this.store.Get(key)
+		if !exists {
+			this.store.Merge(key, this.emptyHll())
+			changed = true
+		}
+	}
+	for _, element := range args[2:] {
+		if this.store.Add(key, hll.HashBytes(element, this.seed)) {
+			changed = true
+		}
+	}
+	if changed {
+		w.writeInteger(1)
+	} else {
+		w.writeInteger(0)
+	}
+}
+
+func (this *server) pfcount(w *respWriter, args [][]byte) {
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		keys[i] = string(arg)
+	}
+	w.writeInteger(int64(this.store.Cardinality(keys...)))
+}
+
+func (this *server) pfmerge(w *respWriter, args [][]byte) {
+	union := this.emptyHll()
+	for _, arg := range args[2:] {
+		if h, ok := this.store.Get(string(arg)); ok {
+			union.Union(h)
+		}
+	}
+	if err := this.store.Merge(string(args[1]), union); err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeSimpleString("OK")
+}
+
+func (this *server) get(w *respWriter, args [][]byte) {
+	h, ok := this.store.Get(string(args[1]))
+	if !ok {
+		w.writeNil()
+		return
+	}
+	w.writeBulk(h.ToBytes())
+}
+
+func (this *server) set(w *respWriter, args [][]byte) {
+	if len(args) > 3 {
+		w.writeError("ERR syntax error")
+		return
+	}
+	h, err := hll.NewHllFromBytes(args[2])
+	if err != nil {
+		w.writeError("INVALIDOBJ Corrupted HLL object detected: " + err.Error())
+		return
+	}
+	if err := this.store.Set(string(args[1]), h); err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeSimpleString("OK")
+}
+
+func (this *server) del(w *respWriter, args [][]byte) {
+	deleted := int64(0)
+	for _, arg := range args[1:] {
+		if this.store.Delete(string(arg)) {
+			deleted++
+		}
+	}
+	w.writeInteger(deleted)
+}
+
+func (this *server) keys(w *respWriter, args [][]byte) {
+	pattern := string(args[1])
+	var matched []string
+	for _, key := range this.store.Keys() {
+		if globMatch(pattern, key) {
+			matched = append(matched, key)
+		}
+	}
+	w.writeArrayHeader(len(matched))
+	for _, key := range matched {
+		w.writeBulk([]byte(key))
+	}
+}
+
+func (this *server) command(w *respWriter, args [][]byte) {
+	w.writeArrayHeader(0)
+}
+
+func (this *server) emptyHll() *hll.Hll {
+	h, _ := hll.NewHll5(this.config.Log2m, this.config.Regwidth, this.config.Expthresh, this.config.Sparseon, hll.EMPTY)
+	return h
+}
@@ -0,0 +1,157 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/l0vest0rm/hll"
+)
+
+func TestServer(t *testing.T) {
+	s, err := newServer(hll.StoreConfig{Log2m: 11, Regwidth: 5, Expthresh: -1, Sparseon: true}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, conn := net.Pipe()
+	go func() {
+		s.serve(conn)
+		conn.Close()
+	}()
+	defer client.Close()
+
+	r := bufio.NewReader(client)
+	send := func(args ...string) string {
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+		client.Write([]byte(b.String()))
+
+		line, _ := r.ReadString('\n')
+		if strings.HasPrefix(line, "$") && line != "$-1\r\n" {
+			var n int
+			fmt.Sscanf(line, "$%d", &n)
+			data := make([]byte, n+2)
+			for read := 0; read < len(data); {
+				m, _ := r.Read(data[read:])
+				read += m
+			}
+			return string(data[:n])
+		}
+		return strings.TrimRight(line, "\r\n")
+	}
+
+	cases := [][]string{
+		{"+PONG", "PING"},
+		{":1", "PFADD", "a", "x", "y", "z"},
+		{":0", "PFADD", "a", "x"},
+		{":3", "PFCOUNT", "a"},
+		{":1", "PFADD", "b", "z", "w"},
+		{":4", "PFCOUNT", "a", "b"},
+		{"+OK", "PFMERGE", "c", "a", "b"},
+		{":4", "PFCOUNT", "c"},
+		{"*3", "KEYS", "*"},
+		{":1", "PFADD", "url:/a/b", "x"},
+		{"*1", "KEYS", "url:*"},
+		{":1", "DEL", "url:/a/b"},
+		{":2", "DEL", "a", "b", "missing"},
+		{"$-1", "GET", "a"},
+		{"-ERR wrong number of arguments for 'pfcount' command", "PFCOUNT"},
+	}
+	for _, c := range cases {
+		if reply := send(c[1:]...); reply != c[0] {
+			t.Errorf("%v: got %q, expected %q", c[1:], reply, c[0])
+		}
+		if c[1] == "KEYS" {
+			var n int
+			fmt.Sscanf(c[0], "*%d", &n)
+			for i := 0; i < n; i++ {
+				r.ReadString('\n')
+				r.ReadString('\n')
+			}
+		}
+	}
+
+	sketch := send("GET", "c")
+	if reply := send("SET", "d", sketch); reply != "+OK" {
+		t.Errorf("SET: %q", reply)
+	}
+	if reply := send("PFCOUNT", "d"); reply != ":4" {
+		t.Errorf("PFCOUNT after SET: %q", reply)
+	}
+
+	h, _ := hll.NewHll5(11, 5, 0, false, hll.FULL)
+	h.Add(1)
+	full := string(h.ToBytes())
+	for _, c := range []struct {
+		name   string
+		sketch string
+	}{
+		{"type 0", "\x10\x8b\x7f"},
+		{"type 5", "\x15\x8b\x7f"},
+		{"truncated EXPLICIT", sketch[:len(sketch)-1]},
+		{"truncated FULL", full[:len(full)-1]},
+	} {
+		if reply := send("SET", "e", c.sketch); !strings.HasPrefix(reply, "-INVALIDOBJ ") {
+			t.Errorf("SET %s: %q", c.name, reply)
+		}
+	}
+	if reply := send("PING"); reply != "+PONG" {
+		t.Errorf("PING after invalid SETs: %q", reply)
+	}
+	if reply := send("SET", "e", full); reply != "+OK" {
+		t.Errorf("SET FULL: %q", reply)
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"*", "url:/a/b", true},
+		{"url:*", "url:/a/b", true},
+		{"url:*/b", "url:/a/b", true},
+		{"url:/?/b", "url:/a/b", true},
+		{"url:/a", "url:/a/b", false},
+		{"*a*b*", "xaybz", true},
+		{"*a*b", "xaybz", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"h[\\]]llo", "h]llo", true},
+		{"h[ab", "ha", true},
+		{"", "", true},
+		{"?", "", false},
+	} {
+		if match := globMatch(c.pattern, c.s); match != c.match {
+			t.Errorf("globMatch(%q, %q) = %v", c.pattern, c.s, match)
+		}
+	}
+}
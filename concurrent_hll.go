@@ -0,0 +1,212 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// number of locks that serialize the updates of FULL registers spanning
+	// two words. Registers are spread over them by index.
+	CONCURRENT_SPAN_LOCKS = 64
+)
+
+/**
+ * A HLL that may be used from multiple goroutines at once.
+ *
+ * While EMPTY, EXPLICIT or SPARSE all operations are serialized by a lock as
+ * the hash tables are mutated and the storage may be swapped on promotion.
+ * Once FULL the storage never changes again, so #Add() updates the registers
+ * of the bit vector with compare-and-swap instead and does not contend on the
+ * lock.
+ */
+type ConcurrentHll struct {
+	// guards hll while it is not FULL
+	lock sync.Mutex
+	hll  *Hll
+	// set once hll is FULL, after which its registers are only accessed
+	// atomically, see BitVector#setMaxRegisterAtomic()
+	full atomic.Bool
+
+	spanLocks [CONCURRENT_SPAN_LOCKS]sync.Mutex
+}
+
+/**
+ * Creates an EMPTY concurrent HLL, see NewHll().
+ */
+func NewConcurrentHll(log2m uint, regwidth uint) (*ConcurrentHll, error) {
+	return NewConcurrentHll5(log2m, regwidth, -1, true, EMPTY)
+}
+
+/**
+ * Creates a concurrent HLL, see NewHll5().
+ */
+func NewConcurrentHll5(log2m uint, regwidth uint, expthresh int, sparseon bool, hllType Type) (*ConcurrentHll, error) {
+	h, err := NewHll5(log2m, regwidth, expthresh, sparseon, hllType)
+	if err != nil {
+		return nil, err
+	}
+	return NewConcurrentHllFrom(h), nil
+}
+
+/**
+ * Wraps an existing HLL. The HLL must not be used directly afterwards.
+ */
+func NewConcurrentHllFrom(h *Hll) *ConcurrentHll {
+	this := &ConcurrentHll{hll: h}
+	this.markIfFull()
+	return this
+}
+
+/**
+ * Adds <code>rawValue</code> directly to the HLL, see Hll#Add().
+ */
+func (this *ConcurrentHll) Add(rawValue uint64) {
+	if this.full.Load() {
+		this.addFull(rawValue)
+		return
+	}
+
+	this.lock.Lock()
+	if this.full.Load() {
+		// promoted by another goroutine while waiting for the lock
+		this.lock.Unlock()
+		this.addFull(rawValue)
+		return
+	}
+	this.hll.Add(rawValue)
+	this.markIfFull()
+	this.lock.Unlock()
+}
+
+/**
+ * Computes the union of this HLL and <code>other</code> and stores it in this
+ * instance, see Hll#Union(). <code>other</code> must not be modified
+ * concurrently.
+ */
+func (this *ConcurrentHll) Union(other *Hll) {
+	if !this.full.Load() {
+		this.lock.Lock()
+		if !this.full.Load() {
+			this.hll.Union(other)
+			this.markIfFull()
+			this.lock.Unlock()
+			return
+		}
+		this.lock.Unlock()
+	}
+
+	switch other.hllType {
+	case EMPTY:
+	case EXPLICIT:
+		it := other.explicitStorage.iterator()
+		for it.HasNext() {
+			this.addFull(it.Next())
+		}
+	default:
+		other.forEachRegister(func(registerIndex uint32, registerValue byte) {
+			this.setMaxRegister(registerIndex, registerValue)
+		})
+	}
+}
+
+/**
+ * @return a point-in-time copy of the HLL, which is not shared with this
+ *         instance. Adds that run concurrently may or may not be included.
+ */
+func (this *ConcurrentHll) Snapshot() *Hll {
+	if !this.full.Load() {
+		this.lock.Lock()
+		if !this.full.Load() {
+			defer this.lock.Unlock()
+			return this.hll.Clone()
+		}
+		this.lock.Unlock()
+	}
+
+	// NOTE:  all but the registers are immutable once FULL
+	c := this.hll.emptyCopy()
+	c.hllType = FULL
+	c.probabilisticStorage = this.hll.probabilisticStorage.cloneAtomic(this.spanLock)
+	return c
+}
+
+/**
+ * Computes the union of <code>target</code> and this HLL and stores it in
+ * <code>target</code>, like target.Union(this.Snapshot()) but without
+ * copying the registers once both are FULL. Adds that run concurrently may
+ * or may not be included.
+ */
+func (this *ConcurrentHll) unionInto(target *Hll) {
+	if !this.full.Load() {
+		this.lock.Lock()
+		if !this.full.Load() {
+			defer this.lock.Unlock()
+			target.Union(this.hll)
+			return
+		}
+		this.lock.Unlock()
+	}
+
+	if target.hllType != FULL {
+		target.Union(this.Snapshot())
+		return
+	}
+	target.probabilisticStorage.unionWithAtomic(this.hll.probabilisticStorage, this.spanLock)
+	target.registerHistogram = nil
+}
+
+/**
+ * @return the cardinality of a snapshot of the HLL, see #Snapshot().
+ */
+func (this *ConcurrentHll) Cardinality() uint {
+	return this.Snapshot().Cardinality()
+}
+
+/**
+ * @return the serialized snapshot of the HLL, see #Snapshot().
+ */
+func (this *ConcurrentHll) ToBytes() []byte {
+	return this.Snapshot().ToBytes()
+}
+
+func (this *ConcurrentHll) markIfFull() {
+	if this.hll.hllType == FULL {
+		// NOTE:  the atomic updates do not maintain the register counts,
+		//        snapshots recompute them
+		this.hll.registerHistogram = nil
+		this.full.Store(true)
+	}
+}
+
+func (this *ConcurrentHll) addFull(rawValue uint64) {
+	registerIndex, registerValue := this.hll.registerFor(rawValue)
+	if registerValue == 0 {
+		return
+	}
+	this.setMaxRegister(registerIndex, registerValue)
+}
+
+func (this *ConcurrentHll) setMaxRegister(registerIndex uint32, registerValue byte) {
+	this.hll.probabilisticStorage.setMaxRegisterAtomic(uint64(registerIndex), uint64(registerValue), this.spanLock(uint64(registerIndex)))
+}
+
+func (this *ConcurrentHll) spanLock(registerIndex uint64) sync.Locker {
+	return &this.spanLocks[registerIndex%CONCURRENT_SPAN_LOCKS]
+}
@@ -0,0 +1,101 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentHll(t *testing.T) {
+	for _, regwidth := range []uint{4, 5, 6} {
+		c, err := NewConcurrentHll(11, regwidth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := NewHll(11, regwidth)
+
+		const goroutines = 8
+		values := make([][]uint64, goroutines)
+		for i := range values {
+			r := rand.New(rand.NewSource(int64(i)))
+			for j := 0; j < 20000; j++ {
+				values[i] = append(values[i], r.Uint64())
+			}
+			for _, v := range values[i] {
+				expected.Add(v)
+			}
+		}
+
+		// snapshots taken while the registers are being raised
+		var snapshots []*Hll
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for len(snapshots) < 50 {
+				snapshots = append(snapshots, c.Snapshot())
+			}
+		}()
+
+		var wg sync.WaitGroup
+		for i := 0; i < goroutines; i++ {
+			wg.Add(1)
+			go func(values []uint64) {
+				defer wg.Done()
+				for j, v := range values {
+					c.Add(v)
+					if j%1000 == 0 {
+						c.Cardinality()
+					}
+				}
+			}(values[i])
+		}
+		wg.Wait()
+		<-done
+
+		if !bytes.Equal(c.ToBytes(), expected.ToBytes()) {
+			t.Errorf("regwidth %d: concurrent adds differ from sequential adds", regwidth)
+		}
+		// a register is never read as more than it was raised to, nor less
+		// than an earlier snapshot read
+		previous := expected.emptyCopy()
+		for _, snapshot := range snapshots {
+			if snapshot.hllType != FULL {
+				continue
+			}
+			for registerIndex := uint64(0); registerIndex < uint64(expected.m); registerIndex++ {
+				registerValue := snapshot.probabilisticStorage.getRegister(registerIndex)
+				if registerValue > expected.probabilisticStorage.getRegister(registerIndex) || previous.hllType == FULL && registerValue < previous.probabilisticStorage.getRegister(registerIndex) {
+					t.Fatalf("regwidth %d: register %d torn to %d", regwidth, registerIndex, registerValue)
+				}
+			}
+			previous = snapshot
+		}
+
+		other, _ := NewHll(11, regwidth)
+		for j := 0; j < 50; j++ {
+			other.Add(rand.Uint64())
+		}
+		c.Union(other)
+		expected.Union(other)
+		if !bytes.Equal(c.ToBytes(), expected.ToBytes()) {
+			t.Errorf("regwidth %d: union differs from sequential union", regwidth)
+		}
+	}
+}
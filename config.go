@@ -0,0 +1,307 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+var typeNames = map[Type]string{
+	UNDEFINED: "UNDEFINED",
+	EMPTY:     "EMPTY",
+	EXPLICIT:  "EXPLICIT",
+	SPARSE:    "SPARSE",
+	FULL:      "FULL",
+}
+
+func (this Type) String() string {
+	if name, ok := typeNames[this]; ok {
+		return name
+	}
+	return fmt.Sprintf("Type(%d)", int(this))
+}
+
+func (this Type) MarshalText() ([]byte, error) {
+	if _, ok := typeNames[this]; !ok {
+		return nil, fmt.Errorf("unknown HLL type %d", int(this))
+	}
+	return []byte(this.String()), nil
+}
+
+/**
+ * Parses the name of a type, in any case.
+ */
+func (this *Type) UnmarshalText(text []byte) error {
+	for hllType, name := range typeNames {
+		if strings.EqualFold(name, string(text)) {
+			*this = hllType
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown HLL type %q, expected EMPTY, EXPLICIT, SPARSE or FULL", text)
+}
+
+/**
+ * The parameters of #New(). The zero values of Expthresh and Sparseon turn
+ * the EXPLICIT and SPARSE representations off, so configurations read from
+ * JSON or YAML should start from #DefaultConfig():
+ *
+ *     config := hll.DefaultConfig()
+ *     err := json.Unmarshal(data, &config)
+ *     h, err := hll.New(hll.WithConfig(config))
+ */
+type Config struct {
+	// see #NewHll5()
+	Log2m     uint `json:"log2m" yaml:"log2m"`
+	Regwidth  uint `json:"regwidth" yaml:"regwidth"`
+	Expthresh int  `json:"expthresh" yaml:"expthresh"`
+	Sparseon  bool `json:"sparseon" yaml:"sparseon"`
+	// the type to start at. UNDEFINED picks it from CapacityHint as
+	// #NewHll3() does, EMPTY without one.
+	Type Type `json:"type" yaml:"type"`
+	// see #NewHll6()
+	SparsePrecision uint      `json:"sparse_precision" yaml:"sparse_precision"`
+	Estimator       Estimator `json:"estimator" yaml:"estimator"`
+	// the expected number of distinct values, zero if unknown
+	CapacityHint uint `json:"capacity_hint" yaml:"capacity_hint"`
+	// hash function of #AddBytes() and #AddString(), nil for HashBytes()
+	// with seed zero
+	Hasher func([]byte) uint64 `json:"-" yaml:"-"`
+}
+
+/**
+ * @return the configuration #New() starts from: 2^11 registers of 5 bits
+ *         and automatic EXPLICIT and SPARSE thresholds, as in
+ *         postgresql-hll's defaults.
+ */
+func DefaultConfig() Config {
+	return Config{Log2m: 11, Regwidth: 5, Expthresh: -1, Sparseon: true}
+}
+
+/**
+ * A parameter of #New().
+ */
+type Option func(*Config)
+
+/**
+ * Replaces the configuration set by the preceding options.
+ */
+func WithConfig(config Config) Option {
+	return func(this *Config) { *this = config }
+}
+
+func WithLog2m(log2m uint) Option {
+	return func(this *Config) { this.Log2m = log2m }
+}
+
+func WithRegwidth(regwidth uint) Option {
+	return func(this *Config) { this.Regwidth = regwidth }
+}
+
+/**
+ * @param expthresh see #NewHll5(): -1 for automatic, 0 for off, otherwise
+ *        promote from EXPLICIT at 2^(expthresh - 1) values.
+ */
+func WithExpthresh(expthresh int) Option {
+	return func(this *Config) { this.Expthresh = expthresh }
+}
+
+func WithSparse(sparseon bool) Option {
+	return func(this *Config) { this.Sparseon = sparseon }
+}
+
+func WithSparsePrecision(sparsePrecision uint) Option {
+	return func(this *Config) { this.SparsePrecision = sparsePrecision }
+}
+
+func WithType(hllType Type) Option {
+	return func(this *Config) { this.Type = hllType }
+}
+
+/**
+ * @param estimator the estimator of #Cardinality(). It is not part of
+ *        #ToBytes(), so an HLL read by #NewHllFromBytes(), as by Store,
+ *        Series and DurableStore, uses CLASSIC_ESTIMATOR.
+ */
+func WithEstimator(estimator Estimator) Option {
+	return func(this *Config) { this.Estimator = estimator }
+}
+
+/**
+ * @param expected the expected number of distinct values. The HLL starts
+ *        in the representation that holds them, unless #WithType() is
+ *        given, and its EXPLICIT storage is sized for them.
+ */
+func WithCapacity(expected uint) Option {
+	return func(this *Config) { this.CapacityHint = expected }
+}
+
+/**
+ * @param hasher the hash function of #AddBytes() and #AddString(). The
+ *        hashes must be uniformly distributed over 64 bits. HLLs hashing
+ *        differently must not be unioned, which #Union() cannot check. It
+ *        is not part of #ToBytes(), so an HLL read by #NewHllFromBytes(),
+ *        as by Store, Series and DurableStore, hashes with HashBytes().
+ */
+func WithHasher(hasher func([]byte) uint64) Option {
+	return func(this *Config) { this.Hasher = hasher }
+}
+
+/**
+ * Creates an HLL from #DefaultConfig() and the options, e.g.
+ *
+ *     h, err := hll.New(hll.WithLog2m(14), hll.WithEstimator(hll.ERTL_ESTIMATOR))
+ *
+ * It fails for invalid parameters and for a type whose representation is
+ * turned off, such as EXPLICIT with an expthresh of 0.
+ */
+func New(opts ...Option) (*Hll, error) {
+	config := DefaultConfig()
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	if _, ok := estimatorNames[config.Estimator]; !ok {
+		return nil, fmt.Errorf("unknown estimator %d", int(config.Estimator))
+	}
+	if config.Type < UNDEFINED || config.Type > FULL {
+		return nil, fmt.Errorf("unknown HLL type %d", int(config.Type))
+	}
+
+	this := &Hll{estimator: config.Estimator, hasher: config.Hasher}
+	err := this.initParams(config.Log2m, config.Regwidth, config.Expthresh, config.Sparseon)
+	if err != nil {
+		return nil, err
+	}
+	err = this.setSparsePrecision(config.SparsePrecision)
+	if err != nil {
+		return nil, err
+	}
+
+	hllType := config.Type
+	if hllType == EXPLICIT && this.explicitOff {
+		return nil, fmt.Errorf("type EXPLICIT but the EXPLICIT representation is off (expthresh 0)")
+	}
+	if hllType == SPARSE && this.sparseOff {
+		return nil, fmt.Errorf("type SPARSE but the SPARSE representation is off")
+	}
+	if hllType == UNDEFINED {
+		// NOTE:  as in #NewHll3()
+		if config.CapacityHint == 0 {
+			hllType = EMPTY
+		} else if config.CapacityHint < this.explicitThreshold {
+			hllType = EXPLICIT
+		} else if !this.sparseOff && config.CapacityHint < this.sparseThreshold {
+			hllType = SPARSE
+		} else {
+			hllType = FULL
+		}
+	}
+	this.initializeStorage(hllType)
+	if hllType == EXPLICIT && config.CapacityHint > 0 {
+		this.explicitStorage.ensureCapacity(min(config.CapacityHint, this.explicitThreshold+1))
+	}
+
+	return this, nil
+}
+
+/**
+ * @return the parameters of this HLL, from which #New() creates an HLL
+ *         like it in its current representation. CapacityHint is not kept
+ *         and is zero.
+ */
+func (this *Hll) Config() Config {
+	var expthresh int
+	if this.explicitOff {
+		expthresh = 0
+	} else if this.explicitAuto {
+		expthresh = -1
+	} else {
+		expthresh = int(math.Log2(float64(this.explicitThreshold))) + 1
+	}
+	return Config{
+		Log2m:           this.log2m,
+		Regwidth:        this.regwidth,
+		Expthresh:       expthresh,
+		Sparseon:        !this.sparseOff,
+		Type:            this.hllType,
+		SparsePrecision: this.sparsePrecision,
+		Estimator:       this.estimator,
+		Hasher:          this.hasher,
+	}
+}
+
+/**
+ * @return log-base-2 of the number of registers.
+ */
+func (this *Hll) Log2m() uint {
+	return this.log2m
+}
+
+/**
+ * @return the width of a register in bits.
+ */
+func (this *Hll) Regwidth() uint {
+	return this.regwidth
+}
+
+/**
+ * @return the number of values above which an EXPLICIT HLL is promoted,
+ *         zero if the EXPLICIT representation is off.
+ */
+func (this *Hll) ExplicitThreshold() uint {
+	return this.explicitThreshold
+}
+
+/**
+ * @return the number of registers above which a SPARSE HLL is promoted to
+ *         FULL, zero if the SPARSE representation is off.
+ */
+func (this *Hll) SparseThreshold() uint {
+	return this.sparseThreshold
+}
+
+/**
+ * @return the estimator of the SPARSE and FULL cardinality.
+ */
+func (this *Hll) Estimator() Estimator {
+	return this.estimator
+}
+
+/**
+ * Hashes data with the hasher of #WithHasher() and adds the hash.
+ */
+func (this *Hll) AddBytes(data []byte) {
+	if this.hasher == nil {
+		this.Add(HashBytes(data, 0))
+	} else {
+		this.Add(this.hasher(data))
+	}
+}
+
+/**
+ * Same as #AddBytes() for a string.
+ */
+func (this *Hll) AddString(s string) {
+	if this.hasher == nil {
+		this.Add(HashString(s, 0))
+	} else {
+		this.Add(this.hasher([]byte(s)))
+	}
+}
@@ -0,0 +1,150 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	h, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reference, _ := NewHll(11, 5)
+	if !bytes.Equal(h.ToBytes(), reference.ToBytes()) || h.Type() != EMPTY {
+		t.Errorf("New() = %v, expected %v", h, reference)
+	}
+	if h.Log2m() != 11 || h.Regwidth() != 5 || h.ExplicitThreshold() != 160 || h.SparseThreshold() != reference.sparseThreshold {
+		t.Errorf("accessors of %v", h)
+	}
+
+	h, err = New(WithLog2m(12), WithRegwidth(6), WithExpthresh(0), WithSparse(false), WithType(FULL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reference, _ = NewHll5(12, 6, 0, false, FULL)
+	if !bytes.Equal(h.ToBytes(), reference.ToBytes()) || h.ExplicitThreshold() != 0 || h.SparseThreshold() != 0 {
+		t.Errorf("New() = %v, expected %v", h, reference)
+	}
+
+	for hint, hllType := range map[uint]Type{0: EMPTY, 100: EXPLICIT, 300: SPARSE, 5000: FULL} {
+		if h, _ := New(WithCapacity(hint)); h.Type() != hllType {
+			t.Errorf("capacity %d: %v, expected %v", hint, h.Type(), hllType)
+		}
+	}
+
+	for _, opts := range [][]Option{
+		{WithLog2m(3)},
+		{WithRegwidth(9)},
+		{WithExpthresh(19)},
+		{WithSparsePrecision(11)},
+		{WithType(Type(7))},
+		{WithEstimator(Estimator(2))},
+		{WithExpthresh(0), WithType(EXPLICIT)},
+		{WithSparse(false), WithType(SPARSE)},
+	} {
+		if _, err := New(opts...); err == nil {
+			t.Errorf("expected an error for %+v", opts)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	config := DefaultConfig()
+	if err := json.Unmarshal([]byte(`{"log2m": 14, "expthresh": 8, "type": "sparse", "estimator": "ertl"}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	h, err := New(WithConfig(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Log2m() != 14 || h.Regwidth() != 5 || h.ExplicitThreshold() != 128 || h.Type() != SPARSE || h.Estimator() != ERTL_ESTIMATOR {
+		t.Errorf("New(%+v) = %v", config, h)
+	}
+
+	data, err := json.Marshal(h.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"log2m":14,"regwidth":5,"expthresh":8,"sparseon":true,"type":"SPARSE","sparse_precision":0,"estimator":"ertl","capacity_hint":0}` {
+		t.Errorf("Config() = %s", data)
+	}
+
+	if err := json.Unmarshal([]byte(`{"type": "dense"}`), &config); err == nil {
+		t.Errorf("expected an unknown type")
+	}
+	if err := json.Unmarshal([]byte(`{"estimator": "loglog"}`), &config); err == nil {
+		t.Errorf("expected an unknown estimator")
+	}
+}
+
+func TestHasher(t *testing.T) {
+	h, _ := New()
+	h.AddString("a")
+	h.AddBytes([]byte("b"))
+	if !h.explicitStorage.Contains(HashString("a", 0)) || !h.explicitStorage.Contains(HashBytes([]byte("b"), 0)) {
+		t.Errorf("expected murmur3 hashes")
+	}
+
+	h, _ = New(WithHasher(func(data []byte) uint64 { return uint64(len(data)) }))
+	h.AddString("abc")
+	if !h.explicitStorage.Contains(3) || !h.Clone().explicitStorage.Contains(3) {
+		t.Errorf("expected the hasher to be used")
+	}
+	c := h.Clone()
+	c.AddString("abcd")
+	if !c.explicitStorage.Contains(4) {
+		t.Errorf("expected the clone to keep the hasher")
+	}
+}
+
+func TestErtlEstimator(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+	classic, _ := New(WithLog2m(10), WithExpthresh(0), WithSparse(false), WithType(FULL))
+	ertl, _ := New(WithLog2m(10), WithExpthresh(0), WithSparse(false), WithType(FULL), WithEstimator(ERTL_ESTIMATOR))
+	if ertl.Cardinality() != 0 {
+		t.Errorf("empty registers: %d", ertl.Cardinality())
+	}
+
+	n := 0
+	for _, cardinality := range []int{10, 100, 1000, 3000, 10000, 100000, 1000000} {
+		for ; n < cardinality; n++ {
+			v := r.Uint64()
+			classic.Add(v)
+			ertl.Add(v)
+		}
+		// NOTE:  the cardinality is rounded up
+		if math.Abs(float64(ertl.Cardinality())-float64(n)) > 3*ertl.StandardError()*float64(n)+1 {
+			t.Errorf("%d values: estimated %d (classic %d)", n, ertl.Cardinality(), classic.Cardinality())
+		}
+	}
+
+	// registers of 2 bits saturate well before 20000 values, where the large
+	// range correction of the classic estimator breaks down
+	small, _ := New(WithLog2m(10), WithRegwidth(2), WithExpthresh(0), WithSparse(false), WithType(FULL), WithEstimator(ERTL_ESTIMATOR))
+	for i := 0; i < 20000; i++ {
+		small.Add(r.Uint64())
+	}
+	if relativeError := math.Abs(float64(small.Cardinality())-20000) / 20000; relativeError > 0.2 {
+		t.Errorf("saturated registers: estimated %d", small.Cardinality())
+	}
+}
@@ -0,0 +1,228 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// magic bytes and version at the start of a container file
+	CONTAINER_MAGIC   = "HLLC"
+	CONTAINER_VERSION = 1
+
+	// marker bytes preceding every entry and the trailer
+	containerEntryMarker = 1
+	containerEndMarker   = 0
+
+	// largest key or sketch accepted when reading a container
+	MAXIMUM_CONTAINER_FIELD_LENGTH = 1 << 30
+)
+
+var ErrCorruptContainer = errors.New("corrupt HLL container")
+
+/**
+ * Writes a container: a sequence of keyed HLLs in the storage format of
+ * Hll#ToBytes().<p/>
+ *
+ * <pre>
+ * "HLLC" version
+ * (0x01 uvarint(len(key)) key uvarint(len(hll)) hll)*
+ * 0x00 crc32(everything before)
+ * </pre>
+ */
+type ContainerWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	buf [binary.MaxVarintLen64]byte
+}
+
+func NewContainerWriter(w io.Writer) (*ContainerWriter, error) {
+	this := &ContainerWriter{}
+	this.crc = crc32.NewIEEE()
+	this.w = bufio.NewWriter(io.MultiWriter(w, this.crc))
+	this.w.WriteString(CONTAINER_MAGIC)
+	if err := this.w.WriteByte(CONTAINER_VERSION); err != nil {
+		return nil, err
+	}
+	return this, nil
+}
+
+func (this *ContainerWriter) Write(key string, h *Hll) error {
+	return this.WriteBytes(key, h.ToBytes())
+}
+
+/**
+ * Same as #Write() for an already serialized HLL.
+ */
+func (this *ContainerWriter) WriteBytes(key string, data []byte) error {
+	this.w.WriteByte(containerEntryMarker)
+	this.w.Write(this.buf[:binary.PutUvarint(this.buf[:], uint64(len(key)))])
+	this.w.WriteString(key)
+	this.w.Write(this.buf[:binary.PutUvarint(this.buf[:], uint64(len(data)))])
+	_, err := this.w.Write(data)
+	return err
+}
+
+/**
+ * Writes the trailer and flushes. The underlying writer is not closed.
+ */
+func (this *ContainerWriter) Close() error {
+	if err := this.w.WriteByte(containerEndMarker); err != nil {
+		return err
+	}
+	if err := this.w.Flush(); err != nil {
+		return err
+	}
+	// NOTE:  the checksum is taken after the flush so that it covers the end
+	//        marker; it then (harmlessly) checksums itself
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], this.crc.Sum32())
+	this.w.Write(sum[:])
+	return this.w.Flush()
+}
+
+/**
+ * Reads a container written by {@link ContainerWriter}.
+ */
+type ContainerReader struct {
+	r    *crcReader
+	done bool
+}
+
+func NewContainerReader(r io.Reader) (*ContainerReader, error) {
+	this := &ContainerReader{}
+	this.r = &crcReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+
+	header := make([]byte, len(CONTAINER_MAGIC)+1)
+	if _, err := io.ReadFull(this.r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(CONTAINER_MAGIC)], []byte(CONTAINER_MAGIC)) {
+		return nil, ErrCorruptContainer
+	}
+	if header[len(CONTAINER_MAGIC)] != CONTAINER_VERSION {
+		return nil, fmt.Errorf("unsupported HLL container version %d", header[len(CONTAINER_MAGIC)])
+	}
+	return this, nil
+}
+
+/**
+ * Reads from the underlying reader and checksums exactly what was read.
+ */
+type crcReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (this *crcReader) Read(p []byte) (int, error) {
+	n, err := this.r.Read(p)
+	this.crc.Write(p[:n])
+	return n, err
+}
+
+func (this *crcReader) ReadByte() (byte, error) {
+	b, err := this.r.ReadByte()
+	if err == nil {
+		this.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+/**
+ * @return the next key and HLL, or io.EOF after the last one once the
+ *         checksum has been verified.
+ */
+func (this *ContainerReader) Next() (string, *Hll, error) {
+	key, data, err := this.NextBytes()
+	if err != nil {
+		return "", nil, err
+	}
+	h, err := NewHllFromBytes(data)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, h, nil
+}
+
+/**
+ * Same as #Next() but returns the HLL still serialized.
+ */
+func (this *ContainerReader) NextBytes() (string, []byte, error) {
+	if this.done {
+		return "", nil, io.EOF
+	}
+
+	marker, err := this.r.ReadByte()
+	if err != nil {
+		return "", nil, unexpectedEOF(err)
+	}
+	switch marker {
+	case containerEntryMarker:
+		key, err := this.readField()
+		if err != nil {
+			return "", nil, err
+		}
+		data, err := this.readField()
+		if err != nil {
+			return "", nil, err
+		}
+		return string(key), data, nil
+	case containerEndMarker:
+		this.done = true
+		expected := this.r.crc.Sum32()
+		var sum [4]byte
+		if _, err := io.ReadFull(this.r.r, sum[:]); err != nil {
+			return "", nil, unexpectedEOF(err)
+		}
+		if binary.BigEndian.Uint32(sum[:]) != expected {
+			return "", nil, ErrCorruptContainer
+		}
+		return "", nil, io.EOF
+	default:
+		return "", nil, ErrCorruptContainer
+	}
+}
+
+func (this *ContainerReader) readField() ([]byte, error) {
+	length, err := binary.ReadUvarint(this.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if length > MAXIMUM_CONTAINER_FIELD_LENGTH {
+		return nil, ErrCorruptContainer
+	}
+	field := make([]byte, length)
+	if _, err := io.ReadFull(this.r, field); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return field, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
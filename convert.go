@@ -0,0 +1,141 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"errors"
+	"fmt"
+)
+
+/**
+ * Promotes this HLL to FULL, e.g. for a consumer that only reads FULL HLLs.
+ * The HLL stays FULL.
+ */
+func (this *Hll) ToFull() {
+	switch this.hllType {
+	case EMPTY:
+		this.initializeStorage(FULL)
+	case EXPLICIT:
+		this.promoteExplicitTo(FULL)
+	case SPARSE:
+		this.promoteSparse()
+	}
+}
+
+/**
+ * Converts this HLL to SPARSE. EXPLICIT values are added to the registers
+ * they set. The registers of a FULL HLL are kept as they are, which is only
+ * possible if the SPARSE registers are not of a higher precision, see
+ * #NewHll6().
+ *
+ * NOTE:  a SPARSE HLL with more registers set than the sparse threshold is
+ *        promoted to FULL again by the next #Add().
+ */
+func (this *Hll) ToSparse() error {
+	if this.sparseOff {
+		return errors.New("the SPARSE representation is off")
+	}
+	switch this.hllType {
+	case EMPTY:
+		this.initializeStorage(SPARSE)
+	case EXPLICIT:
+		this.promoteExplicitTo(SPARSE)
+	case FULL:
+		if this.sparsePrecision != 0 {
+			return errors.New("FULL registers cannot be converted to SPARSE registers of a higher precision")
+		}
+		registers := this.Registers()
+		this.initializeStorage(SPARSE)
+		for registerIndex, registerValue := range registers {
+			if registerValue != 0 {
+				this.sparseProbabilisticStorage.setMax(uint32(registerIndex), registerValue)
+			}
+		}
+		this.probabilisticStorage = nil
+	}
+	return nil
+}
+
+/**
+ * Converts an EMPTY HLL to EXPLICIT. The values of SPARSE and FULL HLLs
+ * cannot be recovered from their registers.
+ */
+func (this *Hll) ToExplicit() error {
+	if this.explicitOff {
+		return errors.New("the EXPLICIT representation is off")
+	}
+	switch this.hllType {
+	case EMPTY:
+		this.initializeStorage(EXPLICIT)
+	case SPARSE, FULL:
+		return fmt.Errorf("the values of a %s HLL cannot be converted to EXPLICIT", this.hllType)
+	}
+	return nil
+}
+
+/**
+ * Converts this HLL to whichever of EXPLICIT, SPARSE and FULL that its
+ * contents can be held in gives the smallest #ToBytes(), e.g. before
+ * archiving it. The type is kept on a tie. EMPTY HLLs are left as they are.
+ *
+ * @return the type of this HLL.
+ */
+func (this *Hll) Compact() Type {
+	if this.hllType == EMPTY {
+		return EMPTY
+	}
+	sizes := make(map[Type]uint)
+	words := func(wordLength uint, wordCount uint) uint {
+		return this.headerByteCount() + (wordLength*wordCount+BITS_PER_BYTE-1)/BITS_PER_BYTE
+	}
+
+	sizes[FULL] = words(this.regwidth, this.m)
+	switch this.hllType {
+	case EXPLICIT:
+		sizes[EXPLICIT] = words(BITS_PER_LONG, this.explicitStorage.Size())
+		if !this.sparseOff {
+			registers := make(map[uint32]struct{})
+			it := this.explicitStorage.iterator()
+			for it.HasNext() {
+				if registerIndex, p_w := this.sparseRegisterFor(it.Next()); p_w != 0 {
+					registers[registerIndex] = struct{}{}
+				}
+			}
+			sizes[SPARSE] = words(this.shortWordLength, uint(len(registers)))
+		}
+	case SPARSE:
+		sizes[SPARSE] = words(this.shortWordLength, this.sparseProbabilisticStorage.Size())
+	case FULL:
+		if !this.sparseOff && this.sparsePrecision == 0 {
+			sizes[SPARSE] = words(this.shortWordLength, this.m-uint(this.registerCounts()[0]))
+		}
+	}
+
+	smallest := this.hllType
+	for _, hllType := range []Type{EXPLICIT, SPARSE, FULL} {
+		if size, ok := sizes[hllType]; ok && size < sizes[smallest] {
+			smallest = hllType
+		}
+	}
+	switch smallest {
+	case SPARSE:
+		this.ToSparse()
+	case FULL:
+		this.ToFull()
+	}
+	return this.hllType
+}
@@ -0,0 +1,219 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"fmt"
+	"maps"
+	"math/bits"
+	"slices"
+)
+
+/**
+ * Starts tracking the registers that are raised, so that the next #Delta()
+ * only contains what changed since. This takes one bit per register, which
+ * is allocated on the first change.
+ */
+func (this *Hll) Checkpoint() {
+	this.checkpointed = true
+	clear(this.dirty)
+}
+
+/**
+ * Serializes the changes since the last #Checkpoint() (or all contents if
+ * there was none). The result is in the format of #ToBytes(): an EXPLICIT
+ * HLL of the values added since the checkpoint while this HLL is still
+ * EXPLICIT, otherwise a SPARSE HLL of the registers that were raised, as
+ * (index, value) short words.<p/>
+ *
+ * Applying a delta is a union, so deltas may be applied more than once or
+ * out of order and the receiver still converges to this HLL.
+ *
+ * @return the serialized delta. This will never be <code>nil</code>.
+ * @see #ApplyDelta()
+ */
+func (this *Hll) Delta() []byte {
+	delta := this.emptyCopy()
+	switch this.hllType {
+	case EMPTY:
+		// nothing changed
+	case EXPLICIT:
+		// NOTE:  the values are tracked by the registers they set, so this
+		//        may also include older values of a raised register
+		delta.initializeStorage(EXPLICIT)
+		it := this.explicitStorage.iterator()
+		for it.HasNext() {
+			k := it.Next()
+			if this.isDirty(uint32(k & this.mBitsMask)) {
+				delta.explicitStorage.add(k)
+			}
+		}
+	case SPARSE, FULL:
+		// NOTE:  the registers are reported folded down, see
+		//        #forEachRegister()
+		delta.setSparsePrecision(0)
+		delta.initializeStorage(SPARSE)
+		if !this.checkpointed {
+			this.forEachRegister(delta.sparseProbabilisticStorage.setMax)
+			break
+		}
+		register := this.registerLookup()
+		for wordIndex, word := range this.dirty {
+			for ; word != 0; word &= word - 1 {
+				registerIndex := uint32(wordIndex<<LOG2_BITS_PER_WORD + bits.TrailingZeros64(word))
+				if registerValue := register(registerIndex); registerValue != 0 {
+					delta.sparseProbabilisticStorage.setMax(registerIndex, registerValue)
+				}
+			}
+		}
+	default:
+		panic(fmt.Sprintf("Unsupported HLL type %d", this.hllType))
+	}
+	return delta.ToBytes()
+}
+
+/**
+ * Records that the FULL register <code>registerIndex</code> was raised, if
+ * there was a #Checkpoint().
+ */
+func (this *Hll) markDirty(registerIndex uint32) {
+	if !this.checkpointed {
+		return
+	}
+	if this.dirty == nil {
+		this.dirty = make([]uint64, (this.m+BITS_PER_WORD-1)/BITS_PER_WORD)
+	}
+	this.dirty[registerIndex>>LOG2_BITS_PER_WORD] |= 1 << (registerIndex & BITS_PER_WORD_MASK)
+}
+
+/**
+ * @return whether the FULL register <code>registerIndex</code> is to be
+ *         included in the next #Delta().
+ */
+func (this *Hll) isDirty(registerIndex uint32) bool {
+	if !this.checkpointed {
+		return true
+	}
+	return this.dirty != nil && this.dirty[registerIndex>>LOG2_BITS_PER_WORD]&(1<<(registerIndex&BITS_PER_WORD_MASK)) != 0
+}
+
+/**
+ * Records the registers that a union with <code>other</code> is about to
+ * raise, see #markDirty(). As the unions write the storage directly this
+ * compares the registers up front.
+ */
+func (this *Hll) markUnionDirty(other *Hll) {
+	if other.hllType == EMPTY {
+		return
+	}
+	register := this.registerLookup()
+	if other.hllType != EXPLICIT {
+		other.forEachRegister(func(registerIndex uint32, registerValue byte) {
+			if registerValue > register(registerIndex) {
+				this.markDirty(registerIndex)
+			}
+		})
+		return
+	}
+
+	it := other.explicitStorage.iterator()
+	for it.HasNext() {
+		k := it.Next()
+		if this.hllType == EXPLICIT {
+			if !this.explicitStorage.Contains(k) {
+				this.markDirty(uint32(k & this.mBitsMask))
+			}
+		} else if registerIndex, registerValue := this.registerFor(k); registerValue > register(registerIndex) {
+			this.markDirty(registerIndex)
+		}
+	}
+}
+
+/**
+ * Unions a delta produced by #Delta() of a compatible HLL into this one.
+ */
+func (this *Hll) ApplyDelta(delta []byte) error {
+	other, err := NewHllFromBytes(delta)
+	if err != nil {
+		return err
+	}
+	if err := this.checkCompatible(other); err != nil {
+		return err
+	}
+	this.Union(other)
+	return nil
+}
+
+/**
+ * Calls <code>fn</code> for every non-zero register of a SPARSE or FULL HLL,
+ * in ascending index order. SPARSE registers of a higher precision are
+ * folded down to the FULL registers, see #foldSparse().
+ */
+func (this *Hll) forEachRegister(fn func(registerIndex uint32, registerValue byte)) {
+	switch this.hllType {
+	case SPARSE:
+		if this.sparsePrecision != 0 {
+			registers := this.foldedSparseRegisters()
+			for _, registerIndex := range slices.Sorted(maps.Keys(registers)) {
+				fn(registerIndex, registers[registerIndex])
+			}
+			return
+		}
+		it := NewSparseListIterator(this.sparseProbabilisticStorage)
+		for it.HasNext() {
+			fn(it.Next())
+		}
+	case FULL:
+		this.probabilisticStorage.forEachNonZero(func(registerIndex uint64, registerValue uint64) {
+			fn(uint32(registerIndex), byte(registerValue))
+		})
+	default:
+		panic(fmt.Sprintf("Unsupported HLL type %d", this.hllType))
+	}
+}
+
+/**
+ * @return a function giving the value of a register of this HLL, whatever
+ *         its type. EXPLICIT values are mapped to the registers they would
+ *         set.
+ */
+func (this *Hll) registerLookup() func(registerIndex uint32) byte {
+	switch this.hllType {
+	case EMPTY:
+		return func(uint32) byte { return 0 }
+	case EXPLICIT:
+		registers := make(map[uint32]byte)
+		it := this.explicitStorage.iterator()
+		for it.HasNext() {
+			j, p_w := this.registerFor(it.Next())
+			if p_w > registers[j] {
+				registers[j] = p_w
+			}
+		}
+		return func(registerIndex uint32) byte { return registers[registerIndex] }
+	case SPARSE:
+		// NOTE:  looking up a register of the list takes a scan
+		registers := this.foldedSparseRegisters()
+		return func(registerIndex uint32) byte { return registers[registerIndex] }
+	case FULL:
+		return func(registerIndex uint32) byte {
+			return byte(this.probabilisticStorage.getRegister(uint64(registerIndex)))
+		}
+	default:
+		panic(fmt.Sprintf("Unsupported HLL type %d", this.hllType))
+	}
+}
@@ -0,0 +1,485 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// file names within DurableConfig#Dir
+	WAL_FILE_NAME      = "wal.log"
+	SNAPSHOT_FILE_NAME = "snapshot.hllc"
+
+	DEFAULT_SYNC_INTERVAL = time.Second
+
+	// WAL record operations
+	walAdd    = 1
+	walMerge  = 2
+	walSet    = 3
+	walDelete = 4
+
+	// largest WAL record accepted on replay
+	MAXIMUM_WAL_RECORD_LENGTH = 1 << 30
+)
+
+var ErrStoreClosed = errors.New("durable store is closed")
+
+/**
+ * Persistence settings of a {@link DurableStore}.
+ */
+type DurableConfig struct {
+	// directory holding the WAL and the snapshot, created if necessary
+	Dir string
+	// how often the WAL is flushed and fsync'd. Writes within one interval
+	// are lost on a crash. Defaults to DEFAULT_SYNC_INTERVAL.
+	SyncInterval time.Duration
+	// how often all sketches are snapshotted and the WAL truncated. Zero
+	// disables periodic snapshots, see #Snapshot().
+	SnapshotInterval time.Duration
+}
+
+/**
+ * A {@link Store} whose mutations are appended to a write-ahead log and
+ * periodically snapshotted into a container file (see ContainerWriter). On
+ * open the state is rebuilt from the snapshot plus a replay of the WAL.<p/>
+ *
+ * Add and Merge are idempotent, and the last Set or Delete of a key resets
+ * it, so replaying a WAL that overlaps the snapshot (after a crash between
+ * writing the snapshot and truncating the WAL) yields the same state.<p/>
+ *
+ * Keys evicted by the store's budget or TTL are handed to its spill
+ * callback and are not part of later snapshots.
+ */
+type DurableStore struct {
+	store  *Store
+	config DurableConfig
+
+	// guards the WAL and orders its records the same as the mutations
+	mu     sync.Mutex
+	wal    *os.File
+	writer *bufio.Writer
+	// first error of a WAL write or sync, returned by all later mutations
+	err    error
+	closed bool
+
+	stop chan struct{}
+	done sync.WaitGroup
+	buf  []byte
+}
+
+/**
+ * Opens (or creates) the durable store in <code>durable.Dir</code> and
+ * recovers its state.
+ *
+ * @param config the configuration of the in-memory store.
+ * @param durable the persistence configuration.
+ */
+func OpenDurableStore(config StoreConfig, durable DurableConfig) (*DurableStore, error) {
+	store, err := NewStore(config)
+	if err != nil {
+		return nil, err
+	}
+	if durable.SyncInterval <= 0 {
+		durable.SyncInterval = DEFAULT_SYNC_INTERVAL
+	}
+	if err := os.MkdirAll(durable.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	this := &DurableStore{store: store, config: durable, stop: make(chan struct{})}
+	if err := this.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := this.replay(); err != nil {
+		return nil, err
+	}
+
+	this.writer = bufio.NewWriter(this.wal)
+	this.done.Add(1)
+	go this.background()
+	return this, nil
+}
+
+/**
+ * @return the in-memory store, for reads. Mutations made directly on it are
+ *         not logged.
+ */
+func (this *DurableStore) Store() *Store {
+	return this.store
+}
+
+func (this *DurableStore) Add(key string, rawValue uint64) (bool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	var value [8]byte
+	binary.BigEndian.PutUint64(value[:], rawValue)
+	if err := this.log(walAdd, key, value[:]); err != nil {
+		return false, err
+	}
+	return this.store.Add(key, rawValue), nil
+}
+
+func (this *DurableStore) Merge(key string, other *Hll) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.store.checkCompatible(other); err != nil {
+		return err
+	}
+	if err := this.log(walMerge, key, other.ToBytes()); err != nil {
+		return err
+	}
+	return this.store.Merge(key, other)
+}
+
+func (this *DurableStore) Set(key string, h *Hll) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.store.checkCompatible(h); err != nil {
+		return err
+	}
+	if err := this.log(walSet, key, h.ToBytes()); err != nil {
+		return err
+	}
+	return this.store.Set(key, h)
+}
+
+func (this *DurableStore) Delete(key string) (bool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if err := this.log(walDelete, key, nil); err != nil {
+		return false, err
+	}
+	return this.store.Delete(key), nil
+}
+
+/**
+ * Flushes and fsyncs the WAL now rather than at the next sync interval.
+ */
+func (this *DurableStore) Sync() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.closed {
+		return ErrStoreClosed
+	}
+	return this.sync()
+}
+
+/**
+ * Writes all sketches to a new snapshot and truncates the WAL.
+ */
+func (this *DurableStore) Snapshot() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.closed {
+		return ErrStoreClosed
+	}
+	if this.err != nil {
+		return this.err
+	}
+
+	path := filepath.Join(this.config.Dir, SNAPSHOT_FILE_NAME)
+	tmp := path + ".tmp"
+	if err := this.writeSnapshot(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if err := syncDir(this.config.Dir); err != nil {
+		return err
+	}
+
+	// NOTE:  a crash before this point replays the old WAL over the new
+	//        snapshot, which is safe (see above)
+	this.writer.Reset(this.wal)
+	if err := this.wal.Truncate(0); err != nil {
+		this.err = err
+		return err
+	}
+	if _, err := this.wal.Seek(0, io.SeekStart); err != nil {
+		this.err = err
+		return err
+	}
+	return nil
+}
+
+/**
+ * Stops the background sync, flushes and closes the WAL. The in-memory
+ * store remains readable.
+ */
+func (this *DurableStore) Close() error {
+	this.mu.Lock()
+	if this.closed {
+		this.mu.Unlock()
+		return ErrStoreClosed
+	}
+	err := this.sync()
+	this.closed = true
+	if closeErr := this.wal.Close(); err == nil {
+		err = closeErr
+	}
+	this.mu.Unlock()
+
+	close(this.stop)
+	this.done.Wait()
+	return err
+}
+
+// ------------------------------------------------------------------------
+// WAL
+
+/**
+ * Appends a record:  uvarint(len(payload)) payload crc32(payload), where
+ * payload is  op uvarint(len(key)) key data.<p/>
+ *
+ * Must be called with the lock held.
+ */
+func (this *DurableStore) log(op byte, key string, data []byte) error {
+	if this.closed {
+		return ErrStoreClosed
+	}
+	if this.err != nil {
+		return this.err
+	}
+
+	payload := this.buf[:0]
+	payload = append(payload, op)
+	payload = binary.AppendUvarint(payload, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = append(payload, data...)
+
+	var header [binary.MaxVarintLen64]byte
+	this.writer.Write(header[:binary.PutUvarint(header[:], uint64(len(payload)))])
+	this.writer.Write(payload)
+	_, err := this.writer.Write(binary.BigEndian.AppendUint32(header[:0], crc32.ChecksumIEEE(payload)))
+	this.buf = payload
+	if err != nil {
+		this.err = err
+	}
+	return err
+}
+
+func (this *DurableStore) sync() error {
+	if this.err != nil {
+		return this.err
+	}
+	if err := this.writer.Flush(); err != nil {
+		this.err = err
+		return err
+	}
+	if err := this.wal.Sync(); err != nil {
+		this.err = err
+		return err
+	}
+	return nil
+}
+
+func (this *DurableStore) background() {
+	defer this.done.Done()
+
+	syncTicker := time.NewTicker(this.config.SyncInterval)
+	defer syncTicker.Stop()
+	var snapshots <-chan time.Time
+	if this.config.SnapshotInterval > 0 {
+		snapshotTicker := time.NewTicker(this.config.SnapshotInterval)
+		defer snapshotTicker.Stop()
+		snapshots = snapshotTicker.C
+	}
+
+	for {
+		select {
+		case <-this.stop:
+			return
+		case <-syncTicker.C:
+			// NOTE:  errors are sticky and reported by the next mutation
+			this.Sync()
+		case <-snapshots:
+			this.Snapshot()
+		}
+	}
+}
+
+/**
+ * Replays the WAL into the store and opens it for appending. A torn or
+ * corrupt tail, as left by a crash mid-write, is truncated.
+ */
+func (this *DurableStore) replay() error {
+	wal, err := os.OpenFile(filepath.Join(this.config.Dir, WAL_FILE_NAME), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(wal)
+	valid := int64(0)
+	for {
+		n, err := this.replayRecord(r)
+		if err != nil {
+			break
+		}
+		valid += n
+	}
+
+	if err := wal.Truncate(valid); err != nil {
+		wal.Close()
+		return err
+	}
+	if _, err := wal.Seek(valid, io.SeekStart); err != nil {
+		wal.Close()
+		return err
+	}
+	this.wal = wal
+	return nil
+}
+
+/**
+ * @return the length of the record that was replayed.
+ */
+func (this *DurableStore) replayRecord(r *bufio.Reader) (int64, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	// NOTE:  a record has at least its op, and an empty payload would pass
+	//        the checksum of zero, so zeroes as left in the tail by a power
+	//        loss are corrupt
+	if length == 0 || length > MAXIMUM_WAL_RECORD_LENGTH {
+		return 0, ErrCorruptContainer
+	}
+	record := make([]byte, length+4)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return 0, err
+	}
+	payload := record[:length]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(record[length:]) {
+		return 0, ErrCorruptContainer
+	}
+
+	op := payload[0]
+	keyLength, n := binary.Uvarint(payload[1:])
+	if n <= 0 || uint64(len(payload)-1-n) < keyLength {
+		return 0, ErrCorruptContainer
+	}
+	key := string(payload[1+n : 1+n+int(keyLength)])
+	data := payload[1+n+int(keyLength):]
+
+	switch op {
+	case walAdd:
+		if len(data) != 8 {
+			return 0, ErrCorruptContainer
+		}
+		this.store.Add(key, binary.BigEndian.Uint64(data))
+	case walMerge, walSet:
+		// NOTE:  sketches are validated before they are logged, so this
+		//        cannot fail for a record with a valid checksum
+		h, err := NewHllFromBytes(data)
+		if err != nil {
+			return 0, err
+		}
+		if op == walMerge {
+			this.store.Merge(key, h)
+		} else {
+			this.store.Set(key, h)
+		}
+	case walDelete:
+		this.store.Delete(key)
+	default:
+		return 0, ErrCorruptContainer
+	}
+
+	var header [binary.MaxVarintLen64]byte
+	return int64(binary.PutUvarint(header[:], length)) + int64(len(record)), nil
+}
+
+// ------------------------------------------------------------------------
+// Snapshots
+
+func (this *DurableStore) loadSnapshot() error {
+	file, err := os.Open(filepath.Join(this.config.Dir, SNAPSHOT_FILE_NAME))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := NewContainerReader(file)
+	if err != nil {
+		return err
+	}
+	for {
+		key, h, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := this.store.Set(key, h); err != nil {
+			return err
+		}
+	}
+}
+
+func (this *DurableStore) writeSnapshot(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer, err := NewContainerWriter(file)
+	if err != nil {
+		return err
+	}
+	this.store.Range(func(key string, h *Hll) bool {
+		err = writer.Write(key, h)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,116 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDurableStoreRecovery(t *testing.T) {
+	dir := t.TempDir()
+	config := StoreConfig{Log2m: 11, Regwidth: 5, Expthresh: -1, Sparseon: true}
+	durable := DurableConfig{Dir: dir}
+
+	store, err := OpenDurableStore(config, durable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range randClientids(500) {
+		store.Add("a", v)
+	}
+	other, _ := NewHll(11, 5)
+	for _, v := range randClientids(500) {
+		other.Add(v)
+	}
+	store.Merge("b", other)
+	store.Add("c", 1<<20)
+	if err := store.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	store.Delete("c")
+	store.Add("a", 1<<40)
+	expectedA := store.Store().Cardinality("a")
+	expectedB := store.Store().Cardinality("b")
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a torn record at the tail, as left by a crash mid-write
+	wal, _ := os.OpenFile(filepath.Join(dir, WAL_FILE_NAME), os.O_WRONLY|os.O_APPEND, 0644)
+	wal.Write([]byte{20, walAdd, 1})
+	wal.Close()
+
+	store, err = OpenDurableStore(config, durable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if a := store.Store().Cardinality("a"); a != expectedA {
+		t.Errorf("recovered cardinality of a is %d, expected %d", a, expectedA)
+	}
+	if b := store.Store().Cardinality("b"); b != expectedB {
+		t.Errorf("recovered cardinality of b is %d, expected %d", b, expectedB)
+	}
+	if keys := store.Store().Keys(); len(keys) != 2 {
+		t.Errorf("expected a and b only, got %v", keys)
+	}
+
+	// the torn tail is gone and new records are appended after the valid ones
+	store.Add("d", 1<<20)
+	store.Sync()
+	info, _ := os.Stat(filepath.Join(dir, WAL_FILE_NAME))
+	if info.Size() == 0 {
+		t.Errorf("WAL is empty")
+	}
+}
+
+func TestDurableStoreZeroedTail(t *testing.T) {
+	dir := t.TempDir()
+	config := StoreConfig{Log2m: 11, Regwidth: 5, Expthresh: -1, Sparseon: true}
+	durable := DurableConfig{Dir: dir}
+
+	store, err := OpenDurableStore(config, durable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Add("a", 1<<20)
+	store.Add("a", 1<<30)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, WAL_FILE_NAME)
+	info, _ := os.Stat(name)
+
+	// zeroes at the tail, as left by a power loss
+	wal, _ := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	wal.Write(make([]byte, 4096))
+	wal.Close()
+
+	store, err = OpenDurableStore(config, durable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if a := store.Store().Cardinality("a"); a != 2 {
+		t.Errorf("recovered cardinality of a is %d, expected 2", a)
+	}
+	if truncated, _ := os.Stat(name); truncated.Size() != info.Size() {
+		t.Errorf("WAL of %d bytes, expected the zeroes to be truncated to %d", truncated.Size(), info.Size())
+	}
+}
@@ -0,0 +1,203 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+)
+
+/**
+ * How two HLLs with the same log2m and regwidth differ, see #Diff().
+ */
+type Difference struct {
+	// values in only one of the HLLs, if both are EMPTY or EXPLICIT, in the
+	// order of #ToBytes()
+	OnlyInA []uint64
+	OnlyInB []uint64
+	// registers that differ, in ascending index order, otherwise
+	Registers []RegisterDifference
+	// p' if Registers are SPARSE registers of that precision (see
+	// #NewHll6()), zero if they are the 2^log2m registers
+	SparsePrecision uint
+	// the SPARSE precisions of the HLLs if they differ and one of them holds
+	// SPARSE registers of a higher precision, which then cannot be compared
+	// register by register. Zero otherwise.
+	SparsePrecisionA uint
+	SparsePrecisionB uint
+
+	CardinalityA uint
+	CardinalityB uint
+}
+
+/**
+ * A register whose values differ between two HLLs.
+ */
+type RegisterDifference struct {
+	Index uint32
+	A     byte
+	B     byte
+}
+
+/**
+ * Compares two HLLs independently of their representation and storage
+ * layout. If both are EMPTY or EXPLICIT their sets of values are compared,
+ * otherwise their registers, with EXPLICIT values mapped to the registers
+ * they set. If neither is FULL and both have the same SPARSE precision p',
+ * the 2^p' SPARSE registers are compared, otherwise the 2^log2m registers
+ * that SPARSE registers of a higher precision fold down to, see
+ * #Registers().
+ *
+ * @return the differences, or an error if the HLLs differ in log2m or
+ *         regwidth.
+ */
+func Diff(a *Hll, b *Hll) (*Difference, error) {
+	if err := a.checkCompatible(b); err != nil {
+		return nil, err
+	}
+	this := &Difference{CardinalityA: a.Cardinality(), CardinalityB: b.Cardinality()}
+
+	if (a.hllType == EMPTY || a.hllType == EXPLICIT) && (b.hllType == EMPTY || b.hllType == EXPLICIT) {
+		var valuesA, valuesB []uint64
+		if a.hllType == EXPLICIT {
+			valuesA = a.sortedExplicitValues()
+		}
+		if b.hllType == EXPLICIT {
+			valuesB = b.sortedExplicitValues()
+		}
+		// NOTE:  both are in ascending order as signed longs
+		for len(valuesA) > 0 || len(valuesB) > 0 {
+			switch {
+			case len(valuesB) == 0 || (len(valuesA) > 0 && int64(valuesA[0]) < int64(valuesB[0])):
+				this.OnlyInA = append(this.OnlyInA, valuesA[0])
+				valuesA = valuesA[1:]
+			case len(valuesA) == 0 || int64(valuesB[0]) < int64(valuesA[0]):
+				this.OnlyInB = append(this.OnlyInB, valuesB[0])
+				valuesB = valuesB[1:]
+			default:
+				valuesA, valuesB = valuesA[1:], valuesB[1:]
+			}
+		}
+		return this, nil
+	}
+
+	if a.sparsePrecision != 0 && a.sparsePrecision == b.sparsePrecision && a.hllType != FULL && b.hllType != FULL {
+		registersA, registersB := a.sparseRegisters(), b.sparseRegisters()
+		for registerIndex, registerValue := range registersA {
+			if registerValue != registersB[registerIndex] {
+				this.Registers = append(this.Registers, RegisterDifference{registerIndex, registerValue, registersB[registerIndex]})
+			}
+		}
+		for registerIndex, registerValue := range registersB {
+			if _, ok := registersA[registerIndex]; !ok {
+				this.Registers = append(this.Registers, RegisterDifference{registerIndex, 0, registerValue})
+			}
+		}
+		slices.SortFunc(this.Registers, func(x, y RegisterDifference) int {
+			return cmp.Compare(x.Index, y.Index)
+		})
+		this.SparsePrecision = a.sparsePrecision
+		return this, nil
+	}
+	if a.sparsePrecision != b.sparsePrecision && ((a.hllType == SPARSE && a.sparsePrecision != 0) || (b.hllType == SPARSE && b.sparsePrecision != 0)) {
+		this.SparsePrecisionA, this.SparsePrecisionB = a.sparsePrecision, b.sparsePrecision
+	}
+
+	registersA, registersB := a.Registers(), b.Registers()
+	for registerIndex := range registersA {
+		if registersA[registerIndex] != registersB[registerIndex] {
+			this.Registers = append(this.Registers, RegisterDifference{uint32(registerIndex), registersA[registerIndex], registersB[registerIndex]})
+		}
+	}
+	return this, nil
+}
+
+/**
+ * @return true if the HLLs have the same log2m and regwidth and #Diff()
+ *         finds no differences. Parameters that do not change the contents,
+ *         such as expthresh and sparseon, are not compared.
+ */
+func Equal(a *Hll, b *Hll) bool {
+	difference, err := Diff(a, b)
+	return err == nil && difference.Equal()
+}
+
+/**
+ * @return true if there are no differences.
+ */
+func (this *Difference) Equal() bool {
+	return len(this.OnlyInA) == 0 && len(this.OnlyInB) == 0 && len(this.Registers) == 0 && this.SparsePrecisionA == this.SparsePrecisionB
+}
+
+/**
+ * @return (CardinalityB - CardinalityA) / CardinalityA, zero if both are
+ *         zero and infinite if only CardinalityA is.
+ */
+func (this *Difference) RelativeCardinalityDifference() float64 {
+	if this.CardinalityA == this.CardinalityB {
+		return 0
+	}
+	if this.CardinalityA == 0 {
+		return math.Inf(1)
+	}
+	return (float64(this.CardinalityB) - float64(this.CardinalityA)) / float64(this.CardinalityA)
+}
+
+/**
+ * @return a summary such as "3 registers differ, cardinality 1021 vs 1034
+ *         (+1.27%)".
+ */
+func (this *Difference) String() string {
+	var differences string
+	switch {
+	case this.Equal():
+		differences = "equal"
+	case this.SparsePrecisionA != this.SparsePrecisionB:
+		differences = fmt.Sprintf("sparse precisions %d and %d differ, %d registers differ", this.SparsePrecisionA, this.SparsePrecisionB, len(this.Registers))
+	case len(this.Registers) > 0:
+		differences = fmt.Sprintf("%d registers differ", len(this.Registers))
+	default:
+		differences = fmt.Sprintf("%d values only in a, %d only in b", len(this.OnlyInA), len(this.OnlyInB))
+	}
+	return fmt.Sprintf("%s, cardinality %d vs %d (%+.2f%%)", differences, this.CardinalityA, this.CardinalityB, 100*this.RelativeCardinalityDifference())
+}
+
+/**
+ * @return the non-zero SPARSE registers of an EMPTY, EXPLICIT or SPARSE HLL,
+ *         EXPLICIT values mapped to the SPARSE registers they would set.
+ */
+func (this *Hll) sparseRegisters() map[uint32]byte {
+	registers := make(map[uint32]byte)
+	switch this.hllType {
+	case EXPLICIT:
+		it := this.explicitStorage.iterator()
+		for it.HasNext() {
+			if registerIndex, p_w := this.sparseRegisterFor(it.Next()); p_w > registers[registerIndex] {
+				registers[registerIndex] = p_w
+			}
+		}
+	case SPARSE:
+		it := NewSparseListIterator(this.sparseProbabilisticStorage)
+		for it.HasNext() {
+			registerIndex, registerValue := it.Next()
+			registers[registerIndex] = registerValue
+		}
+	}
+	return registers
+}
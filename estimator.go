@@ -0,0 +1,129 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"fmt"
+	"math"
+)
+
+/**
+ * The estimator of the cardinality of SPARSE and FULL HLLs.
+ */
+type Estimator int
+
+const (
+	// the HyperLogLog estimator with the small and large range corrections
+	// of the original paper, as in the Java and PostgreSQL implementations
+	CLASSIC_ESTIMATOR Estimator = 0
+	// the improved estimator of Ertl, "New cardinality estimation algorithms
+	// for HyperLogLog sketches" (2017), which needs no range corrections and
+	// has no bias at small and large cardinalities
+	ERTL_ESTIMATOR Estimator = 1
+)
+
+var estimatorNames = map[Estimator]string{
+	CLASSIC_ESTIMATOR: "classic",
+	ERTL_ESTIMATOR:    "ertl",
+}
+
+func (this Estimator) String() string {
+	if name, ok := estimatorNames[this]; ok {
+		return name
+	}
+	return fmt.Sprintf("Estimator(%d)", int(this))
+}
+
+func (this Estimator) MarshalText() ([]byte, error) {
+	if _, ok := estimatorNames[this]; !ok {
+		return nil, fmt.Errorf("unknown estimator %d", int(this))
+	}
+	return []byte(this.String()), nil
+}
+
+/**
+ * Parses "classic" or "ertl".
+ */
+func (this *Estimator) UnmarshalText(text []byte) error {
+	for estimator, name := range estimatorNames {
+		if name == string(text) {
+			*this = estimator
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown estimator %q, expected classic or ertl", text)
+}
+
+/**
+ * Computes the cardinality with Ertl's improved estimator from the register
+ * histogram. A register of regwidth bits holds p(w) up to q = 2^regwidth - 2
+ * or the saturated value q+1.
+ *
+ * @return the unrounded cardinality estimate.
+ */
+func (this *Hll) ertlCardinality() float64 {
+	counts := this.registerCounts()
+	q := len(counts) - 2
+	m := float64(this.m)
+
+	z := m * ertlTau(1-float64(counts[q+1])/m)
+	for k := q; k >= 1; k-- {
+		z = 0.5 * (z + float64(counts[k]))
+	}
+	z += m * ertlSigma(float64(counts[0])/m)
+	// NOTE:  alpha_inf = 1/(2 ln 2)
+	return m * m / (2 * math.Ln2 * z)
+}
+
+/**
+ * sigma(x) = x + sum_{k>=1} x^(2^k) 2^(k-1), infinite at one.
+ */
+func ertlSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		previous := z
+		z += x * y
+		y += y
+		if z == previous {
+			return z
+		}
+	}
+}
+
+/**
+ * tau(x) = (1 - x - sum_{k>=1} (1 - x^(2^-k))^2 2^-k) / 3, zero at zero and
+ * one.
+ */
+func ertlTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		previous := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == previous {
+			return z / 3
+		}
+	}
+}
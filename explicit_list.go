@@ -0,0 +1,409 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"cmp"
+	"math/bits"
+	"slices"
+)
+
+const (
+	// every this many zero bits of the high bits of an ExplicitList their
+	// position is sampled, see ExplicitList#selectZero()
+	EXPLICIT_LIST_ZERO_SAMPLE = 64
+	// the insertion buffer of an ExplicitList is merged into the encoded
+	// list once it holds this many values or a sixteenth of the list,
+	// whichever is more
+	MINIMUM_EXPLICIT_BUFFER_SIZE = 64
+)
+
+/**
+ * The storage of an EXPLICIT HLL: a set of raw values.
+ */
+type explicitSet interface {
+	/** Adds a value, see ExplicitList#Add(). */
+	add(k uint64) bool
+	Contains(k uint64) bool
+	Size() uint
+	/** Prepares the set for <code>expected</code> values, if it can. */
+	ensureCapacity(expected uint)
+	sizeInBytes() uint
+	clone() explicitSet
+	/** Iterates over the values, in no particular order. */
+	iterator() explicitIterator
+}
+
+type explicitIterator interface {
+	HasNext() bool
+	Next() uint64
+}
+
+/**
+ * A compact EXPLICIT storage, see Hll#SetCompactExplicit().
+ *
+ * The values are kept sorted in the Elias-Fano encoding: for n values of at
+ * most u, the low floor(log2(u/n)) bits of every value are stored verbatim
+ * and the remaining high bits in unary, the i-th value setting the bit at
+ * (value >> lowWidth) + i. That takes less than 2 + log2(u/n) bits per value,
+ * which for 64 bit hashes is about 7 bytes at 2^10 values and 6.25 bytes at
+ * 2^16 values, against the 11 to 22 bytes of a LongHashSet. A lookup finds
+ * the run of values sharing its high bits through sampled positions of the
+ * zero bits, see #selectZero(). Insertions go to a small sorted buffer which
+ * is merged into the list when it fills up.
+ *
+ * NOTE:  iterating over the list merges the buffer, so it is not safe for
+ *        concurrent use either.
+ */
+type ExplicitList struct {
+	// number of values in the encoded list
+	size uint
+	// the number of low bits of every value that are stored in lowBits
+	lowWidth uint
+	// the low bits of the values, packed back to back
+	lowBits []uint64
+	// the high bits of the values in unary. The values with the high bits h
+	// lie between the (h-1)-th and the h-th zero bit.
+	highBits []uint64
+	// the number of zero bits in highBits, one more than the largest high
+	// bits
+	buckets uint64
+	// the position in highBits of every EXPLICIT_LIST_ZERO_SAMPLE-th zero bit
+	zeroPositions []uint64
+	// pending insertions, sorted and disjoint from the encoded values
+	buffer []uint64
+}
+
+func NewExplicitList() *ExplicitList {
+	return &ExplicitList{}
+}
+
+func (this *ExplicitList) Clone() *ExplicitList {
+	c := *this
+	c.lowBits = slices.Clone(this.lowBits)
+	c.highBits = slices.Clone(this.highBits)
+	c.zeroPositions = slices.Clone(this.zeroPositions)
+	c.buffer = slices.Clone(this.buffer)
+	return &c
+}
+
+func (this *ExplicitList) clone() explicitSet {
+	return this.Clone()
+}
+
+/**
+ * Adds a value to the list.
+ *
+ * @return <code>true</code> if the value was not in the list yet.
+ */
+func (this *ExplicitList) Add(k uint64) bool {
+	i, found := slices.BinarySearch(this.buffer, k)
+	if found || this.containsSorted(k) {
+		return false
+	}
+	if this.buffer == nil {
+		// NOTE:  sized up front so that the buffer does not grow past its
+		//        limit
+		this.buffer = make([]uint64, 0, this.bufferSize())
+	}
+	this.buffer = slices.Insert(this.buffer, i, k)
+	if uint(len(this.buffer)) >= this.bufferSize() {
+		this.flush()
+	}
+	return true
+}
+
+func (this *ExplicitList) add(k uint64) bool {
+	return this.Add(k)
+}
+
+func (this *ExplicitList) Contains(k uint64) bool {
+	_, found := slices.BinarySearch(this.buffer, k)
+	return found || this.containsSorted(k)
+}
+
+/**
+ * @return whether <code>k</code> is in the encoded list, ignoring the
+ *         buffer.
+ */
+func (this *ExplicitList) containsSorted(k uint64) bool {
+	high := k >> this.lowWidth
+	if this.size == 0 || high >= this.buckets {
+		return false
+	}
+
+	// the run of values with these high bits
+	start := uint64(0)
+	if high > 0 {
+		start = this.selectZero(high-1) + 1
+	}
+	end := this.selectZero(high)
+
+	low := k & (1<<this.lowWidth - 1)
+	for position := start; position < end; position++ {
+		value := packedGet(this.lowBits, this.lowWidth, position-high)
+		if value >= low {
+			return value == low
+		}
+	}
+	return false
+}
+
+/**
+ * @return the position in #highBits of the <code>rank</code>-th zero bit,
+ *         counting from zero. <code>rank</code> must be less than #buckets.
+ */
+func (this *ExplicitList) selectZero(rank uint64) uint64 {
+	sample := rank / EXPLICIT_LIST_ZERO_SAMPLE
+	position := this.zeroPositions[sample]
+	rank -= sample * EXPLICIT_LIST_ZERO_SAMPLE
+
+	wordIndex := position >> LOG2_BITS_PER_WORD
+	zeros := ^this.highBits[wordIndex] &^ (1<<(position&BITS_PER_WORD_MASK) - 1)
+	for {
+		if count := uint64(bits.OnesCount64(zeros)); rank >= count {
+			rank -= count
+			wordIndex++
+			zeros = ^this.highBits[wordIndex]
+			continue
+		}
+		for ; rank > 0; rank-- {
+			zeros &= zeros - 1
+		}
+		return wordIndex<<LOG2_BITS_PER_WORD + uint64(bits.TrailingZeros64(zeros))
+	}
+}
+
+/**
+ * @return the largest value of the encoded list. It must not be empty.
+ */
+func (this *ExplicitList) last() uint64 {
+	// NOTE:  the last value has the largest high bits
+	return (this.buckets-1)<<this.lowWidth | packedGet(this.lowBits, this.lowWidth, uint64(this.size-1))
+}
+
+/**
+ * @return the number of values the buffer holds before it is merged.
+ */
+func (this *ExplicitList) bufferSize() uint {
+	return max(MINIMUM_EXPLICIT_BUFFER_SIZE, this.size/16)
+}
+
+/**
+ * Merges the insertion buffer into the encoded list.
+ */
+func (this *ExplicitList) flush() {
+	if len(this.buffer) == 0 {
+		return
+	}
+
+	size := this.size + uint(len(this.buffer))
+	maxValue := this.buffer[len(this.buffer)-1]
+	if this.size > 0 {
+		maxValue = max(maxValue, this.last())
+	}
+	lowWidth := uint(0)
+	if quotient := maxValue / uint64(size); quotient > 0 {
+		lowWidth = uint(bits.Len64(quotient)) - 1
+	}
+	buckets := maxValue>>lowWidth + 1
+
+	lowBits := make([]uint64, (uint64(size)*uint64(lowWidth)+BITS_PER_WORD-1)/BITS_PER_WORD)
+	highBits := make([]uint64, (uint64(size)+buckets+BITS_PER_WORD-1)/BITS_PER_WORD)
+	count := uint64(0)
+	write := func(value uint64) {
+		packedSet(lowBits, lowWidth, count, value&(1<<lowWidth-1))
+		position := value>>lowWidth + count
+		highBits[position>>LOG2_BITS_PER_WORD] |= 1 << (position & BITS_PER_WORD_MASK)
+		count++
+	}
+
+	buffer := this.buffer
+	it := NewExplicitListIterator(this)
+	for it.HasNext() {
+		value := it.Next()
+		for len(buffer) > 0 && buffer[0] < value {
+			write(buffer[0])
+			buffer = buffer[1:]
+		}
+		write(value)
+	}
+	for _, value := range buffer {
+		write(value)
+	}
+
+	zeroPositions := make([]uint64, 0, (buckets+EXPLICIT_LIST_ZERO_SAMPLE-1)/EXPLICIT_LIST_ZERO_SAMPLE)
+	zeros := uint64(0)
+	for wordIndex, word := range highBits {
+		for inverted := ^word; inverted != 0 && zeros < buckets; inverted &= inverted - 1 {
+			if zeros%EXPLICIT_LIST_ZERO_SAMPLE == 0 {
+				zeroPositions = append(zeroPositions, uint64(wordIndex)<<LOG2_BITS_PER_WORD+uint64(bits.TrailingZeros64(inverted)))
+			}
+			zeros++
+		}
+	}
+
+	this.size = size
+	this.lowWidth = lowWidth
+	this.lowBits = lowBits
+	this.highBits = highBits
+	this.buckets = buckets
+	this.zeroPositions = zeroPositions
+	this.buffer = nil
+}
+
+func (this *ExplicitList) Size() uint {
+	return this.size + uint(len(this.buffer))
+}
+
+/**
+ * Does nothing: unlike a hash table, the list does not get cheaper to fill
+ * by sizing it up front.
+ */
+func (this *ExplicitList) ensureCapacity(expected uint) {
+}
+
+/** Returns the number of bytes held by the backing arrays of this list. */
+func (this *ExplicitList) sizeInBytes() uint {
+	return 8 * uint(cap(this.lowBits)+cap(this.highBits)+cap(this.zeroPositions)+cap(this.buffer))
+}
+
+func (this *ExplicitList) iterator() explicitIterator {
+	this.flush()
+	return NewExplicitListIterator(this)
+}
+
+/**
+ * @return the <code>width</code> bit field <code>i</code> of
+ *         <code>words</code>.
+ */
+func packedGet(words []uint64, width uint, i uint64) uint64 {
+	if width == 0 {
+		return 0
+	}
+	bitIndex := i * uint64(width)
+	wordIndex, shift := bitIndex>>LOG2_BITS_PER_WORD, bitIndex&BITS_PER_WORD_MASK
+	value := words[wordIndex] >> shift
+	if shift+uint64(width) > BITS_PER_WORD {
+		value |= words[wordIndex+1] << (BITS_PER_WORD - shift)
+	}
+	return value & (1<<width - 1)
+}
+
+/**
+ * Sets the <code>width</code> bit field <code>i</code> of
+ * <code>words</code>, which must be zero, to <code>value</code>.
+ */
+func packedSet(words []uint64, width uint, i uint64, value uint64) {
+	if width == 0 {
+		return
+	}
+	bitIndex := i * uint64(width)
+	wordIndex, shift := bitIndex>>LOG2_BITS_PER_WORD, bitIndex&BITS_PER_WORD_MASK
+	words[wordIndex] |= value << shift
+	if shift+uint64(width) > BITS_PER_WORD {
+		words[wordIndex+1] |= value >> (BITS_PER_WORD - shift)
+	}
+}
+
+/**
+ * Iterates over the values of the encoded list, in ascending order. Values
+ * still in the insertion buffer are not visited, see ExplicitList#iterator().
+ */
+type ExplicitListIterator struct {
+	lowBits  []uint64
+	lowWidth uint
+	highBits []uint64
+	size     uint64
+	count    uint64
+	// the index in highBits of the current word and its bits that are yet
+	// to be visited
+	wordIndex int
+	word      uint64
+}
+
+func NewExplicitListIterator(explicitList *ExplicitList) *ExplicitListIterator {
+	this := &ExplicitListIterator{}
+	this.lowBits = explicitList.lowBits
+	this.lowWidth = explicitList.lowWidth
+	this.highBits = explicitList.highBits
+	this.size = uint64(explicitList.size)
+	if len(this.highBits) > 0 {
+		this.word = this.highBits[0]
+	}
+	return this
+}
+
+func (this *ExplicitListIterator) HasNext() bool {
+	return this.count < this.size
+}
+
+func (this *ExplicitListIterator) Next() uint64 {
+	for this.word == 0 {
+		this.wordIndex++
+		this.word = this.highBits[this.wordIndex]
+	}
+	position := uint64(this.wordIndex)<<LOG2_BITS_PER_WORD + uint64(bits.TrailingZeros64(this.word))
+	this.word &= this.word - 1
+
+	value := (position-this.count)<<this.lowWidth | packedGet(this.lowBits, this.lowWidth, this.count)
+	this.count++
+	return value
+}
+
+/**
+ * Selects the storage of the EXPLICIT representation: an ExplicitList if
+ * <code>compact</code> is true, which takes a third to two thirds of the
+ * memory of the default LongHashSet but makes insertions a few times
+ * slower, so that a high explicit threshold (see NewHll()) stays affordable.
+ * Values that are already stored are moved over. The choice does not affect
+ * #ToBytes() and is carried over by #Clone() and #Union(), but not by
+ * #NewHllFromBytes().
+ */
+func (this *Hll) SetCompactExplicit(compact bool) {
+	if this.explicitCompact == compact {
+		return
+	}
+	this.explicitCompact = compact
+	if this.hllType != EXPLICIT {
+		return
+	}
+
+	explicitStorage := this.explicitStorage
+	this.initializeStorage(EXPLICIT)
+	this.explicitStorage.ensureCapacity(explicitStorage.Size())
+	it := explicitStorage.iterator()
+	for it.HasNext() {
+		this.explicitStorage.add(it.Next())
+	}
+}
+
+/**
+ * @return the values of an EXPLICIT HLL in ascending order as signed longs,
+ *         the order of #ToBytes().
+ */
+func (this *Hll) sortedExplicitValues() []uint64 {
+	values := make([]uint64, 0, this.explicitStorage.Size())
+	it := this.explicitStorage.iterator()
+	for it.HasNext() {
+		values = append(values, it.Next())
+	}
+	slices.SortFunc(values, func(a, b uint64) int {
+		return cmp.Compare(int64(a), int64(b))
+	})
+	return values
+}
@@ -0,0 +1,120 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestExplicitList(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	list := NewExplicitList()
+	expected := make(map[uint64]bool)
+	for i := 0; i < 20000; i++ {
+		// NOTE:  a small range repeats values
+		k := r.Uint64()
+		if i%3 == 0 {
+			k = uint64(r.Intn(4000))
+		}
+		if added := list.Add(k); added == expected[k] {
+			t.Fatalf("Add(%d) = %t, expected %t", k, added, !expected[k])
+		}
+		expected[k] = true
+
+		if i%89 == 0 {
+			probe := uint64(r.Intn(4000))
+			if list.Contains(probe) != expected[probe] {
+				t.Fatalf("Contains(%d) = %t, expected %t", probe, !expected[probe], expected[probe])
+			}
+		}
+	}
+
+	if list.Size() != uint(len(expected)) {
+		t.Errorf("size %d, expected %d", list.Size(), len(expected))
+	}
+	count := 0
+	previous := uint64(0)
+	it := list.Clone().iterator()
+	for it.HasNext() {
+		k := it.Next()
+		if count > 0 && k <= previous {
+			t.Fatalf("value %d iterated after %d", k, previous)
+		}
+		if !expected[k] {
+			t.Errorf("unexpected value %d", k)
+		}
+		previous = k
+		count++
+	}
+	if count != len(expected) {
+		t.Errorf("iterated over %d values, expected %d", count, len(expected))
+	}
+}
+
+func TestExplicitListSize(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	list := NewExplicitList()
+	for _, count := range []int{1 << 12, 1 << 14, 1 << 16} {
+		for int(list.Size()) < count {
+			list.Add(r.Uint64())
+		}
+		// NOTE:  2 + log2(2^64/n) bits per value, plus the sampled zero bits
+		//        and the buffer of up to a sixteenth of the values
+		limit := (66-math.Log2(float64(count)))/8 + 0.25 + 0.5
+		if bytesPerValue := float64(list.sizeInBytes()) / float64(list.Size()); bytesPerValue > limit {
+			t.Errorf("%d values: %.2f bytes per value, expected at most %.2f", count, bytesPerValue, limit)
+		}
+	}
+}
+
+func TestCompactExplicit(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for _, compact := range []bool{false, true} {
+		h, _ := NewHll5(13, 5, 16, true, EXPLICIT)
+		h.SetCompactExplicit(!compact)
+		expected, _ := NewHll5(13, 5, 16, true, EXPLICIT)
+		for i := 0; i < 1<<14; i++ {
+			k := r.Uint64()
+			h.Add(k)
+			expected.Add(k)
+			if i == 1000 {
+				// moves the values over
+				h.SetCompactExplicit(compact)
+			}
+		}
+
+		if h.hllType != EXPLICIT || h.Cardinality() != expected.Cardinality() {
+			t.Errorf("compact %t: type %d, cardinality %d, expected %d", compact, h.hllType, h.Cardinality(), expected.Cardinality())
+		}
+		if !bytes.Equal(h.ToBytes(), expected.ToBytes()) {
+			t.Errorf("compact %t: serialized differently", compact)
+		}
+		if _, ok := h.explicitStorage.(*ExplicitList); ok != compact {
+			t.Errorf("compact %t: stored in %T", compact, h.explicitStorage)
+		}
+
+		empty, _ := NewHll5(13, 5, 16, true, EXPLICIT)
+		empty.SetCompactExplicit(!compact)
+		empty.Union(h)
+		if _, ok := empty.explicitStorage.(*ExplicitList); ok == compact {
+			t.Errorf("compact %t: union stored in %T", compact, empty.explicitStorage)
+		}
+	}
+}
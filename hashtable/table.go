@@ -0,0 +1,374 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+/**
+ * Package hashtable provides the open-addressing hash table that backs the
+ * hash-based storages of package hll.
+ *
+ * It is a generalization of the fastutil tables that LongHashSet and
+ * Int2ByteHashMap were ported from: keys are placed by linear probing into a
+ * power-of-two sized array which is doubled once the load factor is
+ * exceeded, and deletions shift the following keys back instead of leaving
+ * tombstones. Which slots are in use is kept in a bitmap, so that any key,
+ * including the zero value, can be stored.
+ */
+package hashtable
+
+import (
+	"errors"
+	"iter"
+	"math"
+	"math/bits"
+	"unsafe"
+)
+
+const (
+	/** The initial default size of a hash table. */
+	DEFAULT_INITIAL_SIZE = 16
+	/** The default load factor of a hash table. */
+	DEFAULT_LOAD_FACTOR = .75
+	/** The largest number of slots of a hash table. */
+	MAXIMUM_CAPACITY = 1 << 30
+)
+
+var (
+	ErrInvalidLoadFactor = errors.New("hashtable: load factor must be greater than 0 and smaller than or equal to 1")
+	ErrTooLarge          = errors.New("hashtable: too many entries")
+)
+
+/**
+ * An open-addressing hash table of keys of type K to values of type V. Use
+ * struct{} values for a set.
+ *
+ * A Table is not safe for concurrent use.
+ */
+type Table[K comparable, V any] struct {
+	keys   []K
+	values []V
+	// bit i is set if slot i holds an entry
+	occupied []uint64
+	// hashes a key, the low bits of the result pick its slot
+	hash func(K) uint64
+	// the acceptable load factor
+	loadFactor float64
+	// the number of slots minus one
+	mask uint64
+	// the number of entries after which the table grows
+	maxFill int
+	// the number of entries
+	size int
+}
+
+/**
+ * Creates a table that holds <code>expected</code> entries without growing.
+ *
+ * @param hash the hash function of the keys. Its low bits must be well
+ *        distributed, see e.g. the finalization step of MurmurHash3.
+ * @param expected the expected number of entries.
+ * @param loadFactor the acceptable ratio of entries to slots, in (0, 1].
+ */
+func New[K comparable, V any](hash func(K) uint64, expected int, loadFactor float64) (*Table[K, V], error) {
+	if !(loadFactor > 0 && loadFactor <= 1) {
+		return nil, ErrInvalidLoadFactor
+	}
+	n, err := tableSize(expected, loadFactor)
+	if err != nil {
+		return nil, err
+	}
+
+	this := &Table[K, V]{}
+	this.hash = hash
+	this.loadFactor = loadFactor
+	this.allocate(n)
+	return this, nil
+}
+
+/**
+ * @return the number of slots needed to hold <code>expected</code> entries at
+ *         the given load factor: a power of two of at least two, so that
+ *         one slot is always free.
+ */
+func tableSize(expected int, loadFactor float64) (int, error) {
+	// NOTE:  at a load factor of one, the table still needs a free slot
+	needed := max(math.Ceil(float64(max(expected, 0))/loadFactor), float64(expected+1))
+	if needed > MAXIMUM_CAPACITY {
+		return 0, ErrTooLarge
+	}
+	n := 2
+	if needed > 2 {
+		n = 1 << bits.Len64(uint64(needed)-1)
+	}
+	return n, nil
+}
+
+/**
+ * Replaces the slots by <code>n</code> empty ones.
+ */
+func (this *Table[K, V]) allocate(n int) {
+	this.keys = make([]K, n)
+	this.values = make([]V, n)
+	this.occupied = make([]uint64, (n+63)/64)
+	this.mask = uint64(n - 1)
+	// NOTE:  there must always be a free slot to end the probing
+	this.maxFill = min(int(math.Ceil(float64(n)*this.loadFactor)), n-1)
+	this.size = 0
+}
+
+func (this *Table[K, V]) isOccupied(pos uint64) bool {
+	return this.occupied[pos/64]&(1<<(pos%64)) != 0
+}
+
+/**
+ * @return the slot that holds <code>k</code> if it is found, otherwise the
+ *         free slot that ends its probe sequence.
+ */
+func (this *Table[K, V]) find(k K) (uint64, bool) {
+	pos := this.hash(k) & this.mask
+	for this.isOccupied(pos) {
+		if this.keys[pos] == k {
+			return pos, true
+		}
+		pos = (pos + 1) & this.mask
+	}
+	return pos, false
+}
+
+/**
+ * @return the number of entries.
+ */
+func (this *Table[K, V]) Len() int {
+	return this.size
+}
+
+/**
+ * @return the value of <code>k</code> and whether it is in the table.
+ */
+func (this *Table[K, V]) Get(k K) (V, bool) {
+	pos, found := this.find(k)
+	if !found {
+		var zero V
+		return zero, false
+	}
+	return this.values[pos], true
+}
+
+func (this *Table[K, V]) Contains(k K) bool {
+	_, found := this.find(k)
+	return found
+}
+
+/**
+ * Sets the value of <code>k</code>, growing the table if needed.
+ *
+ * @return the previous value of <code>k</code> and whether it was in the
+ *         table. If the table cannot grow, it is left unchanged and
+ *         ErrTooLarge is returned.
+ */
+func (this *Table[K, V]) Put(k K, v V) (V, bool, error) {
+	pos, found := this.find(k)
+	if found {
+		previous := this.values[pos]
+		this.values[pos] = v
+		return previous, true, nil
+	}
+
+	var zero V
+	if this.size >= this.maxFill {
+		if err := this.Grow(1); err != nil {
+			return zero, false, err
+		}
+		pos, _ = this.find(k)
+	}
+	this.keys[pos] = k
+	this.values[pos] = v
+	this.occupied[pos/64] |= 1 << (pos % 64)
+	this.size++
+	return zero, false, nil
+}
+
+/**
+ * Removes <code>k</code> from the table.
+ *
+ * @return the value of <code>k</code> and whether it was in the table.
+ */
+func (this *Table[K, V]) Delete(k K) (V, bool) {
+	var zero V
+	pos, found := this.find(k)
+	if !found {
+		return zero, false
+	}
+	previous := this.values[pos]
+
+	// shift the entries that probed past the freed slot back into it, so
+	// that no probe sequence is broken
+	for {
+		last := pos
+		pos = (pos + 1) & this.mask
+		for ; this.isOccupied(pos); pos = (pos + 1) & this.mask {
+			slot := this.hash(this.keys[pos]) & this.mask
+			// the entry can move if its home slot is not within (last, pos]
+			// on the cycle
+			if (pos-slot)&this.mask >= (pos-last)&this.mask {
+				break
+			}
+		}
+		if !this.isOccupied(pos) {
+			this.keys[last] = *new(K)
+			this.values[last] = zero
+			this.occupied[last/64] &^= 1 << (last % 64)
+			break
+		}
+		this.keys[last] = this.keys[pos]
+		this.values[last] = this.values[pos]
+	}
+	this.size--
+	return previous, true
+}
+
+/**
+ * Removes all entries, keeping the slots for reuse.
+ */
+func (this *Table[K, V]) Clear() {
+	clear(this.keys)
+	clear(this.values)
+	clear(this.occupied)
+	this.size = 0
+}
+
+/**
+ * Makes room for <code>n</code> more entries, so that adding them does not
+ * rehash.
+ *
+ * @return ErrTooLarge if the table would become too large, in which case it
+ *         is left unchanged.
+ */
+func (this *Table[K, V]) Grow(n int) error {
+	expected := this.size + max(n, 0)
+	if expected <= this.maxFill {
+		return nil
+	}
+	size, err := tableSize(expected, this.loadFactor)
+	if err != nil {
+		return err
+	}
+	this.rehash(size)
+	return nil
+}
+
+/**
+ * Moves the entries to <code>n</code> new slots.
+ */
+func (this *Table[K, V]) rehash(n int) {
+	keys, values, occupied := this.keys, this.values, this.occupied
+	size := this.size
+	this.allocate(n)
+	for i, word := range occupied {
+		for ; word != 0; word &= word - 1 {
+			pos := i*64 + bits.TrailingZeros64(word)
+			slot, _ := this.find(keys[pos])
+			this.keys[slot] = keys[pos]
+			this.values[slot] = values[pos]
+			this.occupied[slot/64] |= 1 << (slot % 64)
+		}
+	}
+	this.size = size
+}
+
+/**
+ * @return a deep copy of the table. The keys and values themselves are
+ *         copied by assignment.
+ */
+func (this *Table[K, V]) Clone() *Table[K, V] {
+	c := *this
+	c.keys = append([]K(nil), this.keys...)
+	c.values = append([]V(nil), this.values...)
+	c.occupied = append([]uint64(nil), this.occupied...)
+	return &c
+}
+
+/**
+ * Iterates over the entries in slot order. The table must not be changed
+ * during the iteration.
+ */
+func (this *Table[K, V]) Range() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for i, word := range this.occupied {
+			for ; word != 0; word &= word - 1 {
+				pos := i*64 + bits.TrailingZeros64(word)
+				if !yield(this.keys[pos], this.values[pos]) {
+					return
+				}
+			}
+		}
+	}
+}
+
+/**
+ * @return the number of bytes held by the slots of the table.
+ */
+func (this *Table[K, V]) SizeInBytes() int {
+	var k K
+	var v V
+	return len(this.keys)*int(unsafe.Sizeof(k)) + len(this.values)*int(unsafe.Sizeof(v)) + 8*len(this.occupied)
+}
+
+/**
+ * Iterates over the entries of a Table in slot order, for callers that need
+ * to interleave the iteration with other work. The table must not be
+ * changed during the iteration.
+ */
+type Iterator[K comparable, V any] struct {
+	table *Table[K, V]
+	// the slot of the next entry, len(keys) if there is none
+	pos int
+}
+
+func (this *Table[K, V]) Iterator() *Iterator[K, V] {
+	it := &Iterator[K, V]{}
+	it.table = this
+	it.pos = this.nextOccupied(0)
+	return it
+}
+
+/**
+ * @return the first occupied slot at or after <code>pos</code>, the number of
+ *         slots if there is none.
+ */
+func (this *Table[K, V]) nextOccupied(pos int) int {
+	for i := pos / 64; i < len(this.occupied); i++ {
+		word := this.occupied[i]
+		if i == pos/64 {
+			word &= ^uint64(0) << (pos % 64)
+		}
+		if word != 0 {
+			return i*64 + bits.TrailingZeros64(word)
+		}
+	}
+	return len(this.keys)
+}
+
+func (this *Iterator[K, V]) HasNext() bool {
+	return this.pos < len(this.table.keys)
+}
+
+/**
+ * @return the next entry. This must not be called once #HasNext() is false.
+ */
+func (this *Iterator[K, V]) Next() (K, V) {
+	pos := this.pos
+	this.pos = this.table.nextOccupied(pos + 1)
+	return this.table.keys[pos], this.table.values[pos]
+}
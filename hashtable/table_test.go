@@ -0,0 +1,145 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hashtable
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// a poor hash, so that keys collide and probe sequences run long
+func clusteringHash(k uint64) uint64 {
+	return k >> 3
+}
+
+func mix(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	return k
+}
+
+func TestTable(t *testing.T) {
+	for name, hash := range map[string]func(uint64) uint64{"mix": mix, "clustering": clusteringHash} {
+		r := rand.New(rand.NewSource(1))
+		table, err := New[uint64, int](hash, 0, DEFAULT_LOAD_FACTOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := make(map[uint64]int)
+		for i := 0; i < 50000; i++ {
+			k := uint64(r.Intn(4096))
+			switch r.Intn(3) {
+			case 0, 1:
+				previous, found, err := table.Put(k, i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if expectedPrevious, ok := expected[k]; found != ok || previous != expectedPrevious {
+					t.Fatalf("%s: Put(%d) = %d, %t, expected %d, %t", name, k, previous, found, expectedPrevious, ok)
+				}
+				expected[k] = i
+			case 2:
+				previous, found := table.Delete(k)
+				if expectedPrevious, ok := expected[k]; found != ok || previous != expectedPrevious {
+					t.Fatalf("%s: Delete(%d) = %d, %t, expected %d, %t", name, k, previous, found, expectedPrevious, ok)
+				}
+				delete(expected, k)
+			}
+		}
+
+		if table.Len() != len(expected) {
+			t.Errorf("%s: %d entries, expected %d", name, table.Len(), len(expected))
+		}
+		for k := uint64(0); k < 4096; k++ {
+			v, found := table.Get(k)
+			if expectedValue, ok := expected[k]; found != ok || v != expectedValue || table.Contains(k) != ok {
+				t.Fatalf("%s: Get(%d) = %d, %t, expected %d, %t", name, k, v, found, expectedValue, ok)
+			}
+		}
+
+		ranged, iterated := 0, 0
+		for k, v := range table.Range() {
+			if expected[k] != v {
+				t.Errorf("%s: ranged over %d = %d, expected %d", name, k, v, expected[k])
+			}
+			ranged++
+		}
+		it := table.Clone().Iterator()
+		for it.HasNext() {
+			if k, v := it.Next(); expected[k] != v {
+				t.Errorf("%s: iterated over %d = %d, expected %d", name, k, v, expected[k])
+			}
+			iterated++
+		}
+		if ranged != len(expected) || iterated != len(expected) {
+			t.Errorf("%s: ranged over %d and iterated over %d entries, expected %d", name, ranged, iterated, len(expected))
+		}
+	}
+}
+
+func TestTableZeroKey(t *testing.T) {
+	table, _ := New[uint64, struct{}](mix, 0, DEFAULT_LOAD_FACTOR)
+	if table.Contains(0) {
+		t.Error("empty table contains 0")
+	}
+	table.Put(0, struct{}{})
+	if !table.Contains(0) || table.Len() != 1 {
+		t.Error("0 is not stored")
+	}
+}
+
+func TestTableClearAndGrow(t *testing.T) {
+	table, _ := New[uint64, struct{}](mix, 0, 1)
+	if err := table.Grow(1000); err != nil {
+		t.Fatal(err)
+	}
+	slots := len(table.keys)
+	for k := uint64(0); k < 1000; k++ {
+		table.Put(k, struct{}{})
+	}
+	if len(table.keys) != slots {
+		t.Errorf("grew from %d to %d slots after Grow(1000)", slots, len(table.keys))
+	}
+
+	table.Clear()
+	if table.Len() != 0 || table.Contains(7) || len(table.keys) != slots {
+		t.Errorf("%d entries and %d slots after Clear", table.Len(), len(table.keys))
+	}
+	for range table.Range() {
+		t.Fatal("ranged over a cleared table")
+	}
+}
+
+func TestTableErrors(t *testing.T) {
+	for _, loadFactor := range []float64{0, -1, 1.5} {
+		if _, err := New[uint64, struct{}](mix, 0, loadFactor); err != ErrInvalidLoadFactor {
+			t.Errorf("load factor %g: %v", loadFactor, err)
+		}
+	}
+	if _, err := New[uint64, struct{}](mix, MAXIMUM_CAPACITY, DEFAULT_LOAD_FACTOR); err != ErrTooLarge {
+		t.Errorf("oversized table: %v", err)
+	}
+
+	table, _ := New[uint64, struct{}](mix, 0, DEFAULT_LOAD_FACTOR)
+	if err := table.Grow(MAXIMUM_CAPACITY); err != ErrTooLarge {
+		t.Errorf("oversized Grow: %v", err)
+	}
+	if len(table.keys) != 2 {
+		t.Errorf("failed Grow resized the table to %d slots", len(table.keys))
+	}
+}
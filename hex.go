@@ -0,0 +1,49 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// prefix of the hex output format of PostgreSQL's bytea (and thus hll)
+	HEX_PREFIX = "\\x"
+)
+
+/**
+ * Serializes the HLL like #ToBytes() and formats it the way PostgreSQL
+ * prints an <code>hll</code> value, i.e. <code>\x</code> followed by
+ * lowercase hex digits.
+ */
+func (this *Hll) ToHexString() string {
+	return HEX_PREFIX + hex.EncodeToString(this.ToBytes())
+}
+
+/**
+ * Deserializes an HLL from the format of #ToHexString(). The <code>\x</code>
+ * prefix is optional and surrounding whitespace is ignored.
+ */
+func NewHllFromHexString(s string) (*Hll, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), HEX_PREFIX)
+	bytes, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return NewHllFromBytes(bytes)
+}
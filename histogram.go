@@ -0,0 +1,201 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// version byte of serialized histogram summaries
+	HISTOGRAM_SUMMARY_VERSION = 1
+)
+
+var ErrCorruptHistogramSummary = errors.New("corrupt histogram summary")
+
+/**
+ * Counts the registers of this HLL per register value.
+ *
+ * @return a slice of 2^regwidth counts, index <code>i</code> being the number
+ *         of registers holding the value <code>i</code>. The counts add up to
+ *         m. EXPLICIT values are mapped to the registers they would set.
+ */
+func (this *Hll) RegisterHistogram() []uint64 {
+	registerHistogram := make([]uint64, 1<<this.regwidth)
+	switch this.hllType {
+	case EMPTY:
+		registerHistogram[0] = uint64(this.m)
+	case EXPLICIT:
+		registers := make(map[uint32]byte)
+		it := this.explicitStorage.iterator()
+		for it.HasNext() {
+			j, p_w := this.registerFor(it.Next())
+			if p_w > registers[j] {
+				registers[j] = p_w
+			}
+		}
+		registerHistogram[0] = uint64(this.m)
+		for _, registerValue := range registers {
+			registerHistogram[0]--
+			registerHistogram[registerValue]++
+		}
+	case SPARSE, FULL:
+		for registerValue, count := range this.registerCounts() {
+			registerHistogram[registerValue] = uint64(count)
+		}
+	default:
+		panic(fmt.Sprintf("Unsupported HLL type %d", this.hllType))
+	}
+	return registerHistogram
+}
+
+/**
+ * The register histogram of a HLL together with its parameters: all that the
+ * classic HLL estimator needs, in a few dozen bytes once serialized. Unlike the HLL it
+ * cannot be added to or unioned, so it is meant for storing trends.
+ */
+type HistogramSummary struct {
+	// EMPTY HLL carrying the parameters and estimator constants
+	params *Hll
+	counts []uint64
+}
+
+/**
+ * @return the summary of the current registers of this HLL.
+ */
+func (this *Hll) HistogramSummary() *HistogramSummary {
+	return &HistogramSummary{params: this.emptyCopy(), counts: this.RegisterHistogram()}
+}
+
+/**
+ * @param log2m see NewHll().
+ * @param regwidth see NewHll().
+ * @param counts the number of registers per register value, see
+ *        Hll#RegisterHistogram(). Trailing values may be left out. The
+ *        counts must add up to 2^log2m.
+ */
+func NewHistogramSummary(log2m uint, regwidth uint, counts []uint64) (*HistogramSummary, error) {
+	params, err := NewHll(log2m, regwidth)
+	if err != nil {
+		return nil, err
+	}
+	if len(counts) > 1<<regwidth {
+		return nil, fmt.Errorf("%d register values for regwidth %d", len(counts), regwidth)
+	}
+
+	total := uint64(0)
+	for _, count := range counts {
+		total += count
+	}
+	if total != uint64(params.m) {
+		return nil, fmt.Errorf("register counts add up to %d rather than %d", total, params.m)
+	}
+
+	this := &HistogramSummary{params: params, counts: make([]uint64, 1<<regwidth)}
+	copy(this.counts, counts)
+	return this, nil
+}
+
+func (this *HistogramSummary) Log2m() uint {
+	return this.params.log2m
+}
+
+func (this *HistogramSummary) Regwidth() uint {
+	return this.params.regwidth
+}
+
+/**
+ * @return a copy of the register counts, see Hll#RegisterHistogram().
+ */
+func (this *HistogramSummary) Counts() []uint64 {
+	return append([]uint64(nil), this.counts...)
+}
+
+/**
+ * Computes the cardinality estimate of the HLL algorithm from the histogram,
+ * always with CLASSIC_ESTIMATOR. It matches Hll#Cardinality() for SPARSE and
+ * FULL HLLs with that estimator and no sparse precision. With
+ * ERTL_ESTIMATOR, or for SPARSE HLLs whose registers have a higher precision
+ * (which the histogram only holds folded down), Hll#Cardinality() estimates
+ * differently, and EXPLICIT HLLs are estimated rather than counted exactly.
+ */
+func (this *HistogramSummary) Cardinality() uint {
+	sum := float64(0)
+	for registerValue, count := range this.counts {
+		sum += float64(count) / float64(uint64(1)<<uint(registerValue))
+	}
+	return uint(math.Ceil(this.params.estimate(sum, int(this.counts[0]))))
+}
+
+/**
+ * Serializes the summary as the version byte, log2m, regwidth, the number of
+ * counts and the counts, the last two as unsigned varints. Trailing zero
+ * counts are left out.
+ */
+func (this *HistogramSummary) ToBytes() []byte {
+	n := len(this.counts)
+	for n > 0 && this.counts[n-1] == 0 {
+		n--
+	}
+
+	bytes := []byte{HISTOGRAM_SUMMARY_VERSION, byte(this.params.log2m), byte(this.params.regwidth)}
+	bytes = binary.AppendUvarint(bytes, uint64(n))
+	for _, count := range this.counts[:n] {
+		bytes = binary.AppendUvarint(bytes, count)
+	}
+	return bytes
+}
+
+/**
+ * Deserializes a summary written by HistogramSummary#ToBytes().
+ */
+func NewHistogramSummaryFromBytes(bytes []byte) (*HistogramSummary, error) {
+	if len(bytes) < 3 {
+		return nil, ErrCorruptHistogramSummary
+	}
+	if bytes[0] != HISTOGRAM_SUMMARY_VERSION {
+		return nil, fmt.Errorf("unsupported histogram summary version %d", bytes[0])
+	}
+	log2m, regwidth := uint(bytes[1]), uint(bytes[2])
+	bytes = bytes[3:]
+
+	n, length := binary.Uvarint(bytes)
+	if length <= 0 || n > uint64(len(bytes)) {
+		return nil, ErrCorruptHistogramSummary
+	}
+	bytes = bytes[length:]
+	counts := make([]uint64, n)
+	for i := range counts {
+		counts[i], length = binary.Uvarint(bytes)
+		if length <= 0 {
+			return nil, ErrCorruptHistogramSummary
+		}
+		bytes = bytes[length:]
+	}
+	if len(bytes) != 0 {
+		return nil, ErrCorruptHistogramSummary
+	}
+
+	this, err := NewHistogramSummary(log2m, regwidth, counts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorruptHistogramSummary, err)
+	}
+	return this, nil
+}
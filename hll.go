@@ -23,6 +23,7 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"slices"
 )
 
 const (
@@ -38,29 +39,38 @@ const (
 	MINIMUM_EXPTHRESH_PARAM    = -1
 	MAXIMUM_EXPTHRESH_PARAM    = 18
 	MAXIMUM_EXPLICIT_THRESHOLD = 1 << (MAXIMUM_EXPTHRESH_PARAM - 1) /*per storage spec*/
+	// maximum value for the 'sparsePrecision' parameter, as SPARSE register
+	// indices are 32 bits wide
+	MAXIMUM_SPARSE_PRECISION_PARAM = 32
 )
 
+/**
+ * The representation of an HLL in the promotion hierarchy EMPTY, EXPLICIT,
+ * SPARSE, FULL. Its ordinal is the one in the storage spec.
+ */
+type Type int
+
 const (
-	UNDEFINED = 0
-	EMPTY     = 1
-	EXPLICIT  = 2
-	SPARSE    = 3
-	FULL      = 4
+	UNDEFINED Type = 0
+	EMPTY     Type = 1
+	EXPLICIT  Type = 2
+	SPARSE    Type = 3
+	FULL      Type = 4
 )
 
 type Hll struct {
 	// ************************************************************************
 	// Storage
 	// storage used when #type is EXPLICIT, null otherwise
-	explicitStorage *LongHashSet
+	explicitStorage explicitSet
 	// storage used when #type is SPARSE, null otherwise
-	sparseProbabilisticStorage *Int2ByteHashMap
+	sparseProbabilisticStorage *SparseList
 	// storage used when #type is FULL, null otherwise
 	probabilisticStorage *BitVector
 
 	// current type of this HLL instance, if this changes then so should the
 	// storage used (see above)
-	hllType int
+	hllType Type
 
 	// ------------------------------------------------------------------------
 	// Characteristic parameters
@@ -86,6 +96,9 @@ type Hll struct {
 	// power of two OR simply zero
 	// NOTE:  this only has meaning when 'explicitOff' is false
 	explicitThreshold uint
+	// flag indicating that EXPLICIT values are stored in an ExplicitList
+	// rather than a LongHashSet, see #SetCompactExplicit()
+	explicitCompact bool
 
 	// ........................................................................
 	// SPARSE-specific constants
@@ -93,6 +106,12 @@ type Hll struct {
 	shortWordLength uint
 	// flag indicating if the SPARSE representation should not be used
 	sparseOff bool
+	// log-base-2 of the number of registers the SPARSE representation tracks
+	// in HLL++ mode (p' in the paper), greater than log2m. Zero if off, in
+	// which case SPARSE tracks the log2m registers.
+	sparsePrecision uint
+	// a mask of the bits of a SPARSE register index set to one
+	sparseMBitsMask uint64
 	// threshold (in register count) at which a SPARSE HLL is converted to a
 	// FULL HLL, always greater than zero
 	sparseThreshold uint
@@ -115,6 +134,30 @@ type Hll struct {
 	// the cutoff value of the estimator for using the "large" range cardinality
 	// correction formula
 	largeEstimatorCutoff float64
+	// the estimator of the SPARSE and FULL cardinality, see #WithEstimator()
+	estimator Estimator
+
+	// ........................................................................
+	// Hashing
+	// hash function of #AddBytes() and #AddString(), nil for HashBytes()
+	// with seed zero
+	hasher func([]byte) uint64
+
+	// ........................................................................
+	// Incremental cardinality
+	// number of registers holding each value when SPARSE or FULL, index zero
+	// counting the unset registers, so that the indicator function needs no
+	// pass over the registers. nil when it has to be recomputed, see
+	// #registerCounts().
+	registerHistogram []uint32
+
+	// ........................................................................
+	// Delta sync
+	// whether #Checkpoint() was called, after which #dirty tracks the changes
+	checkpointed bool
+	// one bit per FULL register that was raised since the last #Checkpoint(),
+	// nil until the first change
+	dirty []uint64
 }
 
 /**
@@ -141,7 +184,7 @@ func NewHll3(log2m uint, regwidth uint, estimateNum uint) (*Hll, error) {
 		return nil, err
 	}
 
-	var hllType int
+	var hllType Type
 	if estimateNum == 0 {
 		hllType = EMPTY
 	} else if estimateNum < this.explicitThreshold {
@@ -191,12 +234,40 @@ func NewHll3(log2m uint, regwidth uint, estimateNum uint) (*Hll, error) {
  * @param type the type in the promotion hierarchy which this instance should
  *        start at. This cannot be <code>null</code>.
  */
-func NewHll5(log2m uint, regwidth uint, expthresh int, sparseon bool, hllType int) (*Hll, error) {
+func NewHll5(log2m uint, regwidth uint, expthresh int, sparseon bool, hllType Type) (*Hll, error) {
+	this := &Hll{}
+	err := this.initParams(log2m, regwidth, expthresh, sparseon)
+	if err != nil {
+		return nil, err
+	}
+
+	this.initializeStorage(hllType)
+
+	return this, nil
+}
+
+/**
+ * Same as #NewHll5() but, if <code>sparsePrecision</code> is not zero, the
+ * SPARSE representation tracks 2^sparsePrecision rather than 2^log2m
+ * registers, as in the sparse mode of HLL++. The cardinality of such SPARSE
+ * HLLs is estimated by linear counting over the finer registers, which is
+ * nearly exact, and their registers are folded down to log2m bits of index
+ * on promotion to FULL.
+ *
+ * @param sparsePrecision the log-base-2 of the number of SPARSE registers
+ *        (p' in the paper). Must be zero or greater than log2m and at most
+ *        32.
+ */
+func NewHll6(log2m uint, regwidth uint, expthresh int, sparseon bool, hllType Type, sparsePrecision uint) (*Hll, error) {
 	this := &Hll{}
 	err := this.initParams(log2m, regwidth, expthresh, sparseon)
 	if err != nil {
 		return nil, err
 	}
+	err = this.setSparsePrecision(sparsePrecision)
+	if err != nil {
+		return nil, err
+	}
 
 	this.initializeStorage(hllType)
 
@@ -236,8 +307,6 @@ func NewHll5(log2m uint, regwidth uint, expthresh int, sparseon bool, hllType in
  *        representation should be used.
  */
 func (this *Hll) initParams(log2m uint, regwidth uint, expthresh int, sparseon bool) error {
-	initTwoToL()
-
 	this.log2m = log2m
 	if log2m < MINIMUM_LOG2M_PARAM || log2m > MAXIMUM_LOG2M_PARAM {
 		return fmt.Errorf("log2m must be at least %d and at most %d (was %d)", MINIMUM_LOG2M_PARAM, MAXIMUM_LOG2M_PARAM, log2m)
@@ -282,18 +351,8 @@ func (this *Hll) initParams(log2m uint, regwidth uint, expthresh int, sparseon b
 		return fmt.Errorf("'expthresh' must be at least %d and at most %d (was %d)", MINIMUM_EXPTHRESH_PARAM, MAXIMUM_EXPTHRESH_PARAM, expthresh)
 	}
 
-	this.shortWordLength = (regwidth + log2m)
 	this.sparseOff = !sparseon
-	if this.sparseOff {
-		this.sparseThreshold = 0
-	} else {
-		// TODO improve this cutoff to include the cost overhead of Java
-		//      members/objects
-		largestPow2LessThanCutoff := uint(math.Log2(float64(this.m*this.regwidth))) / this.shortWordLength
-		this.sparseThreshold = (1 << largestPow2LessThanCutoff)
-	}
-
-	return nil
+	return this.setSparsePrecision(0)
 }
 
 /**
@@ -304,17 +363,27 @@ func (this *Hll) initParams(log2m uint, regwidth uint, expthresh int, sparseon b
  *        <code>null</code> and must be an instantiable type. (For instance,
  *        it cannot be {@link HLLType#UNDEFINED}.)
  */
-func (this *Hll) initializeStorage(hllType int) {
+func (this *Hll) initializeStorage(hllType Type) {
 	this.hllType = hllType
+	this.registerHistogram = nil
+	if hllType == FULL {
+		// all registers of new storage are unset
+		this.registerHistogram = make([]uint32, 1<<this.regwidth)
+		this.registerHistogram[0] = uint32(this.m)
+	}
 	switch hllType {
 	case EMPTY:
 		// nothing to be done
 		break
 	case EXPLICIT:
-		this.explicitStorage, _ = NewLongHashSet()
+		if this.explicitCompact {
+			this.explicitStorage = NewExplicitList()
+		} else {
+			this.explicitStorage, _ = NewLongHashSet()
+		}
 		break
 	case SPARSE:
-		this.sparseProbabilisticStorage, _ = NewInt2ByteHashMap()
+		this.sparseProbabilisticStorage = NewSparseList(this.regwidth)
 		break
 	case FULL:
 		this.probabilisticStorage = NewBitVector(this.regwidth, this.m)
@@ -342,7 +411,7 @@ func (this *Hll) Add(rawValue uint64) {
 		// NOTE:  EMPTY type is always promoted on #addRaw()
 		if this.explicitThreshold > 0 {
 			this.initializeStorage(EXPLICIT)
-			this.explicitStorage.Add(rawValue)
+			this.addRawExplicit(rawValue)
 		} else if !this.sparseOff {
 			this.initializeStorage(SPARSE)
 			this.addRawSparseProbabilistic(rawValue)
@@ -352,41 +421,19 @@ func (this *Hll) Add(rawValue uint64) {
 		}
 		return
 	case EXPLICIT:
-		this.explicitStorage.Add(rawValue)
+		this.addRawExplicit(rawValue)
 
 		// promotion, if necessary
-        if this.explicitStorage.size > this.explicitThreshold {
-            if this.sparseOff || this.explicitStorage.size > this.sparseThreshold {
-                this.initializeStorage(FULL)
-                it := NewLongHashSetIterator(this.explicitStorage)
-                for it.HasNext() {
-                    k := it.Next()
-                    this.addRawProbabilistic(k)
-                }
-            } else {
-                this.initializeStorage(SPARSE)
-                it := NewLongHashSetIterator(this.explicitStorage)
-                for it.HasNext() {
-                    k := it.Next()
-                    this.addRawSparseProbabilistic(k)
-                }
-            }
-            this.explicitStorage = nil
+        if this.explicitStorage.Size() > this.explicitThreshold {
+            this.promoteExplicit()
         }
 		return
 	case SPARSE:
         this.addRawSparseProbabilistic(rawValue)
 
         // promotion, if necessary
-        if this.sparseProbabilisticStorage.size > this.sparseThreshold {
-            this.initializeStorage(FULL)
-            it := NewInt2ByteHashMapIterator(this.sparseProbabilisticStorage)
-            for it.HasNext() {
-                registerIndex := it.NextKey()
-                registerValue := this.sparseProbabilisticStorage.get(registerIndex)
-                this.probabilisticStorage.setMaxRegister(uint64(registerIndex), uint64(registerValue))
-            }
-            this.sparseProbabilisticStorage = nil
+        if this.sparseProbabilisticStorage.sizeExceeds(this.sparseThreshold) {
+            this.promoteSparse()
         }
         return
 	case FULL:
@@ -398,6 +445,63 @@ func (this *Hll) Add(rawValue uint64) {
 	}
 }
 
+/**
+ * Promotes an EXPLICIT HLL that exceeded the explicit threshold to SPARSE or,
+ * if the SPARSE representation is off or would already be too large, FULL.
+ */
+func (this *Hll) promoteExplicit() {
+	if this.sparseOff || this.explicitStorage.Size() > this.sparseThreshold {
+		this.promoteExplicitTo(FULL)
+	} else {
+		this.promoteExplicitTo(SPARSE)
+	}
+}
+
+/**
+ * Adds the values of an EXPLICIT HLL to new storage of the given type.
+ *
+ * @param hllType SPARSE or FULL.
+ */
+func (this *Hll) promoteExplicitTo(hllType Type) {
+	it := this.explicitStorage.iterator()
+	this.initializeStorage(hllType)
+	if hllType == FULL {
+		for it.HasNext() {
+			this.addRawProbabilistic(it.Next())
+		}
+	} else {
+		for it.HasNext() {
+			this.addRawSparseProbabilistic(it.Next())
+		}
+	}
+	this.explicitStorage = nil
+}
+
+/**
+ * Promotes a SPARSE HLL that exceeded the sparse threshold to FULL.
+ */
+func (this *Hll) promoteSparse() {
+	registerHistogram := this.registerCounts()
+	this.initializeStorage(FULL)
+	this.foldSparseInto(this.probabilisticStorage)
+	this.sparseProbabilisticStorage = nil
+	// NOTE:  the registers are the same, only their representation changed
+	this.registerHistogram = registerHistogram
+}
+
+/**
+ * Sets the registers of FULL storage to at least the values that the
+ * SPARSE registers of this HLL fold down to, see #foldSparse(). The
+ * register counts of the storage's HLL are not updated.
+ */
+func (this *Hll) foldSparseInto(probabilisticStorage *BitVector) {
+	it := NewSparseListIterator(this.sparseProbabilisticStorage)
+	for it.HasNext() {
+		registerIndex, registerValue := this.foldSparse(it.Next())
+		probabilisticStorage.setMaxRegister(uint64(registerIndex), uint64(registerValue))
+	}
+}
+
 /**
  * Computes the cardinality of the HLL.
  *
@@ -419,6 +523,144 @@ func (this *Hll) Cardinality() uint {
 	}
 }
 
+/**
+ * @return the current representation of this HLL: EMPTY, EXPLICIT, SPARSE
+ *         or FULL.
+ */
+func (this *Hll) Type() Type {
+	return this.hllType
+}
+
+/**
+ * The relative standard error of #Cardinality(), 1.04/sqrt(m) for the
+ * probabilistic types. EMPTY and EXPLICIT HLLs are exact.
+ *
+ * @return the standard error relative to the cardinality.
+ */
+func (this *Hll) StandardError() float64 {
+	if this.hllType == EMPTY || this.hllType == EXPLICIT {
+		return 0
+	}
+	return 1.04 / math.Sqrt(float64(this.m))
+}
+
+/**
+ * Creates an EMPTY HLL with the same parameters as this instance.
+ */
+func (this *Hll) emptyCopy() *Hll {
+	c := *this
+	c.explicitStorage = nil
+	c.sparseProbabilisticStorage = nil
+	c.probabilisticStorage = nil
+	c.registerHistogram = nil
+	c.checkpointed = false
+	c.dirty = nil
+	c.hllType = EMPTY
+	return &c
+}
+
+/**
+ * Creates a deep copy of this HLL. The copy shares no storage with this
+ * instance.
+ *
+ * @return the copy. This will never be <code>nil</code>.
+ */
+func (this *Hll) Clone() *Hll {
+	c := *this
+	if this.explicitStorage != nil {
+		c.explicitStorage = this.explicitStorage.clone()
+	}
+	if this.sparseProbabilisticStorage != nil {
+		c.sparseProbabilisticStorage = this.sparseProbabilisticStorage.Clone()
+	}
+	if this.probabilisticStorage != nil {
+		c.probabilisticStorage = this.probabilisticStorage.Clone()
+	}
+	if this.registerHistogram != nil {
+		c.registerHistogram = append([]uint32(nil), this.registerHistogram...)
+	}
+	if this.dirty != nil {
+		c.dirty = append([]uint64(nil), this.dirty...)
+	}
+	return &c
+}
+
+/**
+ * Computes the number of bytes held by the storage of the current type. This
+ * is the real in-memory footprint of the EXPLICIT hash set, the SPARSE hash
+ * map or the FULL bit vector, not the serialized size.
+ *
+ * @return the storage footprint in bytes. Zero for EMPTY.
+ */
+func (this *Hll) SizeInBytes() uint {
+	switch this.hllType {
+	case EMPTY:
+		return 0
+	case EXPLICIT:
+		return this.explicitStorage.sizeInBytes()
+	case SPARSE:
+		return this.sparseProbabilisticStorage.sizeInBytes()
+	case FULL:
+		return this.probabilisticStorage.sizeInBytes()
+	default:
+		panic(fmt.Sprintf("Unsupported HLL type %d", this.hllType))
+	}
+}
+
+/**
+ * Computes the register index and the register value p(w) that the given raw
+ * value maps to. See #addRawProbabilistic() for the details.
+ *
+ * @param rawValue the hashed value.
+ * @return the register index and value. The value is zero if the raw value
+ *         does not set any register.
+ */
+func (this *Hll) registerFor(rawValue uint64) (uint32, byte) {
+	substreamValue := (rawValue >> this.log2m)
+	if substreamValue == 0 {
+		return 0, 0
+	}
+	return uint32(rawValue & this.mBitsMask), byte(1 + leastSignificantBit(substreamValue|this.pwMaxMask))
+}
+
+/**
+ * Reports whether adding <code>rawValue</code> would change the contents of
+ * this HLL, i.e. whether it is a new EXPLICIT value or raises a register.
+ *
+ * @param rawValue the hashed value.
+ */
+func (this *Hll) wouldChange(rawValue uint64) bool {
+	switch this.hllType {
+	case EMPTY:
+		if this.explicitThreshold > 0 {
+			return true
+		}
+		_, p_w := this.registerFor(rawValue)
+		return p_w != 0
+	case EXPLICIT:
+		return !this.explicitStorage.Contains(rawValue)
+	case SPARSE:
+		j, p_w := this.sparseRegisterFor(rawValue)
+		return p_w > this.sparseProbabilisticStorage.get(j)
+	case FULL:
+		j, p_w := this.registerFor(rawValue)
+		return uint64(p_w) > this.probabilisticStorage.getRegister(uint64(j))
+	default:
+		panic(fmt.Sprintf("Unsupported HLL type %d", this.hllType))
+	}
+}
+
+/**
+ * Verifies that <code>other</code> uses the same number of registers and
+ * register width as this instance, so that their union is meaningful.
+ */
+func (this *Hll) checkCompatible(other *Hll) error {
+	if this.log2m != other.log2m || this.regwidth != other.regwidth {
+		return fmt.Errorf("incompatible HLLs: log2m %d/%d, regwidth %d/%d", this.log2m, other.log2m, this.regwidth, other.regwidth)
+	}
+	return nil
+}
+
 /**
  * Adds the raw value to the {@link #probabilisticStorage}.
  * {@link #type} must be {@link HLLType#FULL}.
@@ -458,7 +700,10 @@ func (this *Hll) addRawProbabilistic(rawValue uint64) {
 	// NOTE:  no +1 as in paper since 0-based indexing
 	j := uint32(rawValue & this.mBitsMask)
 
-	this.probabilisticStorage.setMaxRegister(uint64(j), uint64(p_w))
+	if previous := byte(this.probabilisticStorage.getAndSetMaxRegister(uint64(j), uint64(p_w))); p_w > previous {
+		this.registerChanged(previous, p_w)
+		this.markDirty(j)
+	}
 }
 
 /**
@@ -475,7 +720,7 @@ func (this *Hll) addRawSparseProbabilistic(rawValue uint64) {
 	//      lsb(pwMaxMask) = 2^(registerValueInBits) - 2,
 	// thus lsb(any_long | pwMaxMask) <= 2^(registerValueInBits) - 2,
 	// thus 1 + lsb(any_long | pwMaxMask) <= 2^(registerValueInBits) -1.
-	substreamValue := (rawValue >> this.log2m)
+	substreamValue := (rawValue >> this.sparseLog2m())
 	var p_w byte
 
 	if substreamValue == 0 {
@@ -498,12 +743,82 @@ func (this *Hll) addRawSparseProbabilistic(rawValue uint64) {
 	}
 
 	// NOTE:  no +1 as in paper since 0-based indexing
-	j := uint32(rawValue & this.mBitsMask)
+	j := uint32(rawValue & this.sparseMBitsMask)
 
-	currentValue := this.sparseProbabilisticStorage.get(j)
-	if p_w > currentValue {
-		this.sparseProbabilisticStorage.put(j, p_w)
+	if this.checkpointed && p_w > this.sparseProbabilisticStorage.get(j) {
+		this.markDirty(j & uint32(this.mBitsMask))
+	}
+	this.sparseProbabilisticStorage.setMax(j, p_w)
+}
+
+/**
+ * Adds the raw value to the {@link #explicitStorage}.
+ * {@link #type} must be {@link HLLType#EXPLICIT}.
+ *
+ * @param rawValue the raw value to add to the explicit storage.
+ */
+func (this *Hll) addRawExplicit(rawValue uint64) {
+	if this.explicitStorage.add(rawValue) {
+		this.markDirty(uint32(rawValue & this.mBitsMask))
+	}
+}
+
+/**
+ * Accounts for a register of a FULL HLL that was raised from
+ * <code>previous</code> to <code>value</code> in #registerHistogram.
+ */
+func (this *Hll) registerChanged(previous byte, value byte) {
+	if registerHistogram := this.registerHistogram; registerHistogram != nil {
+		registerHistogram[previous]--
+		registerHistogram[value]++
+	}
+}
+
+/**
+ * @return the number of registers holding each value, index zero counting
+ *         the unset registers. It is recomputed from the storage if it went
+ *         stale. {@link #type} must be SPARSE or FULL.
+ */
+func (this *Hll) registerCounts() []uint32 {
+	switch this.hllType {
+	case SPARSE:
+		if this.sparsePrecision != 0 {
+			registerHistogram := make([]uint32, 1<<this.regwidth)
+			registerHistogram[0] = uint32(this.m)
+			for _, registerValue := range this.foldedSparseRegisters() {
+				registerHistogram[0]--
+				registerHistogram[registerValue]++
+			}
+			return registerHistogram
+		}
+		// NOTE:  the list counts the values of its registers itself
+		registerHistogram := slices.Clone(this.sparseProbabilisticStorage.histogram())
+		registerHistogram[0] = uint32(this.m - this.sparseProbabilisticStorage.size)
+		return registerHistogram
+	case FULL:
+		if this.registerHistogram == nil {
+			this.registerHistogram = make([]uint32, 1<<this.regwidth)
+			this.probabilisticStorage.histogram(this.registerHistogram)
+		}
+		return this.registerHistogram
+	default:
+		panic(fmt.Sprintf("Unsupported HLL type %d", this.hllType))
+	}
+}
+
+/**
+ * Computes the "indicator function" -- sum(2^(-M[j])) where M[j] is the
+ * 'j'th register value -- and the number of unset registers ("V" in the
+ * paper) from #registerCounts(), in time proportional to the number of
+ * register values rather than to m. {@link #type} must be SPARSE or FULL.
+ */
+func (this *Hll) indicatorFunction() (float64, int) {
+	registerHistogram := this.registerCounts()
+	sum := float64(0)
+	for registerValue, count := range registerHistogram {
+		sum += float64(count) / float64(uint64(1)<<uint(registerValue))
 	}
+	return sum, int(registerHistogram[0])
 }
 
 /**
@@ -515,38 +830,42 @@ func (this *Hll) addRawSparseProbabilistic(rawValue uint64) {
  * @return the exact, unrounded cardinality given by the HLL algorithm
  */
 func (this *Hll) fullProbabilisticAlgorithmCardinality() float64 {
-	m := this.m /*for performance*/
+	if this.estimator == ERTL_ESTIMATOR {
+		return this.ertlCardinality()
+	}
 
 	// compute the "indicator function" -- sum(2^(-M[j])) where M[j] is the
 	// 'j'th register value
-	sum, numberOfZeroes := this.probabilisticStorage.sum()
+	sum, numberOfZeroes := this.indicatorFunction()
 
-	// apply the estimate and correction to the indicator function
-	estimator := this.alphaMSquared / sum
-	if (numberOfZeroes != 0) && (estimator < this.smallEstimatorCutoff) {
-		return smallEstimator(m, numberOfZeroes)
-	} else if estimator <= this.largeEstimatorCutoff {
-		return estimator
-	} else {
-		return largeEstimator(this.log2m, this.regwidth, estimator)
-	}
+	return this.estimate(sum, numberOfZeroes)
 }
 
 func (this *Hll) sparseProbabilisticAlgorithmCardinality() float64 {
-	m := this.m /*for performance*/
+	if this.sparsePrecision != 0 {
+		return this.sparsePrecisionCardinality()
+	}
+	if this.estimator == ERTL_ESTIMATOR {
+		return this.ertlCardinality()
+	}
 
 	// compute the "indicator function" -- sum(2^(-M[j])) where M[j] is the
 	// 'j'th register value
-	sum := float64(0)
-	numberOfZeroes := 0 /*"V" in the paper*/
-	for j := uint(0); j < m; j++ {
-		register := this.sparseProbabilisticStorage.get(uint32(j))
+	sum, numberOfZeroes := this.indicatorFunction()
 
-		sum += 1.0 / float64(uint64(1)<<register)
-		if register == 0 {
-			numberOfZeroes++
-		}
-	}
+	return this.estimate(sum, numberOfZeroes)
+}
+
+/**
+ * Applies the estimate and correction of the HLL algorithm to the indicator
+ * function.
+ *
+ * @param sum the "indicator function" -- sum(2^(-M[j])).
+ * @param numberOfZeroes the number of unset registers, "V" in the paper.
+ * @return the exact, unrounded cardinality given by the HLL algorithm
+ */
+func (this *Hll) estimate(sum float64, numberOfZeroes int) float64 {
+	m := this.m /*for performance*/
 
 	// apply the estimate and correction to the indicator function
 	estimator := this.alphaMSquared / sum
@@ -567,6 +886,18 @@ func (this *Hll) sparseProbabilisticAlgorithmCardinality() float64 {
  */
 func (this *Hll) Union(other *Hll) {
 	// TODO: verify HLLs are compatible
+	// NOTE:  the unions write the storage directly, the register counts are
+	//        recomputed on the next #Cardinality()
+	defer func() {
+		this.registerHistogram = nil
+	}()
+	if this.checkpointed {
+		this.markUnionDirty(other)
+	}
+	if this.hllType == SPARSE && other.hllType == SPARSE && this.sparsePrecision != other.sparsePrecision {
+		// the registers can only be combined once folded down
+		this.promoteSparse()
+	}
 	if this.hllType == other.hllType {
 		this.homogeneousUnion(other)
 		return
@@ -589,7 +920,7 @@ func (this *Hll) homogeneousUnion(other *Hll) {
 		// union of empty and empty is empty
 		return
 	case EXPLICIT:
-		it := NewLongHashSetIterator(other.explicitStorage)
+		it := other.explicitStorage.iterator()
 		for it.HasNext() {
 			k := it.Next()
 			this.Add(k)
@@ -597,33 +928,18 @@ func (this *Hll) homogeneousUnion(other *Hll) {
 		// NOTE:  #addRaw() will handle promotion, if necessary
 		return
 	case SPARSE:
-		it := NewInt2ByteHashMapIterator(other.sparseProbabilisticStorage)
+		it := NewSparseListIterator(other.sparseProbabilisticStorage)
 		for it.HasNext() {
-			registerIndex := it.NextKey()
-			registerValue := other.sparseProbabilisticStorage.get(registerIndex)
-			currentRegisterValue := this.sparseProbabilisticStorage.get(registerIndex)
-			if registerValue > currentRegisterValue {
-				this.sparseProbabilisticStorage.put(registerIndex, registerValue)
-			}
+			this.sparseProbabilisticStorage.setMax(it.Next())
 		}
 
 		// promotion, if necessary
-		if this.sparseProbabilisticStorage.size > this.sparseThreshold {
-			this.initializeStorage(FULL)
-			it := NewInt2ByteHashMapIterator(this.sparseProbabilisticStorage)
-			for it.HasNext() {
-				registerIndex := it.NextKey()
-				registerValue := this.sparseProbabilisticStorage.get(registerIndex)
-				this.probabilisticStorage.setMaxRegister(uint64(registerIndex), uint64(registerValue))
-			}
-			this.sparseProbabilisticStorage = nil
+		if this.sparseProbabilisticStorage.Size() > this.sparseThreshold {
+			this.promoteSparse()
 		}
 		return
 	case FULL:
-		for i := uint64(0); i < uint64(this.m); i++ {
-			registerValue := other.probabilisticStorage.getRegister(i)
-			this.probabilisticStorage.setMaxRegister(i, registerValue)
-		}
+		this.probabilisticStorage.unionWith(other.probabilisticStorage)
 		return
 	default:
 		panic(fmt.Sprintf("Unsupported HLL type %d", this.hllType))
@@ -666,7 +982,12 @@ func (this *Hll) heterogenousUnion(other *Hll) {
 
 			if other.explicitStorage.Size() <= this.explicitThreshold {
 				this.hllType = EXPLICIT
-				this.explicitStorage = other.explicitStorage.Clone()
+				this.explicitStorage = other.explicitStorage.clone()
+				// keep the EXPLICIT storage this HLL was set up with
+				if compact := this.explicitCompact; compact != other.explicitCompact {
+					this.explicitCompact = other.explicitCompact
+					this.SetCompactExplicit(compact)
+				}
 			} else {
                 if this.sparseOff || other.explicitStorage.Size() > this.sparseThreshold {
                     this.initializeStorage(FULL)
@@ -674,7 +995,7 @@ func (this *Hll) heterogenousUnion(other *Hll) {
                     this.initializeStorage(SPARSE)
                 }
 
-				it := NewLongHashSetIterator(other.explicitStorage)
+				it := other.explicitStorage.iterator()
 				for it.HasNext() {
 					k := it.Next()
 					this.Add(k)
@@ -685,14 +1006,9 @@ func (this *Hll) heterogenousUnion(other *Hll) {
 			// src:  SPARSE
 			// dest: EMPTY
 
-            if this.sparseOff || other.sparseProbabilisticStorage.Size() > this.sparseThreshold {
+            if this.sparseOff || this.sparsePrecision != other.sparsePrecision || other.sparseProbabilisticStorage.Size() > this.sparseThreshold {
                 this.initializeStorage(FULL)
-                it := NewInt2ByteHashMapIterator(other.sparseProbabilisticStorage)
-                for it.HasNext() {
-                    registerIndex := it.NextKey()
-                    registerValue := other.sparseProbabilisticStorage.get(registerIndex)
-                    this.probabilisticStorage.setMaxRegister(uint64(registerIndex), uint64(registerValue))
-                }
+                other.foldSparseInto(this.probabilisticStorage)
             }else {
                 this.hllType = SPARSE
                 this.sparseProbabilisticStorage = other.sparseProbabilisticStorage.Clone()
@@ -726,14 +1042,9 @@ func (this *Hll) heterogenousUnion(other *Hll) {
 		// NOTE:  destination storage may change through promotion if
 		//        source is SPARSE.
 		if other.hllType == SPARSE {
-            if this.sparseOff || this.explicitStorage.Size() + other.sparseProbabilisticStorage.Size() > this.sparseThreshold {
+            if this.sparseOff || this.sparsePrecision != other.sparsePrecision || this.explicitStorage.Size() + other.sparseProbabilisticStorage.Size() > this.sparseThreshold {
                 this.initializeStorage(FULL)
-                it := NewInt2ByteHashMapIterator(other.sparseProbabilisticStorage)
-                for it.HasNext() {
-                    registerIndex := it.NextKey()
-                    registerValue := other.sparseProbabilisticStorage.get(registerIndex)
-                    this.probabilisticStorage.setMaxRegister(uint64(registerIndex), uint64(registerValue))
-                }
+                other.foldSparseInto(this.probabilisticStorage)
             } else {
 				this.hllType = SPARSE
 				this.sparseProbabilisticStorage = other.sparseProbabilisticStorage.Clone()
@@ -742,7 +1053,7 @@ func (this *Hll) heterogenousUnion(other *Hll) {
 			this.hllType = FULL
 			this.probabilisticStorage = other.probabilisticStorage.Clone()
 		}
-		it := NewLongHashSetIterator(this.explicitStorage)
+		it := this.explicitStorage.iterator()
 		for it.HasNext() {
 			k := it.Next()
 			this.Add(k)
@@ -754,7 +1065,7 @@ func (this *Hll) heterogenousUnion(other *Hll) {
             // src:  EXPLICIT
             // dest: SPARSE
             // Add the raw values from the source to the destination.
-            it := NewLongHashSetIterator(other.explicitStorage)
+            it := other.explicitStorage.iterator()
             for it.HasNext() {
                 k := it.Next()
                 this.Add(k)
@@ -770,13 +1081,7 @@ func (this *Hll) heterogenousUnion(other *Hll) {
 
             this.hllType = FULL
             this.probabilisticStorage = other.probabilisticStorage.Clone()
-
-            it := NewInt2ByteHashMapIterator(this.sparseProbabilisticStorage)
-            for it.HasNext() {
-                registerIndex := it.NextKey()
-                registerValue := this.sparseProbabilisticStorage.get(registerIndex)
-                this.probabilisticStorage.setMaxRegister(uint64(registerIndex), uint64(registerValue))
-            }
+            this.foldSparseInto(this.probabilisticStorage)
             this.sparseProbabilisticStorage = nil
         }
         return
@@ -787,7 +1092,7 @@ func (this *Hll) heterogenousUnion(other *Hll) {
 			// Add the raw values from the source to the destination.
 			// Promotion is not possible, so don't bother checking.
 
-			it := NewLongHashSetIterator(other.explicitStorage)
+			it := other.explicitStorage.iterator()
 			for it.HasNext() {
 				k := it.Next()
 				this.Add(k)
@@ -798,12 +1103,7 @@ func (this *Hll) heterogenousUnion(other *Hll) {
 			// Merge the registers from the source into the destination.
 			// Promotion is not possible, so don't bother checking.
 
-			it := NewInt2ByteHashMapIterator(other.sparseProbabilisticStorage)
-			for it.HasNext() {
-				registerIndex := it.NextKey()
-				registerValue := other.sparseProbabilisticStorage.get(registerIndex)
-				this.probabilisticStorage.setMaxRegister(uint64(registerIndex), uint64(registerValue))
-			}
+			other.foldSparseInto(this.probabilisticStorage)
 		}
 	}
 }
@@ -821,25 +1121,25 @@ func (this *Hll) ToBytes() []byte {
 
 	switch this.hllType {
 	case EMPTY:
-		bytes = make([]byte, HEADER_BYTE_COUNT)
+		bytes = make([]byte, this.headerByteCount())
 		break
 	case EXPLICIT:
-		serializer := newBigEndianAscendingWordSerializer(BITS_PER_LONG, this.explicitStorage.Size())
-		it := NewLongHashSetIterator(this.explicitStorage)
-		for it.HasNext() {
-			k := it.Next()
+		serializer := newBigEndianAscendingWordSerializer2(BITS_PER_LONG, this.explicitStorage.Size(), this.headerByteCount())
+		// NOTE:  the values are written sorted as signed longs, like the
+		//        Java and PostgreSQL implementations do, so that the bytes
+		//        do not depend on the storage or the order of insertion
+		for _, k := range this.sortedExplicitValues() {
 			serializer.writeWord(k)
 		}
 
 		bytes = serializer.getBytes()
 		break
 	case SPARSE:
-		serializer := newBigEndianAscendingWordSerializer(this.shortWordLength, this.sparseProbabilisticStorage.Size())
+		serializer := newBigEndianAscendingWordSerializer2(this.shortWordLength, this.sparseProbabilisticStorage.Size(), this.headerByteCount())
 
-		it := NewInt2ByteHashMapIterator(this.sparseProbabilisticStorage)
+		it := NewSparseListIterator(this.sparseProbabilisticStorage)
 		for it.HasNext() {
-			registerIndex := it.NextKey()
-			registerValue := this.sparseProbabilisticStorage.get(registerIndex)
+			registerIndex, registerValue := it.Next()
 			shortWord := ((uint64(registerIndex) << uint64(this.regwidth)) | uint64(registerValue))
 			//binary.Write(buf, binary.BigEndian, shortWord)
 			serializer.writeWord(shortWord)
@@ -848,7 +1148,7 @@ func (this *Hll) ToBytes() []byte {
 		bytes = serializer.getBytes()
 		break
 	case FULL:
-		serializer := newBigEndianAscendingWordSerializer(this.regwidth, this.m)
+		serializer := newBigEndianAscendingWordSerializer2(this.regwidth, this.m, this.headerByteCount())
 
 		it := NewBitVectorIterator(this.probabilisticStorage)
 		for it.HasNext() {
@@ -868,7 +1168,7 @@ func (this *Hll) ToBytes() []byte {
 }
 
 func (this *Hll) writeMetadata(buf *bytes.Buffer) {
-	typeOrdinal := this.hllType
+	typeOrdinal := int(this.hllType)
 
 	var explicitCutoffValue int
 	if this.explicitOff {
@@ -890,6 +1190,9 @@ func (this *Hll) writeMetadata(buf *bytes.Buffer) {
  *
  * @param  bytes the serialized bytes of new HLL
  * @return the deserialized HLL. This will never be <code>null</code>.
+ *         An error is returned for an unknown schema version or type and
+ *         for a payload whose length does not fit the type, so that
+ *         untrusted bytes never cause a panic.
  *
  * @see #toBytes(ISchemaVersion)
  */
@@ -902,8 +1205,23 @@ func NewHllFromBytes(bytes []byte) (*Hll, error) {
 	parametersByte := bytes[1]
 	cutoffByte := bytes[2]
 
-	//version := schemaVersion(versionByte)
-	hllType := typeOrdinal(versionByte)
+	version := schemaVersion(versionByte)
+	if version != SCHEMA_VERSION && version != SPARSE_PRECISION_SCHEMA_VERSION {
+		return nil, fmt.Errorf("unsupported schema version %d", version)
+	}
+	headerByteCount := uint(HEADER_BYTE_COUNT)
+	sparsePrecision := uint(0)
+	if version == SPARSE_PRECISION_SCHEMA_VERSION {
+		if len(bytes) < SPARSE_PRECISION_HEADER_BYTE_COUNT {
+			return nil, fmt.Errorf("too short bytes:%d", len(bytes))
+		}
+		headerByteCount = SPARSE_PRECISION_HEADER_BYTE_COUNT
+		sparsePrecision = uint(bytes[3])
+	}
+	hllType := Type(typeOrdinal(versionByte))
+	if hllType < EMPTY || hllType > FULL {
+		return nil, fmt.Errorf("unsupported HLL type %d", hllType)
+	}
 	explicitCutoffValue := explicitCutoff(cutoffByte)
 	explicitOff := (explicitCutoffValue == EXPLICIT_OFF)
 	explicitAuto := (explicitCutoffValue == EXPLICIT_AUTO)
@@ -929,11 +1247,29 @@ func NewHllFromBytes(bytes []byte) (*Hll, error) {
 		expthresh = log2ExplicitCutoff + 1
 	}
 
-	hll, err := NewHll5(log2m, regwidth, expthresh, sparseon, hllType)
+	hll, err := NewHll6(log2m, regwidth, expthresh, sparseon, hllType, sparsePrecision)
 	if err != nil {
 		return nil, err
 	}
 
+	// NOTE:  SPARSE words may be padded to any whole byte, so only the
+	//        other types have a payload length to check
+	payloadByteCount := uint(len(bytes)) - headerByteCount
+	switch hllType {
+	case EMPTY:
+		if payloadByteCount != 0 {
+			return nil, fmt.Errorf("EMPTY HLL with %d payload bytes", payloadByteCount)
+		}
+	case EXPLICIT:
+		if payloadByteCount%(BITS_PER_LONG/BITS_PER_BYTE) != 0 {
+			return nil, fmt.Errorf("EXPLICIT payload of %d bytes is not a whole number of values", payloadByteCount)
+		}
+	case FULL:
+		if expected := (hll.m*hll.regwidth + BITS_PER_BYTE - 1) / BITS_PER_BYTE; payloadByteCount != expected {
+			return nil, fmt.Errorf("FULL payload of %d bytes, expected %d", payloadByteCount, expected)
+		}
+	}
+
 	// Short-circuit on empty, which needs no other deserialization.
 	if hllType == EMPTY {
 		return hll, nil
@@ -954,7 +1290,7 @@ func NewHllFromBytes(bytes []byte) (*Hll, error) {
 		panic(fmt.Sprintf("Unsupported HLL type %d", hllType))
 	}
 
-	deserializer := newBigEndianAscendingWordDeserializer(wordLength, HEADER_BYTE_COUNT, bytes)
+	deserializer := newBigEndianAscendingWordDeserializer(wordLength, headerByteCount, bytes)
 
 	switch hllType {
 	case EXPLICIT:
@@ -963,7 +1299,7 @@ func NewHllFromBytes(bytes []byte) (*Hll, error) {
 		//        because the word length is at least a byte wide.
 		// SEE:   IWordDeserializer#totalWordCount()
 		for i := uint(0); i < deserializer.totalWordCount(); i++ {
-			hll.explicitStorage.Add(deserializer.readWord())
+			hll.explicitStorage.add(deserializer.readWord())
 		}
 		break
 	case SPARSE:
@@ -978,7 +1314,7 @@ func NewHllFromBytes(bytes []byte) (*Hll, error) {
 			registerValue := byte(shortWord & hll.valueMask)
 			// Only set non-zero registers.
 			if registerValue != 0 {
-				hll.sparseProbabilisticStorage.put(uint32(shortWord>>hll.regwidth), registerValue)
+				hll.sparseProbabilisticStorage.setMax(uint32(shortWord>>hll.regwidth), registerValue)
 			}
 		}
 		break
@@ -996,6 +1332,8 @@ func NewHllFromBytes(bytes []byte) (*Hll, error) {
 	default:
 		panic(fmt.Sprintf("Unsupported HLL type %d", hllType))
 	}
+	// recomputed from the registers that were read on first use
+	hll.registerHistogram = nil
 
 	return hll, nil
 }
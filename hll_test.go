@@ -19,10 +19,13 @@
 package hll
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -56,7 +59,7 @@ func hyperloglog(clientids []uint64) *Hll {
 	fmt.Printf("clientids:%d\n", len(clientids))
 	t1 := time.Now().UnixNano()
 
-	hllType := -1
+	hllType := Type(-1)
 	h, _ := NewHll(16, 5)
 	for i, clientid := range clientids {
 		if h.hllType != hllType {
@@ -107,3 +110,536 @@ func TestHyperloglogParams(t *testing.T) {
 	fmt.Printf("sparseThreshold:%d\n", h.sparseThreshold)
 	fmt.Printf("shortWordLength:%d\n", h.shortWordLength)
 }
+
+func TestHashBytes(t *testing.T) {
+	cases := []struct {
+		data string
+		hash uint64
+	}{
+		{"", 0},
+		{"hello", 0xcbd8a7b341bd9b02},
+		{"The quick brown fox jumps over the lazy dog", 0xe34bbc7bbc071b6c},
+	}
+	for _, c := range cases {
+		if h := HashString(c.data, 0); h != c.hash {
+			t.Errorf("HashString(%q) = %#x, expected %#x", c.data, h, c.hash)
+		}
+	}
+}
+
+func TestDelta(t *testing.T) {
+	sender, _ := NewHll(11, 5)
+	receiver, _ := NewHll(11, 5)
+
+	var deltas [][]byte
+	var added []uint64
+	for round := 0; round < 6; round++ {
+		values := randClientids(100 * (round + 1))
+		switch round % 3 {
+		case 0:
+			for _, v := range values {
+				sender.Add(v)
+			}
+		case 1:
+			sender.AddMany(values)
+		case 2:
+			other, _ := NewHll(11, 5)
+			other.AddMany(values)
+			sender.Union(other)
+		}
+		added = append(added, values...)
+		deltas = append(deltas, sender.Delta())
+		sender.Checkpoint()
+	}
+	if len(deltas[5]) >= len(sender.ToBytes()) {
+		t.Errorf("delta (%d bytes) is not smaller than the sketch (%d bytes)", len(deltas[5]), len(sender.ToBytes()))
+	}
+
+	// out of order and repeated
+	for _, i := range []int{3, 0, 5, 1, 1, 4, 2, 5} {
+		if err := receiver.ApplyDelta(deltas[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if receiver.Cardinality() != sender.Cardinality() {
+		t.Errorf("receiver has cardinality %d, sender %d", receiver.Cardinality(), sender.Cardinality())
+	}
+	if len(sender.Delta()) != HEADER_BYTE_COUNT {
+		t.Errorf("delta right after a checkpoint is not empty")
+	}
+	// values that were counted before raise no register
+	sender.AddMany(added)
+	if len(sender.Delta()) != HEADER_BYTE_COUNT {
+		t.Errorf("delta of values added before the checkpoint is not empty")
+	}
+}
+
+func TestAddMany(t *testing.T) {
+	for _, count := range []int{0, 1, 10, 100, 1000, 100000} {
+		for _, sparseon := range []bool{true, false} {
+			values := make([]uint64, count)
+			for i := range values {
+				values[i] = rand.Uint64()
+			}
+			// duplicates
+			values = append(values, values[:count/2]...)
+
+			expected, _ := NewHll5(11, 5, -1, sparseon, EMPTY)
+			for _, v := range values {
+				expected.Add(v)
+			}
+			batched, _ := NewHll5(11, 5, -1, sparseon, EMPTY)
+			batched.AddMany(values[:count/3])
+			batched.AddSeq(func(yield func(uint64) bool) {
+				for _, v := range values[count/3:] {
+					if !yield(v) {
+						return
+					}
+				}
+			})
+
+			if batched.hllType != expected.hllType || batched.Cardinality() != expected.Cardinality() {
+				t.Errorf("%d values, sparseon %t: got type %d cardinality %d, expected type %d cardinality %d", count, sparseon, batched.hllType, batched.Cardinality(), expected.hllType, expected.Cardinality())
+				continue
+			}
+			batchedRegisters, expectedRegisters := batched.registerLookup(), expected.registerLookup()
+			for j := uint32(0); j < uint32(expected.m); j++ {
+				if batchedRegisters(j) != expectedRegisters(j) {
+					t.Errorf("%d values, sparseon %t: register %d differs", count, sparseon, j)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestAddManyPromotesLikeAdd(t *testing.T) {
+	for _, c := range []struct {
+		count    int
+		distinct int
+	}{
+		{100, 100},
+		{300, 1},
+		{300, 300},
+		{1000, 2},
+		{1000, 200},
+		{5000, 5000},
+	} {
+		values := make([]uint64, c.count)
+		for i := range values {
+			values[i] = rand.Uint64()
+			if i >= c.distinct {
+				values[i] = values[i%c.distinct]
+			}
+		}
+		for _, sparseon := range []bool{true, false} {
+			batched, _ := NewHll5(11, 5, -1, sparseon, EMPTY)
+			batched.AddMany(values)
+			expected, _ := NewHll5(11, 5, -1, sparseon, EMPTY)
+			for _, v := range values {
+				expected.Add(v)
+			}
+			if batched.Type() != expected.Type() || !bytes.Equal(batched.ToBytes(), expected.ToBytes()) {
+				t.Errorf("%d values, %d distinct, sparseon %t: got %v, expected %v", c.count, c.distinct, sparseon, batched, expected)
+			}
+		}
+	}
+}
+
+func TestSparsePromotion(t *testing.T) {
+	r := rand.New(rand.NewSource(9))
+	h, _ := NewHll5(11, 5, 0, true, EMPTY)
+	batched := h.Clone()
+	registers := make(map[uint32]struct{})
+	for len(registers) <= int(h.sparseThreshold) {
+		v := r.Uint64()
+		if j, p_w := h.registerFor(v); p_w != 0 {
+			registers[j] = struct{}{}
+		}
+		h.Add(v)
+		batched.AddMany([]uint64{v})
+		if len(registers) <= int(h.sparseThreshold) && (h.Type() != SPARSE || batched.Type() != SPARSE) {
+			t.Fatalf("promoted at %d registers, the threshold is %d", len(registers), h.sparseThreshold)
+		}
+	}
+	if h.Type() != FULL || batched.Type() != FULL {
+		t.Errorf("not promoted at %d registers, the threshold is %d", len(registers), h.sparseThreshold)
+	}
+}
+
+func TestRegisterHistogram(t *testing.T) {
+	h, _ := NewHll(11, 5)
+	other, _ := NewHll(11, 5)
+	for round := 0; round < 5; round++ {
+		for _, v := range randClientids(500 * (round + 1)) {
+			h.Add(v)
+		}
+		other.AddMany(randClientids(300))
+		h.Union(other)
+		h.Cardinality()
+		for _, v := range randClientids(200) {
+			h.Add(v)
+		}
+		if h.hllType != FULL {
+			continue
+		}
+
+		incremental := append([]uint32(nil), h.registerHistogram...)
+		h.registerHistogram = nil
+		if rebuilt := h.registerCounts(); fmt.Sprint(rebuilt) != fmt.Sprint(incremental) {
+			t.Errorf("round %d: incremental register counts %v, expected %v", round, incremental, rebuilt)
+		}
+		if h.hllType == FULL {
+			sum, numberOfZeroes := h.probabilisticStorage.sum()
+			indicator, zeroes := h.indicatorFunction()
+			if math.Abs(sum-indicator) > 1e-9 || numberOfZeroes != zeroes {
+				t.Errorf("round %d: indicator function %f/%d, expected %f/%d", round, indicator, zeroes, sum, numberOfZeroes)
+			}
+		}
+	}
+}
+
+func TestHistogramSummary(t *testing.T) {
+	for _, count := range []int{0, 10, 1000, 100000} {
+		h, _ := NewHll(14, 5)
+		h.AddMany(randClientids(count))
+
+		registerHistogram := h.RegisterHistogram()
+		total := uint64(0)
+		for _, c := range registerHistogram {
+			total += c
+		}
+		if len(registerHistogram) != 32 || total != uint64(h.m) {
+			t.Errorf("%d values: histogram of %d values adds up to %d", count, len(registerHistogram), total)
+		}
+
+		bytes := h.HistogramSummary().ToBytes()
+		if len(bytes) > 64 {
+			t.Errorf("%d values: summary takes %d bytes", count, len(bytes))
+		}
+		summary, err := NewHistogramSummaryFromBytes(bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.hllType != EXPLICIT && summary.Cardinality() != h.Cardinality() {
+			t.Errorf("%d values: summary estimates %d, expected %d", count, summary.Cardinality(), h.Cardinality())
+		}
+	}
+
+	if _, err := NewHistogramSummaryFromBytes([]byte{HISTOGRAM_SUMMARY_VERSION, 14, 5, 1, 5}); err == nil {
+		t.Errorf("accepted counts that do not add up to m")
+	}
+}
+
+func TestSparsePrecision(t *testing.T) {
+	if _, err := NewHll6(14, 6, -1, true, EMPTY, 14); err == nil {
+		t.Errorf("accepted a sparse precision of log2m")
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for _, count := range []int{300, 1000, 2000} {
+		precise, _ := NewHll6(14, 6, 0, true, EMPTY, 25)
+		standard, _ := NewHll5(14, 6, 0, true, EMPTY)
+		for i := 0; i < count; i++ {
+			v := r.Uint64()
+			precise.Add(v)
+			standard.Add(v)
+		}
+		if precise.hllType != SPARSE {
+			t.Fatalf("%d values: type %d, expected SPARSE", count, precise.hllType)
+		}
+		if math.Abs(float64(precise.Cardinality())-float64(count)) > 2+0.002*float64(count) {
+			t.Errorf("%d values: cardinality %d", count, precise.Cardinality())
+		}
+
+		// the folded registers are those of a standard HLL
+		if fmt.Sprint(precise.RegisterHistogram()) != fmt.Sprint(standard.RegisterHistogram()) {
+			t.Errorf("%d values: folded register histogram differs", count)
+		}
+
+		restored, err := NewHllFromBytes(precise.ToBytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.sparsePrecision != 25 || restored.Cardinality() != precise.Cardinality() {
+			t.Errorf("%d values: restored sparse precision %d, cardinality %d", count, restored.sparsePrecision, restored.Cardinality())
+		}
+	}
+
+	precise, _ := NewHll6(14, 6, 0, true, EMPTY, 25)
+	standard, _ := NewHll5(14, 6, 0, true, EMPTY)
+	for i := 0; i < 20000; i++ {
+		v := r.Uint64()
+		precise.Add(v)
+		standard.Add(v)
+	}
+	full, _ := NewHll5(14, 6, 0, true, FULL)
+	full.Union(precise)
+	if precise.hllType != FULL || fmt.Sprint(precise.RegisterHistogram()) != fmt.Sprint(standard.RegisterHistogram()) || full.Cardinality() != standard.Cardinality() {
+		t.Errorf("promotion to FULL did not fold down to the standard registers")
+	}
+}
+
+func TestRegisters(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for _, count := range []int{10, 300, 20000} {
+		h, _ := NewHll(11, 5)
+		full, _ := NewHll5(11, 5, 0, false, FULL)
+		for i := 0; i < count; i++ {
+			v := r.Uint64()
+			h.Add(v)
+			full.Add(v)
+		}
+
+		registers := h.Registers()
+		if fmt.Sprint(registers) != fmt.Sprint(full.Registers()) {
+			t.Errorf("%d values: registers differ from a FULL HLL", count)
+		}
+		restored, err := NewHllFromRegisters(11, 5, registers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.Cardinality() != full.Cardinality() || fmt.Sprint(restored.Registers()) != fmt.Sprint(registers) {
+			t.Errorf("%d values: restored cardinality %d, expected %d", count, restored.Cardinality(), full.Cardinality())
+		}
+	}
+
+	if _, err := NewHllFromRegisters(11, 5, make([]byte, 100)); err == nil {
+		t.Errorf("wrong number of registers accepted")
+	}
+	if _, err := NewHllFromRegisters(4, 5, append(make([]byte, 15), 32)); err == nil {
+		t.Errorf("register wider than regwidth accepted")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	h, _ := NewHll(11, 5)
+	if s := fmt.Sprint(h); s != "EMPTY, nregs=2048, nbits=5, expthresh=-1(160), sparseon=1" {
+		t.Errorf("String() = %s", s)
+	}
+	h.Add(3 << 11)
+	h.Add(1<<63 | 5)
+	if s := h.String(); s != "EXPLICIT, 2 elements, nregs=2048, nbits=5, expthresh=-1(160), sparseon=1" {
+		t.Errorf("String() = %s", s)
+	}
+	explain := fmt.Sprintf("%+v", h)
+	if !strings.Contains(explain, "version byte:    0x12 (schema version 1, type EXPLICIT)") ||
+		!strings.Contains(explain, "parameters byte: 0x8b (log2m 11, regwidth 5)") ||
+		!strings.HasSuffix(explain, fmt.Sprintf(":\n0: %20d \n1: %20d ", int64(-1<<63|5), 3<<11)) {
+		t.Errorf("Explain() = %s", explain)
+	}
+
+	// NOTE:  hll_print gives the threshold in values, not as the log2 code
+	explicit, _ := NewHll5(11, 5, 9, true, EMPTY)
+	if s := explicit.String(); s != "EMPTY, nregs=2048, nbits=5, expthresh=256, sparseon=1" {
+		t.Errorf("String() = %s", s)
+	}
+
+	full, _ := NewHll5(4, 5, 0, false, FULL)
+	full.Add(1<<4 | 2)
+	if s := full.Explain(); !strings.HasSuffix(s, "FULL, 1 filled nregs=16, nbits=5, expthresh=0, sparseon=0:\n   0:  0  0  1  0  0  0  0  0  0  0  0  0  0  0  0  0 ") {
+		t.Errorf("Explain() = %s", s)
+	}
+	if s := fmt.Sprintf("%x", full); s != fmt.Sprintf("%x", full.ToBytes()) {
+		t.Errorf("%%x = %s", s)
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for _, c := range []struct {
+		targetRelErr   float64
+		maxCardinality uint64
+		maxBytes       int
+		log2m          uint
+		regwidth       uint
+		expthresh      int
+		sparseon       bool
+		hllType        Type
+	}{
+		{0.02, 1000000, 0, 12, 4, -1, true, FULL},
+		{0.02, 400, 0, 12, 2, -1, true, SPARSE},
+		{0.05, 1000, 10000, 9, 3, 11, false, EXPLICIT},
+		{0.05, 20000, 400, 9, 4, -1, true, FULL},
+		{0.01, 1 << 40, 0, 14, 6, -1, true, FULL},
+	} {
+		rec, err := Recommend(c.targetRelErr, c.maxCardinality, c.maxBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Log2m != c.log2m || rec.Regwidth != c.regwidth || rec.Expthresh != c.expthresh || rec.Sparseon != c.sparseon {
+			t.Fatalf("Recommend(%g, %d, %d) = %+v", c.targetRelErr, c.maxCardinality, c.maxBytes, rec)
+		}
+		if rec.StandardError > c.targetRelErr || len(rec.Reasons) != 4 || rec.Stages[len(rec.Stages)-1].Type != c.hllType {
+			t.Errorf("Recommend(%g, %d, %d) = %+v", c.targetRelErr, c.maxCardinality, c.maxBytes, rec)
+		}
+		if c.maxCardinality > 1000000 {
+			continue
+		}
+
+		h, _ := NewHll5(rec.Log2m, rec.Regwidth, rec.Expthresh, rec.Sparseon, EMPTY)
+		for i := uint64(0); i < c.maxCardinality; i++ {
+			h.Add(r.Uint64())
+		}
+		if size := len(h.ToBytes()); size > rec.PeakBytes {
+			t.Errorf("Recommend(%g, %d, %d): %d bytes, expected at most %d", c.targetRelErr, c.maxCardinality, c.maxBytes, size, rec.PeakBytes)
+		}
+		if math.Abs(float64(h.Cardinality())-float64(c.maxCardinality)) > 3*rec.StandardError*float64(c.maxCardinality) {
+			t.Errorf("Recommend(%g, %d, %d): cardinality %d", c.targetRelErr, c.maxCardinality, c.maxBytes, h.Cardinality())
+		}
+	}
+
+	if _, err := Recommend(0.00001, 1000, 0); err == nil {
+		t.Errorf("expected too many registers")
+	}
+	if _, err := Recommend(0.02, 1000000, 100); err == nil {
+		t.Errorf("expected too few bytes")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	for _, count := range []int{10, 100, 1000, 100000} {
+		values := make([]uint64, count)
+		for i := range values {
+			values[i] = r.Uint64()
+		}
+		h, _ := NewHll(11, 5)
+		h.AddMany(values)
+		registers := fmt.Sprint(h.Registers())
+
+		full := h.Clone()
+		full.ToFull()
+		if full.Type() != FULL || fmt.Sprint(full.Registers()) != registers {
+			t.Errorf("%d values: ToFull() = %v", count, full)
+		}
+		sparse := full.Clone()
+		if err := sparse.ToSparse(); err != nil || sparse.Type() != SPARSE || fmt.Sprint(sparse.Registers()) != registers {
+			t.Errorf("%d values: ToSparse() = %v, %v", count, sparse, err)
+		}
+		if err := sparse.ToExplicit(); err == nil {
+			t.Errorf("%d values: expected SPARSE not to convert to EXPLICIT", count)
+		}
+
+		// EXPLICIT values can be held in any representation, registers only
+		// in SPARSE and FULL
+		probabilisticBytes := min(len(full.ToBytes()), len(sparse.ToBytes()))
+		for _, c := range []*Hll{h.Clone(), full.Clone(), sparse.Clone()} {
+			expected := probabilisticBytes
+			if c.Type() == EXPLICIT {
+				expected = min(expected, len(c.ToBytes()))
+			}
+			before := c.Type()
+			c.Compact()
+			if len(c.ToBytes()) != expected || fmt.Sprint(c.Registers()) != registers {
+				t.Errorf("%d values: Compact() of %v = %v, expected %d bytes", count, before, c, expected)
+			}
+		}
+	}
+
+	empty, _ := NewHll(11, 5)
+	if empty.Compact() != EMPTY || empty.ToExplicit() != nil || empty.Type() != EXPLICIT {
+		t.Errorf("ToExplicit() = %v", empty)
+	}
+	off, _ := NewHll5(11, 5, 0, false, EMPTY)
+	if off.ToSparse() == nil || off.ToExplicit() == nil {
+		t.Errorf("expected representations that are off to be refused")
+	}
+	precise, _ := NewHll6(11, 5, 0, true, FULL, 20)
+	if precise.ToSparse() == nil {
+		t.Errorf("expected FULL registers not to convert to a higher precision")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+	values := make([]uint64, 5000)
+	for i := range values {
+		values[i] = r.Uint64()
+	}
+
+	compact, _ := NewHll(11, 5)
+	compact.SetCompactExplicit(true)
+	hashed, _ := NewHll(11, 5)
+	for i := 99; i >= 0; i-- {
+		hashed.Add(values[i])
+	}
+	compact.AddMany(values[:100])
+	if !Equal(compact, hashed) {
+		t.Errorf("EXPLICIT storages differ: %v", mustDiff(t, compact, hashed))
+	}
+	hashed.Add(values[100])
+	compact.Add(values[101])
+	if d := mustDiff(t, compact, hashed); len(d.OnlyInA) != 1 || d.OnlyInA[0] != values[101] || len(d.OnlyInB) != 1 || d.OnlyInB[0] != values[100] {
+		t.Errorf("Diff() = %+v", d)
+	}
+
+	sparse, _ := NewHll(11, 5)
+	sparse.AddMany(values[:300])
+	full := sparse.Clone()
+	full.ToFull()
+	if !Equal(sparse, full) || !Equal(full, sparse) {
+		t.Errorf("SPARSE and FULL differ: %v", mustDiff(t, sparse, full))
+	}
+	full.AddMany(values[300:])
+	d := mustDiff(t, sparse, full)
+	if len(d.Registers) == 0 || d.CardinalityA >= d.CardinalityB || d.RelativeCardinalityDifference() <= 0 {
+		t.Errorf("Diff() = %v", d)
+	}
+	for _, register := range d.Registers {
+		if register.A >= register.B {
+			t.Errorf("register %d: %d, %d", register.Index, register.A, register.B)
+		}
+	}
+	if !strings.HasPrefix(d.String(), fmt.Sprintf("%d registers differ, cardinality %d vs %d (+", len(d.Registers), d.CardinalityA, d.CardinalityB)) {
+		t.Errorf("String() = %s", d)
+	}
+
+	empty, _ := NewHll(11, 5)
+	if d := mustDiff(t, empty, empty.Clone()); !d.Equal() || d.String() != "equal, cardinality 0 vs 0 (+0.00%)" {
+		t.Errorf("Diff() = %v", d)
+	}
+	// SPARSE registers of a higher precision are compared before folding
+	preciseA, _ := NewHll6(11, 5, 0, true, EMPTY, 20)
+	preciseB, _ := NewHll6(11, 5, 0, true, EMPTY, 20)
+	preciseA.Add(1<<20 | 5)
+	preciseB.Add(1<<20 | 5)
+	// folds to a smaller value of the same register
+	preciseA.Add(1<<20 | 1<<11 | 5)
+	if !Equal(folded(preciseA), folded(preciseB)) {
+		t.Errorf("expected the folded registers to be equal")
+	}
+	if d := mustDiff(t, preciseA, preciseB); d.Equal() || len(d.Registers) != 1 || d.SparsePrecision != 20 || d.Registers[0] != (RegisterDifference{1<<11 | 5, 1, 0}) {
+		t.Errorf("Diff() = %+v", d)
+	}
+	preciseB.Add(1<<20 | 1<<11 | 5)
+	if !Equal(preciseA, preciseB) {
+		t.Errorf("expected equal SPARSE registers: %v", mustDiff(t, preciseA, preciseB))
+	}
+	if d := mustDiff(t, preciseA, folded(preciseA)); d.Equal() || len(d.Registers) != 0 || d.SparsePrecisionA != 20 || d.SparsePrecisionB != 0 {
+		t.Errorf("Diff() = %+v", d)
+	}
+
+	other, _ := NewHll(12, 5)
+	if _, err := Diff(empty, other); err == nil || Equal(empty, other) {
+		t.Errorf("expected incompatible HLLs")
+	}
+}
+
+/**
+ * @return an HLL of the log2m registers that those of h fold down to.
+ */
+func folded(h *Hll) *Hll {
+	f, _ := NewHll6(h.log2m, h.regwidth, 0, true, EMPTY, 0)
+	f.Union(h)
+	return f
+}
+
+func mustDiff(t *testing.T, a *Hll, b *Hll) *Difference {
+	t.Helper()
+	d, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
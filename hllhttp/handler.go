@@ -0,0 +1,245 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package hllhttp exposes an hll.Store over HTTP with JSON requests and
+// responses. Sketches are exchanged in the storage format of
+// hll.Hll#ToBytes(), either raw or as PostgreSQL-style "\x" hex.
+//
+//	POST   /sketches/{key}/add          {"hashes": [...], "values": [...]}
+//	GET    /sketches/{key}/cardinality
+//	GET    /sketches/{key}[?format=hex]
+//	PUT    /sketches/{key}              raw or "\x" hex body
+//	DELETE /sketches/{key}
+//	GET    /sketches
+//	POST   /merge                       {"destination": "...", "keys": [...]}
+package hllhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/l0vest0rm/hll"
+)
+
+const (
+	// largest accepted request body
+	MAX_BODY_BYTES = 64 * 1024 * 1024
+
+	// the cardinality bounds are this many standard errors wide, ~95%
+	BOUNDS_STANDARD_ERRORS = 2
+)
+
+type addRequest struct {
+	// already hashed values, added as they are
+	Hashes []uint64 `json:"hashes"`
+	// raw values, hashed with hll.HashString() and the handler's seed
+	Values []string `json:"values"`
+}
+
+type addResponse struct {
+	Changed bool `json:"changed"`
+}
+
+type cardinalityResponse struct {
+	Key           string  `json:"key"`
+	Cardinality   uint    `json:"cardinality"`
+	StandardError float64 `json:"standard_error"`
+	Lower         float64 `json:"lower"`
+	Upper         float64 `json:"upper"`
+}
+
+type mergeRequest struct {
+	Destination string   `json:"destination"`
+	Keys        []string `json:"keys"`
+}
+
+type mergeResponse struct {
+	Cardinality uint `json:"cardinality"`
+}
+
+type keysResponse struct {
+	Keys []string `json:"keys"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type handler struct {
+	store *hll.Store
+	seed  uint32
+	mux   *http.ServeMux
+}
+
+/**
+ * @param store the sketches to serve.
+ * @param seed the seed with which raw values are hashed on ingest. It must
+ *        match the seed of any hashes that are added directly.
+ */
+func NewHandler(store *hll.Store, seed uint32) http.Handler {
+	this := &handler{store: store, seed: seed, mux: http.NewServeMux()}
+	this.mux.HandleFunc("POST /sketches/{key}/add", this.add)
+	this.mux.HandleFunc("GET /sketches/{key}/cardinality", this.cardinality)
+	this.mux.HandleFunc("GET /sketches/{key}", this.get)
+	this.mux.HandleFunc("PUT /sketches/{key}", this.put)
+	this.mux.HandleFunc("DELETE /sketches/{key}", this.delete)
+	this.mux.HandleFunc("GET /sketches", this.keys)
+	this.mux.HandleFunc("POST /merge", this.merge)
+	return this
+}
+
+func (this *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_BODY_BYTES)
+	this.mux.ServeHTTP(w, r)
+}
+
+func (this *handler) add(w http.ResponseWriter, r *http.Request) {
+	var request addRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	key := r.PathValue("key")
+	changed := false
+	for _, hash := range request.Hashes {
+		if this.store.Add(key, hash) {
+			changed = true
+		}
+	}
+	for _, value := range request.Values {
+		if this.store.Add(key, hll.HashString(value, this.seed)) {
+			changed = true
+		}
+	}
+	writeJSON(w, http.StatusOK, addResponse{Changed: changed})
+}
+
+func (this *handler) cardinality(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	h, ok := this.store.Get(key)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such key %q", key))
+		return
+	}
+
+	cardinality := h.Cardinality()
+	standardError := h.StandardError()
+	delta := float64(cardinality) * standardError * BOUNDS_STANDARD_ERRORS
+	writeJSON(w, http.StatusOK, cardinalityResponse{
+		Key:           key,
+		Cardinality:   cardinality,
+		StandardError: standardError,
+		Lower:         max(float64(cardinality)-delta, 0),
+		Upper:         float64(cardinality) + delta,
+	})
+}
+
+func (this *handler) get(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	h, ok := this.store.Get(key)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such key %q", key))
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "binary":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(h.ToBytes())
+	case "hex":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, h.ToHexString())
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown format %q", r.URL.Query().Get("format")))
+	}
+}
+
+func (this *handler) put(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// NOTE:  the version byte of a serialized HLL is never a backslash, so
+	//        the two formats cannot be confused
+	var h *hll.Hll
+	if bytes.HasPrefix(body, []byte(hll.HEX_PREFIX)) {
+		h, err = hll.NewHllFromHexString(string(body))
+	} else {
+		h, err = hll.NewHllFromBytes(body)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := this.store.Set(r.PathValue("key"), h); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (this *handler) delete(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if !this.store.Delete(key) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such key %q", key))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (this *handler) keys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, keysResponse{Keys: this.store.Keys()})
+}
+
+func (this *handler) merge(w http.ResponseWriter, r *http.Request) {
+	var request mergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if request.Destination == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing destination"))
+		return
+	}
+
+	for _, key := range request.Keys {
+		h, ok := this.store.Get(key)
+		if !ok {
+			continue
+		}
+		if err := this.store.Merge(request.Destination, h); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, mergeResponse{Cardinality: this.store.Cardinality(request.Destination)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,92 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hllhttp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/l0vest0rm/hll"
+)
+
+func TestHandler(t *testing.T) {
+	store, _ := hll.NewStore(hll.StoreConfig{Log2m: 11, Regwidth: 5, Expthresh: -1, Sparseon: true})
+	server := httptest.NewServer(NewHandler(store, 0))
+	defer server.Close()
+
+	do := func(method, path, body string) (int, string) {
+		request, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		data, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(data)
+	}
+
+	if status, _ := do("POST", "/sketches/a/add", `{"values": ["x", "y"], "hashes": [12345678901234567890]}`); status != http.StatusOK {
+		t.Fatalf("add: %d", status)
+	}
+	status, body := do("GET", "/sketches/a/cardinality", "")
+	var cardinality cardinalityResponse
+	json.Unmarshal([]byte(body), &cardinality)
+	if status != http.StatusOK || cardinality.Cardinality != 3 || cardinality.StandardError != 0 {
+		t.Errorf("cardinality: %d %s", status, body)
+	}
+
+	status, sketch := do("GET", "/sketches/a?format=hex", "")
+	if status != http.StatusOK || !strings.HasPrefix(sketch, hll.HEX_PREFIX) {
+		t.Fatalf("get: %d %s", status, sketch)
+	}
+	if status, _ := do("PUT", "/sketches/b", sketch); status != http.StatusNoContent {
+		t.Errorf("put: %d", status)
+	}
+	do("POST", "/sketches/c/add", `{"values": ["z"]}`)
+
+	status, body = do("POST", "/merge", `{"destination": "d", "keys": ["b", "c"]}`)
+	if status != http.StatusOK || !strings.Contains(body, `"cardinality":4`) {
+		t.Errorf("merge: %d %s", status, body)
+	}
+	if status, _ := do("DELETE", "/sketches/d", ""); status != http.StatusNoContent {
+		t.Errorf("delete: %d", status)
+	}
+	if status, _ := do("GET", "/sketches/d", ""); status != http.StatusNotFound {
+		t.Errorf("get deleted: %d", status)
+	}
+
+	h, _ := hll.NewHll5(11, 5, 0, false, hll.FULL)
+	h.Add(1)
+	full := string(h.ToBytes())
+	for _, c := range []struct {
+		name   string
+		sketch string
+	}{
+		{"type 0", "\x10\x8b\x7f"},
+		{"type 5", "\x15\x8b\x7f"},
+		{"truncated EXPLICIT", sketch[:len(sketch)-2]},
+		{"truncated FULL", full[:len(full)-1]},
+	} {
+		if status, body := do("PUT", "/sketches/e", c.sketch); status != http.StatusBadRequest {
+			t.Errorf("put %s: %d %s", c.name, status, body)
+		}
+	}
+}
@@ -20,14 +20,14 @@
 package hll
 
 import(
-    "errors"
+    "github.com/l0vest0rm/hll/hashtable"
 )
 
 const(
     /** The initial default size of a hash table. */
-    DEFAULT_INITIAL_SIZE = 16
+    DEFAULT_INITIAL_SIZE = hashtable.DEFAULT_INITIAL_SIZE
     /** The default load factor of a hash table. */
-    DEFAULT_LOAD_FACTOR = .75
+    DEFAULT_LOAD_FACTOR = hashtable.DEFAULT_LOAD_FACTOR
     /** The load factor for a (usually small) table that is meant to be particularly fast. */
     FAST_LOAD_FACTOR = .5
     /** The load factor for a (usually very small) table that is meant to be extremely fast. */
@@ -35,20 +35,8 @@ const(
 )
 
 type LongHashSet struct {
-    /** The array of keys. */
-    key []uint64
-    /** The array telling whether a position is used. */
-    used []bool
-    /** The acceptable load factor. */
-    f float64
-    /** The current table size. */
-    n uint
-    /** Threshold after which we rehash. It must be the table size times {@link #f}. */
-    maxFill uint
-    /** The mask for wrapping a position counter. */
-    mask uint64
-    /** Number of entries in the set. */
-    size uint
+    /** The table of keys. */
+    table *hashtable.Table[uint64, struct{}]
 }
 
 func NewLongHashSet() (*LongHashSet,error) {
@@ -56,152 +44,103 @@ func NewLongHashSet() (*LongHashSet,error) {
 }
 
 /** Creates a new hash set.
-	 *
-	 * <p>The actual table size will be the least power of two greater than <code>expected</code>/<code>f</code>.
 	 *
 	 * @param expected the expected number of elements in the hash set.
 	 * @param f the load factor.
 	 */
 func NewLongHashSet2(expected uint, f float64) (*LongHashSet,error){
-    this := &LongHashSet{}
-    if  f <= 0 || f > 1 {
-        return nil, errors.New("Load factor must be greater than 0 and smaller than or equal to 1")
+    table, err := hashtable.New[uint64, struct{}](murmur3Hash64, int(expected), f)
+    if err != nil {
+        return nil, err
     }
+    return &LongHashSet{table: table}, nil
+}
 
-    if  expected < 0 {
-        return nil,errors.New("The expected number of elements must be nonnegative")
-    }
+func (this *LongHashSet) Clone() *LongHashSet {
+    return &LongHashSet{table: this.table.Clone()}
+}
 
-    this.f = f
-    this.n = arraySize( expected, f )
-    this.mask = uint64(this.n - 1)
-    this.maxFill = maxFill( this.n, f )
-    this.key = make([]uint64, this.n)
-    this.used = make([]bool, this.n)
+func (this *LongHashSet) clone() explicitSet {
+    return this.Clone()
+}
 
-    return this, nil
+func (this *LongHashSet) iterator() explicitIterator {
+    return NewLongHashSetIterator(this)
 }
 
-func (this *LongHashSet) Clone() *LongHashSet {
-    c := &LongHashSet{}
-    c.f = this.f
-    c.n = this.n
-    c.mask = this.mask
-    c.maxFill = this.maxFill
-    c.size = this.size
-    c.key = make([]uint64, c.n)
-    copy(c.key, this.key)
-    c.used = make([]bool, c.n)
-    copy(c.used, this.used)
-    return c
-}
-
-func (this *LongHashSet)Add(k uint64 ) bool {
-    // The starting point.
-    pos := murmur3Hash64( (k) ^ this.mask ) & this.mask;
-    // There's always an unused entry.
-    for ;this.used[ pos ];{
-        if this.key[pos] == k {
-            return false
-        }
-        pos = ( pos + 1 ) & this.mask
-    }
-    this.used[ pos ] = true
-    this.key[ pos ] = k
-    if this.size >= this.maxFill{
-        this.rehash( arraySize(this.size + 1, this.f ) )
+/** Adds a key to the set.
+	 *
+	 * @return <code>true</code> if the key was not in the set yet, and an
+	 *         error if the set would outgrow hashtable.MAXIMUM_CAPACITY.
+	 */
+func (this *LongHashSet)Add(k uint64 ) (bool, error) {
+    _, found, err := this.table.Put(k, struct{}{})
+    return !found, err
+}
+
+/** Adds a key to the set as the storage of an EXPLICIT HLL.
+	 *
+	 * NOTE:  this panics instead of returning the error of #Add(), which is
+	 *        unreachable: an EXPLICIT HLL holds at most one value more than
+	 *        MAXIMUM_EXPLICIT_THRESHOLD (2^17) before it is promoted, far
+	 *        below hashtable.MAXIMUM_CAPACITY (2^30), so Hll#Add() need not
+	 *        return an error.
+	 */
+func (this *LongHashSet) add(k uint64) bool {
+    added, err := this.Add(k)
+    if err != nil {
+        panic(err)
     }
-    this.size += 1
+    return added
+}
 
-    return true;
+func (this *LongHashSet) Contains(k uint64) bool {
+    return this.table.Contains(k)
+}
+
+/** Removes a key from the set.
+	 *
+	 * @return <code>true</code> if the key was in the set.
+	 */
+func (this *LongHashSet) Remove(k uint64) bool {
+    _, found := this.table.Delete(k)
+    return found
 }
 
 func (this *LongHashSet)Size() uint {
-    return this.size
+    return uint(this.table.Len())
 }
 
-/** Rehashes the set.
-	 *
-	 * <P>This method implements the basic rehashing strategy, and may be
-	 * overriden by subclasses implementing different rehashing strategies (e.g.,
-	 * disk-based rehashing). However, you should not override this method
-	 * unless you understand the internal workings of this class.
-	 *
-	 * @param newN the new size
+/** Grows the table, if needed, so that <code>expected</code> elements fit
+	 * without rehashing.
 	 */
-func (this *LongHashSet) rehash(newN uint) {
-    i := 0
-    used := this.used;
-    key := this.key;
-    mask := uint64(newN - 1) // Note that this is used by the hashing macro
-    newKey := make([]uint64, newN)
-    newUsed := make([]bool, newN)
-    for j := this.size; j > 0; j--{
-        for ; !used[ i ];{
-            i += 1
-        }
-
-        k := key[ i ];
-        pos := murmur3Hash64( (k) ^ mask ) & mask;
-        for ;newUsed[ pos ];{
-            pos = ( pos + 1 ) & mask
-        }
-
-        newUsed[ pos ] = true;
-        newKey[ pos ] = k;
-        i++;
-    }
-    this.n = newN;
-    this.mask = mask;
-    this.maxFill = maxFill( this.n, this.f );
-    this.key = newKey;
-    this.used = newUsed;
+func (this *LongHashSet) ensureCapacity(expected uint) {
+    // NOTE:  failing to grow only means that #Add() rehashes later
+    _ = this.table.Grow(int(expected) - this.table.Len())
+}
+
+/** Returns the number of bytes held by the backing arrays of this set. */
+func (this *LongHashSet) sizeInBytes() uint {
+    return uint(this.table.SizeInBytes())
 }
 
 type LongHashSetIterator struct {
-    longHashSet *LongHashSet
-    pos uint
-    c uint
+    it *hashtable.Iterator[uint64, struct{}]
 }
 
 func NewLongHashSetIterator(longHashSet *LongHashSet) *LongHashSetIterator{
-    this := &LongHashSetIterator{}
-    this.longHashSet = longHashSet
-    this.c = longHashSet.size
-    this.pos = longHashSet.n
-
-    used := longHashSet.used
-    if this.c != 0 {
-        this.pos -= 1
-        for ; !used[ this.pos ]; {
-            this.pos -= 1
-        }
-    }
-
-    return this
+    return &LongHashSetIterator{it: longHashSet.table.Iterator()}
 }
 
 func (this *LongHashSetIterator)HasNext() bool {
-    return this.c != 0
+    return this.it.HasNext()
 }
 
 func (this *LongHashSetIterator)Next() uint64 {
-    if !this.HasNext(){
+    if !this.it.HasNext(){
         panic("LongHashSetIterator,Next,no more element")
-        return 0
-    }
-
-    this.c -= 1
-    it := this.longHashSet
-    retVal := it.key[this.pos]
-    if this.c != 0 {
-        for ;this.pos != 0; {
-            this.pos -= 1
-            if it.used[ this.pos ] {
-                break
-            }
-        }
     }
 
-    return retVal
+    k, _ := this.it.Next()
+    return k
 }
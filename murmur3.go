@@ -0,0 +1,120 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	murmur3C1 = 0x87c37b91114253d5
+	murmur3C2 = 0x4cf5ad432745937f
+)
+
+/**
+ * Hashes <code>data</code> with the x64 128-bit variant of MurmurHash3 and
+ * returns the first 64 bits. This matches <code>hll_hash_bytea()</code> and
+ * friends of the PostgreSQL implementation and the <code>asLong()</code> of
+ * Guava's <code>murmur3_128(seed)</code>, so values hashed here can be
+ * unioned with HLLs built by those.
+ *
+ * @param data the bytes to hash.
+ * @param seed the hash seed. It must be the same for all inputs of the HLLs
+ *        that are going to be unioned.
+ * @return the hash to pass to Hll#Add().
+ */
+func HashBytes(data []byte, seed uint32) uint64 {
+	h1, _ := murmur3Hash128(data, seed)
+	return h1
+}
+
+/**
+ * Same as #HashBytes() for the UTF-8 bytes of <code>s</code>, which is what
+ * <code>hll_hash_text()</code> hashes.
+ */
+func HashString(s string, seed uint32) uint64 {
+	return HashBytes([]byte(s), seed)
+}
+
+// REF:  https://github.com/aappleby/smhasher/blob/master/src/MurmurHash3.cpp
+func murmur3Hash128(data []byte, seed uint32) (uint64, uint64) {
+	h1 := uint64(seed)
+	h2 := uint64(seed)
+	length := len(data)
+
+	// body
+	for len(data) >= 16 {
+		k1 := binary.LittleEndian.Uint64(data)
+		k2 := binary.LittleEndian.Uint64(data[8:])
+		data = data[16:]
+
+		k1 *= murmur3C1
+		k1 = bits.RotateLeft64(k1, 31)
+		k1 *= murmur3C2
+		h1 ^= k1
+
+		h1 = bits.RotateLeft64(h1, 27)
+		h1 += h2
+		h1 = h1*5 + 0x52dce729
+
+		k2 *= murmur3C2
+		k2 = bits.RotateLeft64(k2, 33)
+		k2 *= murmur3C1
+		h2 ^= k2
+
+		h2 = bits.RotateLeft64(h2, 31)
+		h2 += h1
+		h2 = h2*5 + 0x38495ab5
+	}
+
+	// tail
+	var k1, k2 uint64
+	for i := len(data) - 1; i >= 8; i-- {
+		k2 ^= uint64(data[i]) << (uint(i-8) * 8)
+	}
+	if len(data) > 8 {
+		k2 *= murmur3C2
+		k2 = bits.RotateLeft64(k2, 33)
+		k2 *= murmur3C1
+		h2 ^= k2
+	}
+	for i := min(len(data), 8) - 1; i >= 0; i-- {
+		k1 ^= uint64(data[i]) << (uint(i) * 8)
+	}
+	if len(data) > 0 {
+		k1 *= murmur3C1
+		k1 = bits.RotateLeft64(k1, 31)
+		k1 *= murmur3C2
+		h1 ^= k1
+	}
+
+	// finalization
+	h1 ^= uint64(length)
+	h2 ^= uint64(length)
+
+	h1 += h2
+	h2 += h1
+
+	h1 = murmur3Hash64(h1)
+	h2 = murmur3Hash64(h2)
+
+	h1 += h2
+	h2 += h1
+
+	return h1, h2
+}
@@ -0,0 +1,131 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// registers per line of #Explain(), as in postgresql-hll's hll_print
+	EXPLAIN_REGISTERS_PER_LINE = 32
+)
+
+/**
+ * @return the EXPLICIT threshold in the format of postgresql-hll's
+ *         hll_print, which gives it in values rather than as the log2 code
+ *         of #NewHll5(): "-1(n)" with the computed threshold n in auto mode,
+ *         "0" when off and the threshold, e.g. "256", otherwise.
+ */
+func (this *Hll) expthreshString() string {
+	switch {
+	case this.explicitAuto:
+		return fmt.Sprintf("-1(%d)", this.explicitThreshold)
+	case this.explicitOff:
+		return "0"
+	default:
+		return fmt.Sprint(this.explicitThreshold)
+	}
+}
+
+/**
+ * @return the summary line of postgresql-hll's hll_print, e.g.
+ *         <code>EXPLICIT, 3 elements, nregs=2048, nbits=5, expthresh=-1(160), sparseon=1</code>.
+ *         SPARSE and FULL HLLs give the number of registers that are set
+ *         where hll_print prints COMPRESSED.
+ */
+func (this *Hll) String() string {
+	var sparseon int
+	if !this.sparseOff {
+		sparseon = 1
+	}
+	parameters := fmt.Sprintf("nregs=%d, nbits=%d, expthresh=%s, sparseon=%d", this.m, this.regwidth, this.expthreshString(), sparseon)
+
+	switch this.hllType {
+	case EXPLICIT:
+		return fmt.Sprintf("EXPLICIT, %d elements, %s", this.explicitStorage.Size(), parameters)
+	case SPARSE, FULL:
+		filled := uint32(this.m) - this.registerCounts()[0]
+		return fmt.Sprintf("%s, %d filled %s", this.hllType, filled, parameters)
+	default:
+		return fmt.Sprintf("%s, %s", this.hllType, parameters)
+	}
+}
+
+/**
+ * @return a multi-line description of the HLL: its decoded header bytes, the
+ *         thresholds between its representations and, as postgresql-hll's
+ *         hll_print shows them, #String() followed by the EXPLICIT values in
+ *         ascending order or all registers, 32 to a line.
+ */
+func (this *Hll) Explain() string {
+	var b strings.Builder
+
+	header := make([]byte, this.headerByteCount())
+	writeMetadata(header, this)
+	fmt.Fprintf(&b, "version byte:    0x%02x (schema version %d, type %s)\n", header[0], header[0]>>NIBBLE_BITS, Type(header[0]&NIBBLE_MASK))
+	fmt.Fprintf(&b, "parameters byte: 0x%02x (log2m %d, regwidth %d)\n", header[1], header[1]&LOG2_REGISTER_COUNT_MASK, header[1]>>LOG2_REGISTER_COUNT_BITS+1)
+	fmt.Fprintf(&b, "cutoff byte:     0x%02x (expthresh %s, sparseon %t)\n", header[2], this.expthreshString(), header[2]&(1<<EXPLICIT_CUTOFF_BITS) != 0)
+	if len(header) > HEADER_BYTE_COUNT {
+		fmt.Fprintf(&b, "precision byte:  0x%02x (sparse precision %d)\n", header[3], header[3])
+	}
+	fmt.Fprintf(&b, "explicitThreshold=%d, sparseThreshold=%d, shortWordLength=%d\n", this.explicitThreshold, this.sparseThreshold, this.shortWordLength)
+
+	b.WriteString(this.String())
+	switch this.hllType {
+	case EXPLICIT:
+		b.WriteString(":")
+		for i, value := range this.sortedExplicitValues() {
+			fmt.Fprintf(&b, "\n%d: %20d ", i, int64(value))
+		}
+	case SPARSE, FULL:
+		b.WriteString(":")
+		for registerIndex, registerValue := range this.Registers() {
+			if registerIndex%EXPLAIN_REGISTERS_PER_LINE == 0 {
+				fmt.Fprintf(&b, "\n%4d: ", registerIndex)
+			}
+			fmt.Fprintf(&b, "%2d ", registerValue)
+		}
+	}
+	return b.String()
+}
+
+/**
+ * Implements fmt.Formatter: %v and %s print #String(), %+v prints
+ * #Explain(), %q a quoted #String() and %x and %X the bytes of #ToBytes() in
+ * hex.
+ */
+func (this *Hll) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if f.Flag('+') {
+			io.WriteString(f, this.Explain())
+			return
+		}
+		io.WriteString(f, this.String())
+	case 's':
+		io.WriteString(f, this.String())
+	case 'q':
+		fmt.Fprintf(f, "%q", this.String())
+	case 'x', 'X':
+		fmt.Fprintf(f, fmt.FormatString(f, verb), this.ToBytes())
+	default:
+		fmt.Fprintf(f, "%%!%c(*hll.Hll=%s)", verb, this.String())
+	}
+}
@@ -0,0 +1,199 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+/**
+ * The parameters that #Recommend() picked and why.
+ */
+type Recommendation struct {
+	Log2m     uint
+	Regwidth  uint
+	Expthresh int
+	Sparseon  bool
+
+	// the relative standard error of the probabilistic types, 1.04/sqrt(m)
+	StandardError float64
+	// the cardinality above which registers approach saturation and the
+	// large range correction applies, 2^L/30 (see TWO_TO_L)
+	SaturationCutoff float64
+	// the serialized size of the HLL at the largest cardinality it is
+	// expected to count
+	PeakBytes int
+	// the representations the HLL goes through up to that cardinality
+	Stages []RecommendedStage
+	// one line per parameter explaining its choice
+	Reasons []string
+}
+
+/**
+ * A representation an HLL goes through as its cardinality grows.
+ */
+type RecommendedStage struct {
+	Type Type
+	// the largest cardinality held in this representation. For SPARSE this
+	// is the number of set registers, which is about the cardinality while
+	// few registers collide.
+	MaxCardinality uint64
+	// the serialized size at that cardinality
+	MaxBytes int
+}
+
+/**
+ * Recommends the parameters of an HLL from accuracy and size targets:
+ * <ul>
+ *   <li>log2m is the smallest whose standard error 1.04/sqrt(m) is at most
+ *       targetRelErr,</li>
+ *   <li>regwidth is the smallest whose registers do not saturate below
+ *       maxCardinality, i.e. that keeps it under the large range correction
+ *       cutoff,</li>
+ *   <li>expthresh and sparseon keep the serialized size at maxCardinality
+ *       within maxBytes, preferring exact EXPLICIT counts when they fit,
+ *       then the automatic EXPLICIT threshold and the SPARSE
+ *       representation.</li>
+ * </ul>
+ *
+ * @param targetRelErr the largest acceptable relative standard error, in
+ *        (0, 1).
+ * @param maxCardinality the largest cardinality the HLL is expected to count.
+ * @param maxBytes the largest acceptable serialized size, or zero for no
+ *        limit.
+ * @return the recommended parameters, or an error explaining which target
+ *         cannot be met.
+ */
+func Recommend(targetRelErr float64, maxCardinality uint64, maxBytes int) (*Recommendation, error) {
+	if !(targetRelErr > 0 && targetRelErr < 1) {
+		return nil, fmt.Errorf("targetRelErr must be greater than 0 and less than 1 (was %g)", targetRelErr)
+	}
+	if maxCardinality == 0 {
+		return nil, errors.New("maxCardinality must be at least 1")
+	}
+	if maxBytes < 0 {
+		return nil, fmt.Errorf("maxBytes must be zero or positive (was %d)", maxBytes)
+	}
+
+	var this Recommendation
+
+	// 1.04/sqrt(2^log2m) <= targetRelErr
+	m := math.Pow(1.04/targetRelErr, 2)
+	log2m := max(uint(math.Ceil(math.Log2(m))), MINIMUM_LOG2M_PARAM)
+	if log2m > MAXIMUM_LOG2M_PARAM {
+		return nil, fmt.Errorf("a relative error of %g needs 2^%d registers, more than the maximum of 2^%d", targetRelErr, log2m, MAXIMUM_LOG2M_PARAM)
+	}
+	this.Log2m = log2m
+	this.StandardError = 1.04 / math.Sqrt(float64(uint64(1)<<log2m))
+	this.Reasons = append(this.Reasons, fmt.Sprintf("log2m=%d: 1.04/sqrt(2^%d) = %.4g is the first standard error at most %g", log2m, log2m, this.StandardError, targetRelErr))
+
+	for regwidth := uint(MINIMUM_REGWIDTH_PARAM); regwidth <= MAXIMUM_REGWIDTH_PARAM; regwidth++ {
+		if cutoff := largeEstimatorCutoff(log2m, regwidth); cutoff >= float64(maxCardinality) {
+			this.Regwidth = regwidth
+			this.SaturationCutoff = cutoff
+			break
+		}
+	}
+	if this.Regwidth == 0 {
+		return nil, fmt.Errorf("registers of %d bits saturate below a cardinality of %d with log2m=%d", MAXIMUM_REGWIDTH_PARAM, maxCardinality, log2m)
+	}
+	if this.Regwidth == MINIMUM_REGWIDTH_PARAM {
+		this.Reasons = append(this.Reasons, fmt.Sprintf("regwidth=%d: registers of %d bits do not saturate below %.4g", this.Regwidth, this.Regwidth, this.SaturationCutoff))
+	} else {
+		this.Reasons = append(this.Reasons, fmt.Sprintf("regwidth=%d: registers of %d bits do not saturate below %.4g, those of %d bits would below %.4g", this.Regwidth, this.Regwidth, this.SaturationCutoff, this.Regwidth-1, largeEstimatorCutoff(log2m, this.Regwidth-1)))
+	}
+
+	// candidates for expthresh and sparseon, by preference
+	type candidate struct {
+		expthresh int
+		sparseon  bool
+		reason    string
+	}
+	var candidates []candidate
+	if maxBytes > 0 && maxCardinality <= MAXIMUM_EXPLICIT_THRESHOLD {
+		// 2^(expthresh - 1) >= maxCardinality
+		expthresh := 1 + int(math.Ceil(math.Log2(float64(maxCardinality))))
+		candidates = append(candidates, candidate{expthresh, false, fmt.Sprintf("expthresh=%d: the EXPLICIT representation holds up to %d values, so counts stay exact", expthresh, 1<<(expthresh-1))})
+	}
+	candidates = append(candidates,
+		candidate{-1, true, "expthresh=-1: counts are exact for as long as the EXPLICIT representation takes no more room than the FULL one"},
+		candidate{-1, false, "expthresh=-1: counts are exact for as long as the EXPLICIT representation takes no more room than the FULL one"},
+		candidate{0, true, "expthresh=0: the EXPLICIT representation would not fit"},
+		candidate{0, false, "expthresh=0: the EXPLICIT representation would not fit"},
+	)
+
+	smallest := math.MaxInt
+	for _, c := range candidates {
+		hll, err := NewHll5(log2m, this.Regwidth, c.expthresh, c.sparseon, EMPTY)
+		if err != nil {
+			return nil, err
+		}
+		stages := recommendedStages(hll, maxCardinality)
+		peakBytes := 0
+		for _, stage := range stages {
+			peakBytes = max(peakBytes, stage.MaxBytes)
+		}
+		smallest = min(smallest, peakBytes)
+		if maxBytes > 0 && peakBytes > maxBytes {
+			continue
+		}
+
+		this.Expthresh, this.Sparseon = c.expthresh, c.sparseon
+		this.PeakBytes, this.Stages = peakBytes, stages
+		this.Reasons = append(this.Reasons, c.reason)
+		switch {
+		case c.sparseon:
+			this.Reasons = append(this.Reasons, fmt.Sprintf("sparseon=true: the SPARSE representation holds up to %d registers in less room than the FULL one", hll.sparseThreshold))
+		case c.expthresh > 0:
+			this.Reasons = append(this.Reasons, "sparseon=false: the SPARSE representation is not reached")
+		case hll.sparseThreshold <= hll.explicitThreshold:
+			// NOTE:  an EXPLICIT HLL that outgrows the SPARSE threshold is
+			//        promoted straight to FULL
+			this.Reasons = append(this.Reasons, "sparseon=false: the SPARSE representation would be skipped")
+		default:
+			this.Reasons = append(this.Reasons, fmt.Sprintf("sparseon=false: the SPARSE representation would exceed %d bytes", maxBytes))
+		}
+		return &this, nil
+	}
+	return nil, fmt.Errorf("an HLL with log2m=%d and regwidth=%d takes at least %d bytes at a cardinality of %d, more than %d", log2m, this.Regwidth, smallest, maxCardinality, maxBytes)
+}
+
+/**
+ * @return the representations that an EMPTY HLL with the parameters of
+ *         <code>hll</code> goes through up to maxCardinality, with their
+ *         serialized sizes.
+ */
+func recommendedStages(hll *Hll, maxCardinality uint64) []RecommendedStage {
+	var stages []RecommendedStage
+	headerBytes := int(hll.headerByteCount())
+	reached := uint64(0)
+
+	if !hll.explicitOff {
+		reached = min(uint64(hll.explicitThreshold), maxCardinality)
+		stages = append(stages, RecommendedStage{EXPLICIT, reached, headerBytes + int(reached)*BITS_PER_LONG/BITS_PER_BYTE})
+	}
+	if !hll.sparseOff && reached < maxCardinality && uint64(hll.sparseThreshold) > reached {
+		reached = min(uint64(hll.sparseThreshold), maxCardinality)
+		stages = append(stages, RecommendedStage{SPARSE, reached, headerBytes + int((reached*uint64(hll.shortWordLength)+BITS_PER_BYTE-1)/BITS_PER_BYTE)})
+	}
+	if reached < maxCardinality {
+		stages = append(stages, RecommendedStage{FULL, maxCardinality, headerBytes + int((uint64(hll.m)*uint64(hll.regwidth)+BITS_PER_BYTE-1)/BITS_PER_BYTE)})
+	}
+	return stages
+}
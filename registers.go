@@ -0,0 +1,106 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"fmt"
+)
+
+/**
+ * @return the values of all 2^log2m registers, as a FULL HLL with the same
+ *         parameters would hold them. EXPLICIT values are mapped to the
+ *         registers they would set and SPARSE registers of a higher
+ *         precision are folded, see #foldSparse().
+ */
+func (this *Hll) Registers() []byte {
+	registers := make([]byte, this.m)
+	switch this.hllType {
+	case EMPTY:
+	case EXPLICIT:
+		it := this.explicitStorage.iterator()
+		for it.HasNext() {
+			j, p_w := this.registerFor(it.Next())
+			registers[j] = max(registers[j], p_w)
+		}
+	default:
+		this.forEachRegister(func(registerIndex uint32, registerValue byte) {
+			registers[registerIndex] = registerValue
+		})
+	}
+	return registers
+}
+
+/**
+ * Creates an HLL with the parameters of #NewHll() from the values of its
+ * registers, e.g. to import a sketch from another implementation that
+ * indexes registers and computes their values the same way. It is SPARSE if
+ * the registers that are set fit under the SPARSE threshold, FULL
+ * otherwise.
+ *
+ * @param registers the values of all 2^log2m registers, each less than
+ *        2^regwidth.
+ */
+func NewHllFromRegisters(log2m uint, regwidth uint, registers []byte) (*Hll, error) {
+	this, err := NewHll(log2m, regwidth)
+	if err != nil {
+		return nil, err
+	}
+	if uint(len(registers)) != this.m {
+		return nil, fmt.Errorf("%d registers for log2m %d", len(registers), log2m)
+	}
+
+	set := make(map[uint32]byte)
+	for registerIndex, registerValue := range registers {
+		if uint64(registerValue) > this.valueMask {
+			return nil, fmt.Errorf("register %d is %d, more than regwidth %d holds", registerIndex, registerValue, regwidth)
+		}
+		if registerValue != 0 {
+			set[uint32(registerIndex)] = registerValue
+		}
+	}
+	this.setRegisters(set)
+	return this, nil
+}
+
+/**
+ * Replaces the contents of an EMPTY HLL by the given registers, in SPARSE
+ * storage if they fit under the SPARSE threshold and in FULL storage
+ * otherwise.
+ *
+ * @param registers the non-zero registers by index, for the log2m registers
+ *        of a FULL HLL.
+ */
+func (this *Hll) setRegisters(registers map[uint32]byte) {
+	if len(registers) == 0 {
+		return
+	}
+	// NOTE:  the registers are not of the SPARSE precision
+	this.setSparsePrecision(0)
+
+	if !this.sparseOff && uint(len(registers)) <= this.sparseThreshold {
+		this.initializeStorage(SPARSE)
+		for registerIndex, registerValue := range registers {
+			this.sparseProbabilisticStorage.setMax(registerIndex, registerValue)
+		}
+	} else {
+		this.initializeStorage(FULL)
+		for registerIndex, registerValue := range registers {
+			previous := byte(this.probabilisticStorage.getAndSetMaxRegister(uint64(registerIndex), uint64(registerValue)))
+			this.registerChanged(previous, registerValue)
+		}
+	}
+}
@@ -32,6 +32,13 @@ const (
     // number of header bytes for all HLL types
     HEADER_BYTE_COUNT = 3
 
+    /**
+         * The schema version of HLLs with a SPARSE precision (see NewHll6()),
+         * which is recorded in a fourth header byte.
+         */
+    SPARSE_PRECISION_SCHEMA_VERSION = 2
+    SPARSE_PRECISION_HEADER_BYTE_COUNT = 4
+
     // sentinel values from the spec for explicit off and auto
     EXPLICIT_OFF = 0
     EXPLICIT_AUTO = 63
@@ -227,7 +234,7 @@ func registerCountLog2(parametersByte byte) uint {
 }
 
 func writeMetadata(bytes []byte,hll *Hll) {
-    typeOrdinal := hll.hllType
+    typeOrdinal := int(hll.hllType)
 
     var explicitCutoffValue int
     if(hll.explicitOff) {
@@ -238,7 +245,23 @@ func writeMetadata(bytes []byte,hll *Hll) {
         explicitCutoffValue = int(math.Log2(float64(hll.explicitThreshold)) + 1)/*per spec*/
     }
 
-    bytes[0] = packVersionByte(SCHEMA_VERSION, typeOrdinal)
+    if hll.sparsePrecision != 0 {
+        bytes[0] = packVersionByte(SPARSE_PRECISION_SCHEMA_VERSION, typeOrdinal)
+        bytes[3] = byte(hll.sparsePrecision)
+    } else {
+        bytes[0] = packVersionByte(SCHEMA_VERSION, typeOrdinal)
+    }
     bytes[1] = packParametersByte(hll.regwidth, hll.log2m)
     bytes[2] =packCutoffByte(explicitCutoffValue, !hll.sparseOff)
+}
+
+/**
+ * @return the number of header bytes of the serialized HLL, which depends on
+ *         the schema version that #writeMetadata() picks.
+ */
+func (this *Hll) headerByteCount() uint {
+    if this.sparsePrecision != 0 {
+        return SPARSE_PRECISION_HEADER_BYTE_COUNT
+    }
+    return HEADER_BYTE_COUNT
 }
\ No newline at end of file
@@ -0,0 +1,440 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// key of the container entry holding the layout of a Series, see
+	// Series#WriteContainer()
+	SERIES_CONTAINER_KEY = "series"
+)
+
+/**
+ * A granularity of a {@link Series}.
+ */
+type SeriesLevel struct {
+	// the length of a bucket, a multiple of that of the previous level
+	Resolution time.Duration
+	// buckets that ended longer than this before the newest time are
+	// dropped. It is at least the resolution of the next level.
+	Retention time.Duration
+}
+
+/**
+ * Minute buckets for two hours, hour buckets for two days and day buckets
+ * (UTC) for 90 days.
+ */
+var DEFAULT_SERIES_LEVELS = []SeriesLevel{
+	{time.Minute, 2 * time.Hour},
+	{time.Hour, 48 * time.Hour},
+	{24 * time.Hour, 90 * 24 * time.Hour},
+}
+
+/**
+ * HLLs of consecutive time buckets at several granularities, e.g. minutes,
+ * hours and days, for counting the distinct values of arbitrary time ranges.
+ *
+ * Values go to the buckets of the finest level. Once a bucket of a coarser
+ * level is over, the buckets of the level below are rolled up into it with
+ * Hll#Union(), so that, like in a segment tree, #Count() unions the coarsest
+ * buckets that fit in the range and only descends to finer levels at its
+ * edges and for the current, incomplete buckets.
+ *
+ * NOTE:  a Series is not safe for concurrent use.
+ */
+type Series struct {
+	// an EMPTY HLL with the parameters of the buckets
+	params *Hll
+	levels []SeriesLevel
+	// the buckets of every level by their start in Unix nanoseconds
+	buckets []map[int64]*Hll
+	// the buckets of every level that start before this have been rolled up
+	// from the level below, math.MinInt64 if none
+	rolled []int64
+	// the newest time that was added or advanced to, math.MinInt64 if none
+	latest int64
+}
+
+/**
+ * @param prototype the HLL whose parameters the buckets use. It is not
+ *        modified and its contents are ignored.
+ * @param levels the granularities from finest to coarsest, see
+ *        DEFAULT_SERIES_LEVELS.
+ */
+func NewSeries(prototype *Hll, levels []SeriesLevel) (*Series, error) {
+	if len(levels) == 0 {
+		return nil, fmt.Errorf("a series needs at least one level")
+	}
+	for i, level := range levels {
+		if level.Resolution <= 0 || level.Retention < level.Resolution {
+			return nil, fmt.Errorf("level %d: resolution %v must be positive and at most the retention %v", i, level.Resolution, level.Retention)
+		}
+		if i > 0 {
+			previous := levels[i-1]
+			if level.Resolution%previous.Resolution != 0 {
+				return nil, fmt.Errorf("level %d: resolution %v is not a multiple of %v", i, level.Resolution, previous.Resolution)
+			}
+			// NOTE:  buckets must be kept until they are rolled up
+			if previous.Retention < level.Resolution {
+				return nil, fmt.Errorf("level %d: retention %v is shorter than the resolution %v of the next level", i-1, previous.Retention, level.Resolution)
+			}
+		}
+	}
+
+	this := &Series{}
+	this.params = prototype.emptyCopy()
+	this.levels = append([]SeriesLevel(nil), levels...)
+	this.buckets = make([]map[int64]*Hll, len(levels))
+	this.rolled = make([]int64, len(levels))
+	for i := range levels {
+		this.buckets[i] = make(map[int64]*Hll)
+		this.rolled[i] = math.MinInt64
+	}
+	this.latest = math.MinInt64
+	return this, nil
+}
+
+/**
+ * @return the largest multiple of <code>resolution</code> that is not
+ *         larger than <code>t</code>.
+ */
+func floorTo(t int64, resolution int64) int64 {
+	start := t - t%resolution
+	if start > t {
+		start -= resolution
+	}
+	return start
+}
+
+/**
+ * Adds <code>rawValue</code> as seen at time <code>t</code>. Values may
+ * arrive late, as long as their buckets are still retained.
+ *
+ * @param rawValue the value to be added, already hashed, see Hll#Add().
+ */
+func (this *Series) AddAt(rawValue uint64, t time.Time) {
+	this.update(t, func(bucket *Hll) {
+		bucket.Add(rawValue)
+	})
+}
+
+/**
+ * Unions <code>other</code> into the buckets of time <code>t</code>, e.g.
+ * to load sketches that were kept per bucket.
+ */
+func (this *Series) MergeAt(other *Hll, t time.Time) error {
+	if err := this.params.checkCompatible(other); err != nil {
+		return err
+	}
+	this.update(t, func(bucket *Hll) {
+		bucket.Union(other)
+	})
+	return nil
+}
+
+/**
+ * Applies <code>fn</code> to the finest retained bucket of time
+ * <code>t</code>, and to the coarser ones that were already rolled up.
+ */
+func (this *Series) update(t time.Time, fn func(bucket *Hll)) {
+	this.AdvanceTo(t)
+	at := t.UnixNano()
+	for i, level := range this.levels {
+		// NOTE:  buckets that are still to be rolled up get the value then
+		if i > 0 && at >= this.rolled[i] {
+			break
+		}
+		start := floorTo(at, int64(level.Resolution))
+		if this.expired(i, start) {
+			continue
+		}
+		fn(this.bucket(i, start))
+	}
+}
+
+/**
+ * @return the bucket of the level that starts at <code>start</code>,
+ *         created if necessary.
+ */
+func (this *Series) bucket(level int, start int64) *Hll {
+	bucket, ok := this.buckets[level][start]
+	if !ok {
+		bucket = this.params.emptyCopy()
+		this.buckets[level][start] = bucket
+	}
+	return bucket
+}
+
+/**
+ * @return whether the bucket of the level that starts at <code>start</code>
+ *         is past its retention.
+ */
+func (this *Series) expired(level int, start int64) bool {
+	return this.latest != math.MinInt64 && start+int64(this.levels[level].Resolution) <= this.latest-int64(this.levels[level].Retention)
+}
+
+/**
+ * Moves the newest time forward to <code>t</code>, as if a value had been
+ * added then: the buckets that are over are rolled up and those past their
+ * retention are dropped. Times before the newest one are ignored.
+ */
+func (this *Series) AdvanceTo(t time.Time) {
+	at := t.UnixNano()
+	if at <= this.latest {
+		return
+	}
+	this.latest = at
+
+	for i := 1; i < len(this.levels); i++ {
+		resolution := int64(this.levels[i].Resolution)
+		complete := floorTo(at, resolution)
+		if complete <= this.rolled[i] {
+			continue
+		}
+		for start, finer := range this.buckets[i-1] {
+			if start >= this.rolled[i] && start < complete {
+				this.bucket(i, floorTo(start, resolution)).Union(finer)
+			}
+		}
+		this.rolled[i] = complete
+	}
+
+	for i, buckets := range this.buckets {
+		for start := range buckets {
+			if this.expired(i, start) {
+				delete(buckets, start)
+			}
+		}
+	}
+}
+
+/**
+ * @return the union of the values added from <code>from</code> (inclusive)
+ *         to <code>to</code> (exclusive), as far as they are retained. The
+ *         range is widened to the buckets of the finest level. This will
+ *         never be <code>nil</code>.
+ */
+func (this *Series) Range(from time.Time, to time.Time) *Hll {
+	result := this.params.emptyCopy()
+	if this.latest == math.MinInt64 {
+		return result
+	}
+
+	// NOTE:  no bucket lies outside of the longest retention or after the
+	//        newest time, so the range is clamped to spare the descent
+	//        through them
+	oldest := this.latest
+	for _, level := range this.levels {
+		oldest = min(oldest, this.latest-int64(level.Retention))
+	}
+	this.collect(result, len(this.levels)-1, max(saturatedUnixNano(from), oldest), min(saturatedUnixNano(to), this.latest+1))
+	return result
+}
+
+/**
+ * @return t in Unix nanoseconds, clamped to the range of an int64 (see
+ *         time.Time#UnixNano()), so that e.g. the zero time can be used as
+ *         an open end.
+ */
+func saturatedUnixNano(t time.Time) int64 {
+	if t.Before(time.Unix(0, math.MinInt64)) {
+		return math.MinInt64
+	}
+	if t.After(time.Unix(0, math.MaxInt64)) {
+		return math.MaxInt64
+	}
+	return t.UnixNano()
+}
+
+/**
+ * Unions the buckets of the level and, where they are incomplete or
+ * missing, those of finer levels that cover [from, to) into
+ * <code>result</code>.
+ */
+func (this *Series) collect(result *Hll, level int, from int64, to int64) {
+	if from >= to {
+		return
+	}
+	resolution := int64(this.levels[level].Resolution)
+	if level == 0 {
+		for start := floorTo(from, resolution); start < to; start += resolution {
+			if bucket, ok := this.buckets[0][start]; ok {
+				result.Union(bucket)
+			}
+		}
+		return
+	}
+
+	// the buckets of the level that lie within the range
+	first := floorTo(from+resolution-1, resolution)
+	last := floorTo(to, resolution)
+	if first >= last {
+		this.collect(result, level-1, from, to)
+		return
+	}
+	this.collect(result, level-1, from, first)
+	for start := first; start < last; start += resolution {
+		if start < this.rolled[level] && !this.expired(level, start) {
+			if bucket, ok := this.buckets[level][start]; ok {
+				result.Union(bucket)
+			}
+		} else {
+			this.collect(result, level-1, start, start+resolution)
+		}
+	}
+	this.collect(result, level-1, last, to)
+}
+
+/**
+ * @return the estimated number of distinct values added from
+ *         <code>from</code> to <code>to</code>, see #Range().
+ */
+func (this *Series) Count(from time.Time, to time.Time) uint {
+	return this.Range(from, to).Cardinality()
+}
+
+/**
+ * @return the number of buckets held over all levels.
+ */
+func (this *Series) Buckets() int {
+	buckets := 0
+	for _, level := range this.buckets {
+		buckets += len(level)
+	}
+	return buckets
+}
+
+// ------------------------------------------------------------------------
+// Serialization
+
+/**
+ * Writes the series as a container (see ContainerWriter). The first entry
+ * is keyed
+ *
+ * <pre>
+ * "series latest=" unix-nanos " levels=" resolution ":" retention ("," resolution ":" retention)*
+ * </pre>
+ *
+ * with the durations in the format of time.Duration#String(), and holds an
+ * EMPTY HLL with the parameters of the buckets. Every bucket follows keyed
+ * <code>level "/" start-unix-nanos</code>.
+ */
+func (this *Series) WriteContainer(w io.Writer) error {
+	writer, err := NewContainerWriter(w)
+	if err != nil {
+		return err
+	}
+
+	levels := make([]string, len(this.levels))
+	for i, level := range this.levels {
+		levels[i] = level.Resolution.String() + ":" + level.Retention.String()
+	}
+	key := fmt.Sprintf("%s latest=%d levels=%s", SERIES_CONTAINER_KEY, this.latest, strings.Join(levels, ","))
+	if err := writer.Write(key, this.params); err != nil {
+		return err
+	}
+
+	for i, buckets := range this.buckets {
+		for start, bucket := range buckets {
+			if err := writer.Write(strconv.Itoa(i)+"/"+strconv.FormatInt(start, 10), bucket); err != nil {
+				return err
+			}
+		}
+	}
+	return writer.Close()
+}
+
+/**
+ * Reads a series written by Series#WriteContainer().
+ */
+func ReadSeries(r io.Reader) (*Series, error) {
+	reader, err := NewContainerReader(r)
+	if err != nil {
+		return nil, err
+	}
+	key, params, err := reader.Next()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	this, err := newSeriesFromKey(key, params)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		key, bucket, err := reader.Next()
+		if err == io.EOF {
+			return this, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		levelText, startText, ok := strings.Cut(key, "/")
+		level, levelErr := strconv.Atoi(levelText)
+		start, startErr := strconv.ParseInt(startText, 10, 64)
+		if !ok || levelErr != nil || startErr != nil || level < 0 || level >= len(this.levels) {
+			return nil, ErrCorruptContainer
+		}
+		if err := this.params.checkCompatible(bucket); err != nil {
+			return nil, err
+		}
+		this.buckets[level][start] = bucket
+	}
+}
+
+/**
+ * Creates an empty series from the layout entry of a container.
+ */
+func newSeriesFromKey(key string, params *Hll) (*Series, error) {
+	var latest int64
+	var levelsText string
+	if _, err := fmt.Sscanf(key, SERIES_CONTAINER_KEY+" latest=%d levels=%s", &latest, &levelsText); err != nil {
+		return nil, ErrCorruptContainer
+	}
+	var levels []SeriesLevel
+	for _, levelText := range strings.Split(levelsText, ",") {
+		resolutionText, retentionText, ok := strings.Cut(levelText, ":")
+		resolution, resolutionErr := time.ParseDuration(resolutionText)
+		retention, retentionErr := time.ParseDuration(retentionText)
+		if !ok || resolutionErr != nil || retentionErr != nil {
+			return nil, ErrCorruptContainer
+		}
+		levels = append(levels, SeriesLevel{resolution, retention})
+	}
+
+	this, err := NewSeries(params, levels)
+	if err != nil {
+		return nil, err
+	}
+	// NOTE:  every level is rolled up to its last complete bucket
+	this.latest = latest
+	if latest != math.MinInt64 {
+		for i := 1; i < len(levels); i++ {
+			this.rolled[i] = floorTo(latest, int64(levels[i].Resolution))
+		}
+	}
+	return this, nil
+}
@@ -0,0 +1,111 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"bytes"
+	"maps"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSeries(t *testing.T) {
+	prototype, _ := NewHll(11, 5)
+	series, err := NewSeries(prototype, DEFAULT_SERIES_LEVELS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type event struct {
+		rawValue uint64
+		time     time.Time
+	}
+	r := rand.New(rand.NewSource(1))
+	start := time.Date(2024, 3, 1, 5, 17, 0, 0, time.UTC)
+	var events []event
+	for at := start; at.Before(start.Add(75 * time.Hour)); at = at.Add(time.Duration(r.Intn(4000)) * time.Millisecond) {
+		// NOTE:  a few values arrive late
+		e := event{r.Uint64(), at.Add(-time.Duration(r.Intn(3)) * time.Minute)}
+		events = append(events, e)
+		series.AddAt(e.rawValue, e.time)
+	}
+	end := events[len(events)-1].time
+
+	ranges := [][2]time.Time{
+		// within the retention of the minutes
+		{end.Add(-90*time.Minute + 13*time.Second), end},
+		// hours and the minutes of the current hour
+		{end.Truncate(time.Hour).Add(-20 * time.Hour), end.Add(-7 * time.Minute)},
+		// days, hours and minutes
+		{end.Truncate(24 * time.Hour).Add(-24 * time.Hour), end.Add(time.Hour)},
+		{time.Time{}, end.Add(time.Hour)},
+	}
+	for _, fromTo := range ranges {
+		expected := prototype.emptyCopy()
+		for _, e := range events {
+			// NOTE:  the range is widened to whole minutes
+			if !e.time.Before(fromTo[0].Truncate(time.Minute)) && e.time.Before(fromTo[1].Add(time.Minute-1).Truncate(time.Minute)) && fromTo[1].After(fromTo[0]) {
+				expected.Add(e.rawValue)
+			}
+		}
+		if registers, expectedRegisters := registersOf(series.Range(fromTo[0], fromTo[1])), registersOf(expected); !maps.Equal(registers, expectedRegisters) {
+			t.Errorf("range %v - %v: %d registers set, expected %d", fromTo[0], fromTo[1], len(registers), len(expectedRegisters))
+		}
+	}
+
+	// two hours of minutes, two days of hours and four days
+	if buckets := series.Buckets(); buckets > 121+49+5 {
+		t.Errorf("%d buckets retained", buckets)
+	}
+
+	// a late value only reaches the hours
+	late := end.Add(-3 * time.Hour)
+	series.AddAt(1<<20|1, late)
+	if series.Count(late.Truncate(time.Hour), late.Truncate(time.Hour).Add(time.Hour)) == 0 {
+		t.Errorf("late value was dropped")
+	}
+
+	var buffer bytes.Buffer
+	if err := series.WriteContainer(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ReadSeries(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fromTo := range ranges {
+		if !bytes.Equal(restored.Range(fromTo[0], fromTo[1]).ToBytes(), series.Range(fromTo[0], fromTo[1]).ToBytes()) {
+			t.Errorf("range %v - %v differs after a round trip", fromTo[0], fromTo[1])
+		}
+	}
+}
+
+func TestSeriesLevels(t *testing.T) {
+	prototype, _ := NewHll(11, 5)
+	for _, levels := range [][]SeriesLevel{
+		nil,
+		{{0, time.Hour}},
+		{{time.Hour, time.Minute}},
+		{{time.Minute, time.Hour}, {90 * time.Second, time.Hour}},
+		{{time.Minute, time.Minute}, {time.Hour, time.Hour}},
+	} {
+		if _, err := NewSeries(prototype, levels); err == nil {
+			t.Errorf("levels %v accepted", levels)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"fmt"
+	"iter"
+	"sync"
+	"time"
+)
+
+/**
+ * Ingests values from many goroutines without contention. Every shard owns a
+ * private HLL and is meant to be fed by a single worker. #Flush(), which may
+ * also run on a timer, folds the shards into a shared result by #Union().
+ * As the union is idempotent the shards keep their storage across flushes,
+ * so they are only promoted once.
+ *
+ * #Cardinality() and #Snapshot() only see what has been flushed, so they are
+ * consistent with a single point in time rather than racing with the
+ * workers.
+ */
+type ShardedHll struct {
+	shards []*Shard
+
+	// guards result
+	lock   sync.Mutex
+	result *Hll
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+/**
+ * A private HLL of a ShardedHll. It is safe to share a shard between
+ * goroutines. Until it is FULL every add takes a lock, which is otherwise
+ * only contended by ShardedHll#Flush(). Once FULL adds do not lock at all,
+ * see ConcurrentHll.
+ */
+type Shard struct {
+	hll *ConcurrentHll
+}
+
+/**
+ * @param prototype the HLL whose parameters the shards and the result use.
+ *        Its contents seed the result. It is not modified.
+ * @param shards the number of shards, typically the number of workers or
+ *        runtime.GOMAXPROCS(0).
+ * @param flushInterval the interval at which the shards are flushed in the
+ *        background, 0 to only flush on #Flush().
+ */
+func NewShardedHll(prototype *Hll, shards int, flushInterval time.Duration) (*ShardedHll, error) {
+	if shards < 1 {
+		return nil, fmt.Errorf("shards must be at least 1 (was %d)", shards)
+	}
+
+	this := &ShardedHll{shards: make([]*Shard, shards), result: prototype.Clone()}
+	this.result.checkpointed, this.result.dirty = false, nil
+	for i := range this.shards {
+		this.shards[i] = &Shard{hll: NewConcurrentHllFrom(prototype.emptyCopy())}
+	}
+	if flushInterval > 0 {
+		this.stop = make(chan struct{})
+		this.done = make(chan struct{})
+		go this.run(flushInterval)
+	}
+	return this, nil
+}
+
+func (this *ShardedHll) run(flushInterval time.Duration) {
+	defer close(this.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			this.Flush()
+		case <-this.stop:
+			return
+		}
+	}
+}
+
+/**
+ * @return the number of shards.
+ */
+func (this *ShardedHll) Shards() int {
+	return len(this.shards)
+}
+
+/**
+ * @param i the index of the shard, wrapped around the number of shards so
+ *        that worker ids may be used directly.
+ */
+func (this *ShardedHll) Shard(i int) *Shard {
+	return this.shards[uint(i)%uint(len(this.shards))]
+}
+
+/**
+ * Adds <code>rawValue</code> to the private HLL of the shard.
+ */
+func (this *Shard) Add(rawValue uint64) {
+	this.hll.Add(rawValue)
+}
+
+/**
+ * Adds all values of <code>seq</code> to the shard.
+ */
+func (this *Shard) AddSeq(seq iter.Seq[uint64]) {
+	for rawValue := range seq {
+		this.Add(rawValue)
+	}
+}
+
+/**
+ * Adds values received from <code>ch</code> to the shard until it is closed.
+ */
+func (this *Shard) AddChan(ch <-chan uint64) {
+	for rawValue := range ch {
+		this.Add(rawValue)
+	}
+}
+
+/**
+ * Consumes <code>ch</code> with one worker per shard, each adding to its own
+ * shard, until it is closed. Blocks until all workers are done.
+ */
+func (this *ShardedHll) Consume(ch <-chan uint64) {
+	var wg sync.WaitGroup
+	for _, shard := range this.shards {
+		wg.Add(1)
+		go func(shard *Shard) {
+			defer wg.Done()
+			shard.AddChan(ch)
+		}(shard)
+	}
+	wg.Wait()
+}
+
+/**
+ * Consumes every sequence on a worker of its own, the i-th one adding to
+ * #Shard(i). Blocks until all sequences are exhausted.
+ */
+func (this *ShardedHll) ConsumeSeqs(seqs ...iter.Seq[uint64]) {
+	var wg sync.WaitGroup
+	for i, seq := range seqs {
+		wg.Add(1)
+		go func(shard *Shard) {
+			defer wg.Done()
+			shard.AddSeq(seq)
+		}(this.Shard(i))
+	}
+	wg.Wait()
+}
+
+/**
+ * Folds all shards into the shared result. Values added concurrently end up
+ * either in this or in the next flush.
+ */
+func (this *ShardedHll) Flush() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, shard := range this.shards {
+		// NOTE:  the shards are not emptied, folding the same registers
+		//        again on the next flush does not change the result
+		shard.hll.unionInto(this.result)
+	}
+}
+
+/**
+ * @return the cardinality of the shared result as of the last #Flush().
+ */
+func (this *ShardedHll) Cardinality() uint {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.result.Cardinality()
+}
+
+/**
+ * @return a copy of the shared result as of the last #Flush().
+ */
+func (this *ShardedHll) Snapshot() *Hll {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.result.Clone()
+}
+
+/**
+ * Stops the background flushes, if any, and flushes one last time.
+ */
+func (this *ShardedHll) Close() {
+	if this.stop != nil {
+		close(this.stop)
+		<-this.done
+		this.stop = nil
+	}
+	this.Flush()
+}
@@ -0,0 +1,84 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"bytes"
+	"iter"
+	"math/rand"
+	"testing"
+)
+
+func TestShardedHll(t *testing.T) {
+	prototype, _ := NewHll(11, 5)
+	sharded, err := NewShardedHll(prototype, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := NewHll(11, 5)
+
+	seqs := make([]iter.Seq[uint64], 4)
+	for i := range seqs {
+		values := make([]uint64, 5000)
+		for j := range values {
+			values[j] = rand.Uint64()
+			expected.Add(values[j])
+		}
+		seqs[i] = func(yield func(uint64) bool) {
+			for _, v := range values {
+				if !yield(v) {
+					return
+				}
+			}
+		}
+	}
+	sharded.ConsumeSeqs(seqs...)
+	if sharded.Cardinality() != 0 {
+		t.Errorf("unflushed values are visible")
+	}
+
+	ch := make(chan uint64)
+	go func() {
+		for j := 0; j < 1000; j++ {
+			v := rand.Uint64()
+			expected.Add(v)
+			ch <- v
+		}
+		close(ch)
+	}()
+	flushed := make(chan struct{})
+	go func() {
+		// flush while the shards are being added to
+		for j := 0; j < 10; j++ {
+			sharded.Flush()
+		}
+		close(flushed)
+	}()
+	sharded.Consume(ch)
+	<-flushed
+	sharded.Close()
+
+	for i := 0; i < sharded.Shards(); i++ {
+		if !sharded.Shard(i).hll.full.Load() {
+			t.Errorf("shard %d was emptied by a flush", i)
+		}
+	}
+
+	if !bytes.Equal(sharded.Snapshot().ToBytes(), expected.ToBytes()) {
+		t.Errorf("sharded result differs from sequential adds")
+	}
+}
@@ -0,0 +1,219 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+/**
+ * A HyperLogLog over a sliding window of time, after "Sliding HyperLogLog:
+ * Estimating cardinality in a data stream over a sliding window" by
+ * Chabchoub and Hébrail.
+ *
+ * Instead of its maximum value, every register keeps the list of future
+ * possible maxima (LFPM): the values that were set within the maximum window
+ * and are larger than every value set after them. The maximum of a register
+ * over any window up to the maximum one is then the first value of its list
+ * that was set within that window. As values are geometrically distributed,
+ * the lists hold about ln(n) entries for n values added to a register.
+ *
+ * The registers follow the indexing of a FULL HLL with the same parameters,
+ * so that #Window() can collapse them into a regular HLL.
+ *
+ * NOTE:  a SlidingHll is not safe for concurrent use.
+ */
+type SlidingHll struct {
+	// an EMPTY HLL with the parameters of the windows
+	params *Hll
+	// the largest window that can be queried
+	maxWindow time.Duration
+	// the LFPM of every register, oldest entry first
+	registers [][]slidingEntry
+	// the newest time that was added or advanced to, the end of all windows
+	latest int64
+	// the value of latest when the expired entries were last dropped, see
+	// #prune()
+	prunedAt int64
+}
+
+/**
+ * An entry of a list of future possible maxima.
+ */
+type slidingEntry struct {
+	// Unix time in nanoseconds at which the value was set
+	time  int64
+	value byte
+}
+
+/**
+ * @param prototype the HLL whose parameters the windows use. It is not
+ *        modified and its contents are ignored.
+ * @param maxWindow the largest window that can be queried. Entries that
+ *        fall out of it are dropped by #AdvanceTo() and #Window().
+ */
+func NewSlidingHll(prototype *Hll, maxWindow time.Duration) (*SlidingHll, error) {
+	if maxWindow <= 0 {
+		return nil, fmt.Errorf("maxWindow must be positive (was %v)", maxWindow)
+	}
+
+	this := &SlidingHll{}
+	this.params = prototype.emptyCopy()
+	// NOTE:  the windows are collapsed from registers at log2m
+	this.params.setSparsePrecision(0)
+	this.maxWindow = maxWindow
+	this.registers = make([][]slidingEntry, this.params.m)
+	return this, nil
+}
+
+/**
+ * Adds <code>rawValue</code> as seen at time <code>t</code>. Times may
+ * arrive out of order; values older than the maximum window before the
+ * newest time are ignored.
+ *
+ * @param rawValue the value to be added, already hashed, see Hll#Add().
+ */
+func (this *SlidingHll) AddAt(rawValue uint64, t time.Time) {
+	this.AdvanceTo(t)
+	now := t.UnixNano()
+	if now < this.horizon(this.maxWindow) {
+		return
+	}
+	registerIndex, registerValue := this.params.registerFor(rawValue)
+	if registerValue == 0 {
+		return
+	}
+
+	lfpm := this.registers[registerIndex]
+	// the entries set at or after t, whose values are strictly decreasing
+	later := len(lfpm)
+	for later > 0 && lfpm[later-1].time >= now {
+		later--
+	}
+	if later < len(lfpm) && lfpm[later].value >= registerValue {
+		// a later value is at least as large, so this one never is a maximum
+		return
+	}
+
+	// NOTE:  the later entries that are not larger are dominated by the new
+	//        one, as are the earlier entries that are not larger
+	kept := make([]slidingEntry, 0, len(lfpm)+1)
+	for _, entry := range lfpm[:later] {
+		if entry.value > registerValue && entry.time >= this.horizon(this.maxWindow) {
+			kept = append(kept, entry)
+		}
+	}
+	kept = append(kept, slidingEntry{now, registerValue})
+	for _, entry := range lfpm[later:] {
+		if entry.value < registerValue {
+			kept = append(kept, entry)
+		}
+	}
+	this.registers[registerIndex] = kept
+}
+
+/**
+ * Moves the end of the windows forward to <code>t</code>, as if a value
+ * had been added then. Times before the current end are ignored.
+ *
+ * Once the end moved by the maximum window since the last time, the entries
+ * that fell out of it are dropped, so that the registers hold no more than
+ * about two maximum windows of entries.
+ */
+func (this *SlidingHll) AdvanceTo(t time.Time) {
+	this.latest = max(this.latest, t.UnixNano())
+	if this.latest-this.prunedAt >= int64(this.maxWindow) {
+		this.prune()
+	}
+}
+
+/**
+ * Drops the entries that are older than the maximum window before the end.
+ */
+func (this *SlidingHll) prune() {
+	horizon := this.horizon(this.maxWindow)
+	for registerIndex, lfpm := range this.registers {
+		// NOTE:  the entries are oldest first
+		expired := 0
+		for expired < len(lfpm) && lfpm[expired].time < horizon {
+			expired++
+		}
+		if expired == len(lfpm) {
+			this.registers[registerIndex] = nil
+		} else if expired > 0 {
+			this.registers[registerIndex] = slices.Clone(lfpm[expired:])
+		}
+	}
+	this.prunedAt = this.latest
+}
+
+/**
+ * @return the earliest time within the window before the end.
+ */
+func (this *SlidingHll) horizon(window time.Duration) int64 {
+	return this.latest - int64(window)
+}
+
+/**
+ * Collapses the registers into a regular HLL of the values added within
+ * <code>window</code> before the newest time, which can then be serialized
+ * or unioned. The result has the parameters of the prototype and is SPARSE
+ * if its registers fit under the SPARSE threshold, FULL otherwise.
+ *
+ * @param window the length of the window. Longer windows than the maximum
+ *        one are cut to it.
+ * @return the HLL of the window. This will never be <code>nil</code>.
+ */
+func (this *SlidingHll) Window(window time.Duration) *Hll {
+	this.prune()
+	horizon := this.horizon(min(window, this.maxWindow))
+	result := this.params.emptyCopy()
+
+	registers := make(map[uint32]byte)
+	for registerIndex, lfpm := range this.registers {
+		// NOTE:  the first entry within the window is its maximum
+		for _, entry := range lfpm {
+			if entry.time >= horizon {
+				registers[uint32(registerIndex)] = entry.value
+				break
+			}
+		}
+	}
+	result.setRegisters(registers)
+	return result
+}
+
+/**
+ * @return the estimated number of distinct values added within
+ *         <code>window</code> before the newest time, see #Window().
+ */
+func (this *SlidingHll) CardinalityOver(window time.Duration) uint {
+	return this.Window(window).Cardinality()
+}
+
+/**
+ * @return the number of LFPM entries held, a measure of the memory used.
+ */
+func (this *SlidingHll) Entries() int {
+	entries := 0
+	for _, lfpm := range this.registers {
+		entries += len(lfpm)
+	}
+	return entries
+}
@@ -0,0 +1,86 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"maps"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSlidingHll(t *testing.T) {
+	prototype, _ := NewHll5(11, 5, 0, true, SPARSE)
+	sliding, err := NewSlidingHll(prototype, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type event struct {
+		rawValue uint64
+		time     time.Time
+	}
+	r := rand.New(rand.NewSource(1))
+	start := time.Unix(1700000000, 0)
+	events := make([]event, 50000)
+	var end time.Time
+	for i := range events {
+		// NOTE:  times arrive slightly out of order
+		offset := time.Duration(i)*90*time.Millisecond - time.Duration(r.Intn(1000))*time.Millisecond
+		events[i] = event{r.Uint64(), start.Add(offset)}
+		sliding.AddAt(events[i].rawValue, events[i].time)
+		if events[i].time.After(end) {
+			end = events[i].time
+		}
+	}
+
+	for _, window := range []time.Duration{time.Second, time.Minute, 15 * time.Minute, time.Hour, 2 * time.Hour} {
+		expected := prototype.emptyCopy()
+		for _, e := range events {
+			if !e.time.Before(end.Add(-min(window, time.Hour))) {
+				expected.Add(e.rawValue)
+			}
+		}
+		if registers, expectedRegisters := registersOf(sliding.Window(window)), registersOf(expected); !maps.Equal(registers, expectedRegisters) {
+			t.Errorf("window %v: %d registers set, expected %d", window, len(registers), len(expectedRegisters))
+		}
+		if sliding.CardinalityOver(window) != expected.Cardinality() {
+			t.Errorf("window %v: cardinality %d, expected %d", window, sliding.CardinalityOver(window), expected.Cardinality())
+		}
+	}
+
+	// NOTE:  about ln(n) entries per register rather than n
+	if entries := sliding.Entries(); entries > 20*int(prototype.m) {
+		t.Errorf("%d entries for %d registers", entries, prototype.m)
+	}
+
+	sliding.AdvanceTo(end.Add(2 * time.Hour))
+	if entries := sliding.Entries(); entries != 0 {
+		t.Errorf("%d entries outlive the window", entries)
+	}
+	if sliding.CardinalityOver(time.Hour) != 0 {
+		t.Errorf("values outlive the window")
+	}
+}
+
+func registersOf(h *Hll) map[uint32]byte {
+	registers := make(map[uint32]byte)
+	h.forEachRegister(func(registerIndex uint32, registerValue byte) {
+		registers[registerIndex] = registerValue
+	})
+	return registers
+}
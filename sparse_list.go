@@ -0,0 +1,283 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+const (
+	// the insertion buffer of a SparseList is merged into the sorted list once
+	// it holds this many entries or a quarter of the sorted list, whichever
+	// is more, so that merging costs O(1) per insertion amortized
+	MINIMUM_SPARSE_BUFFER_SIZE = 64
+	// every this many registers of the sorted list are indexed, so that
+	// looking up a register decodes at most this many
+	SPARSE_LIST_BLOCK_SIZE = 64
+)
+
+/**
+ * The storage of a SPARSE HLL: a map of register indices to non-zero register
+ * values in the style of HLL++.
+ *
+ * The registers are kept sorted by index in a byte slice, each as the
+ * unsigned varint of (index - previous index) << valueWidth | value, which
+ * takes one to three bytes per register for typical parameters. Insertions
+ * go to a small unsorted buffer, which is merged into the sorted list when it
+ * fills up or when the registers are read.
+ *
+ * NOTE:  reading the registers through #Size() or an iterator merges the
+ *        buffer and #get() indexes it, so these are not safe for concurrent
+ *        use either.
+ */
+type SparseList struct {
+	// the sorted, delta-varint encoded registers
+	sorted []byte
+	// number of registers in sorted
+	size uint
+	// pending insertions, index << 8 | value, unsorted and possibly with
+	// repeated indices
+	buffer []uint64
+	// the width of the register values in bits
+	valueWidth uint
+	// counts[v] is the number of registers in sorted with value v
+	counts []uint32
+	// the index and the offset in sorted of every SPARSE_LIST_BLOCK_SIZE-th
+	// register, starting with the first
+	blockIndices []uint32
+	blockOffsets []int
+	// the largest buffered value per index, built by #get() and kept up to
+	// date until the next flush, nil otherwise
+	bufferValues map[uint32]byte
+}
+
+/**
+ * @param valueWidth the width of the register values in bits, see
+ *        NewHll().
+ */
+func NewSparseList(valueWidth uint) *SparseList {
+	this := &SparseList{}
+	this.valueWidth = valueWidth
+	this.counts = make([]uint32, 1<<valueWidth)
+	return this
+}
+
+func (this *SparseList) Clone() *SparseList {
+	c := *this
+	c.sorted = slices.Clone(this.sorted)
+	c.buffer = slices.Clone(this.buffer)
+	c.counts = slices.Clone(this.counts)
+	c.blockIndices = slices.Clone(this.blockIndices)
+	c.blockOffsets = slices.Clone(this.blockOffsets)
+	c.bufferValues = nil
+	return &c
+}
+
+/**
+ * @return the value of the register with the given index, 0 if it is not
+ *         set.
+ */
+func (this *SparseList) get(registerIndex uint32) byte {
+	// NOTE:  the buffered values are only indexed while registers are looked
+	//        up, so that plain insertions do not pay for it
+	if this.bufferValues == nil && len(this.buffer) > 0 {
+		this.bufferValues = make(map[uint32]byte, len(this.buffer))
+		for _, entry := range this.buffer {
+			this.bufferValues[uint32(entry>>8)] = max(this.bufferValues[uint32(entry>>8)], byte(entry))
+		}
+	}
+	value := this.bufferValues[registerIndex]
+
+	// the last block starting at or before the register
+	block, found := slices.BinarySearch(this.blockIndices, registerIndex)
+	if !found {
+		block--
+	}
+	if block < 0 {
+		return value
+	}
+
+	// NOTE:  the list is sorted, so the scan stops at the first larger index,
+	//        at the latest at the start of the next block
+	offset := this.blockOffsets[block]
+	entry, _ := binary.Uvarint(this.sorted[offset:])
+	index := this.blockIndices[block] - uint32(entry>>this.valueWidth)
+	for offset < len(this.sorted) {
+		entry, length := binary.Uvarint(this.sorted[offset:])
+		offset += length
+		index += uint32(entry >> this.valueWidth)
+		if index == registerIndex {
+			return max(value, byte(entry&((1<<this.valueWidth)-1)))
+		}
+		if index > registerIndex {
+			break
+		}
+	}
+	return value
+}
+
+/**
+ * Sets the register with the given index to <code>value</code> if that is
+ * greater than its current value.
+ *
+ * @param value the new value. This cannot be zero.
+ */
+func (this *SparseList) setMax(registerIndex uint32, value byte) {
+	this.buffer = append(this.buffer, uint64(registerIndex)<<8|uint64(value))
+	if this.bufferValues != nil {
+		this.bufferValues[registerIndex] = max(this.bufferValues[registerIndex], value)
+	}
+	if uint(len(this.buffer)) >= max(MINIMUM_SPARSE_BUFFER_SIZE, this.size/4) {
+		this.flush()
+	}
+}
+
+/**
+ * Merges the insertion buffer into the sorted list.
+ */
+func (this *SparseList) flush() {
+	if len(this.buffer) == 0 {
+		return
+	}
+	// NOTE:  sorting the entries also orders the values of a register
+	//        ascending, so the last one of a run is the largest
+	slices.Sort(this.buffer)
+
+	valueMask := uint64(1<<this.valueWidth) - 1
+	sorted := make([]byte, 0, len(this.sorted)+3*len(this.buffer))
+	previousIndex := uint64(0)
+	this.blockIndices, this.blockOffsets = this.blockIndices[:0], this.blockOffsets[:0]
+	written := 0
+	write := func(index uint64, value uint64) {
+		if written%SPARSE_LIST_BLOCK_SIZE == 0 {
+			this.blockIndices = append(this.blockIndices, uint32(index))
+			this.blockOffsets = append(this.blockOffsets, len(sorted))
+		}
+		written++
+		sorted = binary.AppendUvarint(sorted, (index-previousIndex)<<this.valueWidth|value)
+		previousIndex = index
+	}
+
+	buffer := this.buffer
+	index := uint64(0)
+	for offset := 0; offset < len(this.sorted); {
+		entry, length := binary.Uvarint(this.sorted[offset:])
+		offset += length
+		index += entry >> this.valueWidth
+		value := entry & valueMask
+
+		// buffered registers that come before this one are new
+		for len(buffer) > 0 && buffer[0]>>8 < index {
+			newIndex, newValue := buffer[0]>>8, buffer[0]&0xff
+			for len(buffer) > 0 && buffer[0]>>8 == newIndex {
+				newValue, buffer = buffer[0]&0xff, buffer[1:]
+			}
+			write(newIndex, newValue)
+			this.size++
+			this.counts[newValue]++
+		}
+		// buffered values of this register
+		for len(buffer) > 0 && buffer[0]>>8 == index {
+			if newValue := buffer[0] & 0xff; newValue > value {
+				this.counts[value]--
+				this.counts[newValue]++
+				value = newValue
+			}
+			buffer = buffer[1:]
+		}
+		write(index, value)
+	}
+	for len(buffer) > 0 {
+		newIndex, newValue := buffer[0]>>8, buffer[0]&0xff
+		for len(buffer) > 0 && buffer[0]>>8 == newIndex {
+			newValue, buffer = buffer[0]&0xff, buffer[1:]
+		}
+		write(newIndex, newValue)
+		this.size++
+		this.counts[newValue]++
+	}
+
+	this.sorted = sorted
+	this.buffer = this.buffer[:0]
+	this.bufferValues = nil
+}
+
+/**
+ * @return the number of registers that are set.
+ */
+func (this *SparseList) Size() uint {
+	this.flush()
+	return this.size
+}
+
+/**
+ * @return true if more than <code>threshold</code> registers are set. The
+ *         buffer is only merged if it could make the difference.
+ */
+func (this *SparseList) sizeExceeds(threshold uint) bool {
+	if this.size+uint(len(this.buffer)) <= threshold {
+		return false
+	}
+	return this.Size() > threshold
+}
+
+/**
+ * @return the number of registers per non-zero value, see
+ *         Hll#RegisterHistogram(). Index zero is unused.
+ */
+func (this *SparseList) histogram() []uint32 {
+	this.flush()
+	return this.counts
+}
+
+/** Returns the number of bytes held by the backing arrays of this list. */
+func (this *SparseList) sizeInBytes() uint {
+	return uint(cap(this.sorted)) + 8*uint(cap(this.buffer)) + 4*uint(len(this.counts)) + 4*uint(cap(this.blockIndices)) + 8*uint(cap(this.blockOffsets))
+}
+
+/**
+ * Iterates over the registers that are set, in ascending index order.
+ */
+type SparseListIterator struct {
+	sorted     []byte
+	valueWidth uint
+	offset     int
+	index      uint32
+}
+
+func NewSparseListIterator(sparseList *SparseList) *SparseListIterator {
+	sparseList.flush()
+	this := &SparseListIterator{}
+	this.sorted = sparseList.sorted
+	this.valueWidth = sparseList.valueWidth
+	return this
+}
+
+func (this *SparseListIterator) HasNext() bool {
+	return this.offset < len(this.sorted)
+}
+
+/**
+ * @return the index and the value of the next register.
+ */
+func (this *SparseListIterator) Next() (uint32, byte) {
+	entry, length := binary.Uvarint(this.sorted[this.offset:])
+	this.offset += length
+	this.index += uint32(entry >> this.valueWidth)
+	return this.index, byte(entry & ((1 << this.valueWidth) - 1))
+}
@@ -0,0 +1,87 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSparseList(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	list := NewSparseList(5)
+	expected := make(map[uint32]byte)
+	for i := 0; i < 5000; i++ {
+		registerIndex, registerValue := uint32(r.Intn(1<<14)), byte(1+r.Intn(31))
+		list.setMax(registerIndex, registerValue)
+		if registerValue > expected[registerIndex] {
+			expected[registerIndex] = registerValue
+		}
+
+		if i%97 == 0 {
+			if value := list.get(registerIndex); value != expected[registerIndex] {
+				t.Fatalf("get(%d) = %d, expected %d", registerIndex, value, expected[registerIndex])
+			}
+		}
+	}
+
+	// every register, with and without buffered entries
+	clone := list.Clone()
+	for registerIndex := uint32(0); registerIndex < 1<<14; registerIndex++ {
+		if value := clone.get(registerIndex); value != expected[registerIndex] {
+			t.Fatalf("get(%d) = %d, expected %d", registerIndex, value, expected[registerIndex])
+		}
+		if registerIndex%7 == 0 {
+			clone.setMax(registerIndex, 31)
+			if value := clone.get(registerIndex); value != 31 {
+				t.Fatalf("get(%d) = %d after setMax(31)", registerIndex, value)
+			}
+			expected[registerIndex] = 31
+			list.setMax(registerIndex, 31)
+		}
+	}
+
+	if list.Size() != uint(len(expected)) {
+		t.Errorf("size %d, expected %d", list.Size(), len(expected))
+	}
+	counts := make([]uint32, 32)
+	for _, registerValue := range expected {
+		counts[registerValue]++
+	}
+	for registerValue, count := range list.histogram() {
+		if registerValue != 0 && count != counts[registerValue] {
+			t.Errorf("%d registers of value %d, expected %d", count, registerValue, counts[registerValue])
+		}
+	}
+
+	previous := -1
+	it := NewSparseListIterator(list.Clone())
+	for it.HasNext() {
+		registerIndex, registerValue := it.Next()
+		if int(registerIndex) <= previous {
+			t.Fatalf("register %d iterated after %d", registerIndex, previous)
+		}
+		if registerValue != expected[registerIndex] {
+			t.Errorf("register %d is %d, expected %d", registerIndex, registerValue, expected[registerIndex])
+		}
+		previous = int(registerIndex)
+	}
+
+	if bytesPerRegister := float64(len(list.sorted)) / float64(list.Size()); bytesPerRegister > 2 {
+		t.Errorf("%.2f bytes per register", bytesPerRegister)
+	}
+}
@@ -0,0 +1,128 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+/**
+ * Sets the precision of the SPARSE representation and the parameters that
+ * depend on it. See #NewHll6().
+ *
+ * @param sparsePrecision p', or zero to track the log2m registers.
+ */
+func (this *Hll) setSparsePrecision(sparsePrecision uint) error {
+	if sparsePrecision != 0 && (sparsePrecision <= this.log2m || sparsePrecision > MAXIMUM_SPARSE_PRECISION_PARAM) {
+		return fmt.Errorf("sparsePrecision must be zero or greater than log2m (%d) and at most %d (was %d)", this.log2m, MAXIMUM_SPARSE_PRECISION_PARAM, sparsePrecision)
+	}
+	this.sparsePrecision = sparsePrecision
+	this.sparseMBitsMask = (1 << this.sparseLog2m()) - 1
+
+	this.shortWordLength = (this.regwidth + this.sparseLog2m())
+	if this.sparseOff {
+		this.sparseThreshold = 0
+	} else {
+		// NOTE:  the SPARSE representation is used for as long as its short
+		//        words take no more room than the FULL one, which holds
+		//        for the delta-varint encoded SparseList in memory as well
+		largestPow2LessThanCutoff := uint(math.Log2(float64(this.m*this.regwidth) / float64(this.shortWordLength)))
+		this.sparseThreshold = (1 << largestPow2LessThanCutoff)
+	}
+	return nil
+}
+
+/**
+ * @return the log-base-2 of the number of SPARSE registers.
+ */
+func (this *Hll) sparseLog2m() uint {
+	if this.sparsePrecision != 0 {
+		return this.sparsePrecision
+	}
+	return this.log2m
+}
+
+/**
+ * Same as #registerFor() but for the SPARSE registers, see #NewHll6().
+ */
+func (this *Hll) sparseRegisterFor(rawValue uint64) (uint32, byte) {
+	substreamValue := (rawValue >> this.sparseLog2m())
+	if substreamValue == 0 {
+		return 0, 0
+	}
+	return uint32(rawValue & this.sparseMBitsMask), byte(1 + leastSignificantBit(substreamValue|this.pwMaxMask))
+}
+
+/**
+ * Maps a SPARSE register to the register of a FULL HLL with the same
+ * parameters that the same raw values would have set.
+ *
+ * The low log2m bits of the index of a SPARSE register are the index of the
+ * FULL register. The remaining p' - log2m bits are the lowest bits of the
+ * substream that p(w) is computed from at log2m bits, so p(w) is their least
+ * significant set bit if there is one, and otherwise p' - log2m more than the
+ * SPARSE register value.
+ *
+ * NOTE:  raw values whose bits above p' are all zero are ignored by SPARSE
+ *        registers even if they would set a FULL register. This happens with
+ *        a probability of 2^-(64 - p') as in HLL++.
+ *
+ * @return the index and the value of the FULL register. These are the
+ *         arguments if SPARSE registers are not of a higher precision.
+ */
+func (this *Hll) foldSparse(registerIndex uint32, registerValue byte) (uint32, byte) {
+	if this.sparsePrecision == 0 {
+		return registerIndex, registerValue
+	}
+
+	betweenBits := uint64(registerIndex) >> this.log2m
+	var p_w uint
+	if betweenBits == 0 {
+		p_w = (this.sparsePrecision - this.log2m) + uint(registerValue)
+	} else {
+		p_w = 1 + uint(bits.TrailingZeros64(betweenBits))
+	}
+	// NOTE:  capped as by pwMaxMask in #registerFor()
+	return uint32(uint64(registerIndex) & this.mBitsMask), byte(min(p_w, uint(this.valueMask)))
+}
+
+/**
+ * @return the FULL registers that the SPARSE registers fold down to, see
+ *         #foldSparse(). {@link #type} must be SPARSE.
+ */
+func (this *Hll) foldedSparseRegisters() map[uint32]byte {
+	registers := make(map[uint32]byte, this.sparseProbabilisticStorage.Size())
+	it := NewSparseListIterator(this.sparseProbabilisticStorage)
+	for it.HasNext() {
+		registerIndex, registerValue := this.foldSparse(it.Next())
+		if registerValue > registers[registerIndex] {
+			registers[registerIndex] = registerValue
+		}
+	}
+	return registers
+}
+
+/**
+ * Estimates the cardinality of a SPARSE HLL in HLL++ mode by linear counting
+ * over its 2^p' registers. {@link #type} must be SPARSE.
+ */
+func (this *Hll) sparsePrecisionCardinality() float64 {
+	sparseM := uint(1) << this.sparsePrecision
+	return smallEstimator(sparseM, int(sparseM-this.sparseProbabilisticStorage.Size()))
+}
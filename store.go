@@ -0,0 +1,398 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// approximate per-key bookkeeping cost (map slot, list element, entry)
+	// added to the storage footprint of every sketch held by a Store
+	STORE_ENTRY_OVERHEAD = 128
+)
+
+/**
+ * Configuration of a {@link Store}. The HLL parameters are those of
+ * #NewHll5() and are used for every sketch the store creates.
+ */
+type StoreConfig struct {
+	Log2m     uint
+	Regwidth  uint
+	Expthresh int
+	Sparseon  bool
+
+	// memory budget in bytes for all sketches (storage footprint plus
+	// STORE_ENTRY_OVERHEAD per key), least recently used keys are evicted
+	// once it is exceeded. Zero means unbounded.
+	MaxBytes uint
+	// keys which were not touched for longer than this are evicted. Zero
+	// means keys never expire.
+	TTL time.Duration
+	// called with the key and the serialized sketch (see Hll#ToBytes()) of
+	// every evicted key, so that it can be merged into durable storage. It is
+	// never called while the store is locked, so it may use the store.
+	Spill func(key string, data []byte)
+}
+
+type storeEntry struct {
+	key     string
+	hll     *Hll
+	size    uint
+	touched time.Time
+}
+
+/**
+ * A memory-bounded set of HLLs keyed by string. All methods are safe for
+ * concurrent use.
+ */
+type Store struct {
+	config StoreConfig
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// most recently touched entry at the front
+	lru   *list.List
+	bytes uint
+
+	// clock, replaceable for testing
+	now func() time.Time
+}
+
+/**
+ * @param config the store configuration. The HLL parameters are validated
+ *        as by #NewHll5().
+ */
+func NewStore(config StoreConfig) (*Store, error) {
+	if _, err := NewHll5(config.Log2m, config.Regwidth, config.Expthresh, config.Sparseon, EMPTY); err != nil {
+		return nil, err
+	}
+
+	this := &Store{}
+	this.config = config
+	this.entries = make(map[string]*list.Element)
+	this.lru = list.New()
+	this.now = time.Now
+	return this, nil
+}
+
+/**
+ * Adds the hashed value to the sketch of <code>key</code>, creating it if
+ * necessary.
+ *
+ * @return <code>true</code> if the sketch changed, which is the meaning of
+ *         the reply of Redis' <code>PFADD</code>.
+ */
+func (this *Store) Add(key string, rawValue uint64) bool {
+	this.mu.Lock()
+	evicted := this.expire()
+	entry := this.getOrCreate(key)
+	changed := entry.hll.wouldChange(rawValue)
+	if changed {
+		entry.hll.Add(rawValue)
+	}
+	evicted = append(evicted, this.update(entry)...)
+	this.mu.Unlock()
+
+	this.spill(evicted)
+	return changed
+}
+
+/**
+ * Unions <code>other</code> into the sketch of <code>key</code>, creating it
+ * if necessary. <code>other</code> is not modified.
+ */
+func (this *Store) Merge(key string, other *Hll) error {
+	this.mu.Lock()
+	evicted := this.expire()
+	// NOTE:  validated before the entry is created, so that a rejected
+	//        sketch leaves no empty entry behind
+	if err := this.checkCompatible(other); err != nil {
+		this.mu.Unlock()
+		this.spill(evicted)
+		return err
+	}
+	entry := this.getOrCreate(key)
+	entry.hll.Union(other)
+	evicted = append(evicted, this.update(entry)...)
+	this.mu.Unlock()
+
+	this.spill(evicted)
+	return nil
+}
+
+/**
+ * Replaces the sketch of <code>key</code>. The store takes ownership of
+ * <code>h</code>, which must not be modified afterwards.
+ */
+func (this *Store) Set(key string, h *Hll) error {
+	this.mu.Lock()
+	evicted := this.expire()
+	// NOTE:  validated before the entry is created, so that a rejected
+	//        sketch leaves no empty entry behind
+	if err := this.checkCompatible(h); err != nil {
+		this.mu.Unlock()
+		this.spill(evicted)
+		return err
+	}
+	entry := this.getOrCreate(key)
+	entry.hll = h
+	evicted = append(evicted, this.update(entry)...)
+	this.mu.Unlock()
+
+	this.spill(evicted)
+	return nil
+}
+
+/**
+ * @return a copy of the sketch of <code>key</code> and <code>true</code>,
+ *         or <code>nil</code> and <code>false</code> if the key is absent
+ *         or expired.
+ */
+func (this *Store) Get(key string) (*Hll, bool) {
+	this.mu.Lock()
+	evicted := this.expire()
+	element, ok := this.entries[key]
+	var h *Hll
+	if ok {
+		entry := element.Value.(*storeEntry)
+		entry.touched = this.now()
+		this.lru.MoveToFront(element)
+		h = entry.hll.Clone()
+	}
+	this.mu.Unlock()
+
+	this.spill(evicted)
+	return h, ok
+}
+
+/**
+ * Computes the cardinality of the union of the sketches of the given keys.
+ * Absent keys are treated as empty.
+ */
+func (this *Store) Cardinality(keys ...string) uint {
+	this.mu.Lock()
+	evicted := this.expire()
+	var union *Hll
+	for _, key := range keys {
+		element, ok := this.entries[key]
+		if !ok {
+			continue
+		}
+		entry := element.Value.(*storeEntry)
+		entry.touched = this.now()
+		this.lru.MoveToFront(element)
+		if union == nil {
+			union = entry.hll.Clone()
+		} else {
+			union.Union(entry.hll)
+		}
+	}
+	this.mu.Unlock()
+
+	this.spill(evicted)
+	if union == nil {
+		return 0
+	}
+	return union.Cardinality()
+}
+
+/**
+ * Removes <code>key</code> without spilling it.
+ *
+ * @return <code>true</code> if the key was present.
+ */
+func (this *Store) Delete(key string) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	element, ok := this.entries[key]
+	if ok {
+		this.remove(element)
+	}
+	return ok
+}
+
+/**
+ * @return the keys currently held, sorted.
+ */
+func (this *Store) Keys() []string {
+	this.mu.Lock()
+	evicted := this.expire()
+	keys := make([]string, 0, len(this.entries))
+	for key := range this.entries {
+		keys = append(keys, key)
+	}
+	this.mu.Unlock()
+
+	this.spill(evicted)
+	sort.Strings(keys)
+	return keys
+}
+
+/**
+ * @return the number of keys currently held.
+ */
+func (this *Store) Len() int {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return len(this.entries)
+}
+
+/**
+ * @return the accounted memory of all keys, see StoreConfig#MaxBytes.
+ */
+func (this *Store) Bytes() uint {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.bytes
+}
+
+/**
+ * Evicts and spills all keys whose TTL has passed. Expiry is also checked on
+ * every access, this only needs to be called to reclaim memory of a store
+ * that is otherwise idle.
+ */
+func (this *Store) Expire() {
+	this.mu.Lock()
+	evicted := this.expire()
+	this.mu.Unlock()
+
+	this.spill(evicted)
+}
+
+/**
+ * Evicts and spills all keys, e.g. on shutdown.
+ */
+func (this *Store) SpillAll() {
+	this.mu.Lock()
+	var evicted []*storeEntry
+	for element := this.lru.Back(); element != nil; element = this.lru.Back() {
+		evicted = append(evicted, this.remove(element))
+	}
+	this.mu.Unlock()
+
+	this.spill(evicted)
+}
+
+/**
+ * Calls <code>fn</code> for every key with a copy of its sketch, in no
+ * particular order, until <code>fn</code> returns <code>false</code>. The
+ * store is locked for the whole iteration.
+ */
+func (this *Store) Range(fn func(key string, h *Hll) bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for key, element := range this.entries {
+		if !fn(key, element.Value.(*storeEntry).hll.Clone()) {
+			return
+		}
+	}
+}
+
+/**
+ * Verifies that <code>h</code> can be stored, see Hll#checkCompatible().
+ */
+func (this *Store) checkCompatible(h *Hll) error {
+	if h.log2m != this.config.Log2m || h.regwidth != this.config.Regwidth {
+		return fmt.Errorf("incompatible HLL: log2m %d/%d, regwidth %d/%d", h.log2m, this.config.Log2m, h.regwidth, this.config.Regwidth)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------
+// must be called with the lock held
+
+/**
+ * NOTE:  #expire() must have been called first so that an expired sketch is
+ *        not returned.
+ */
+func (this *Store) getOrCreate(key string) *storeEntry {
+	if element, ok := this.entries[key]; ok {
+		return element.Value.(*storeEntry)
+	}
+
+	h, _ := NewHll5(this.config.Log2m, this.config.Regwidth, this.config.Expthresh, this.config.Sparseon, EMPTY)
+	entry := &storeEntry{key: key, hll: h}
+	this.entries[key] = this.lru.PushFront(entry)
+	return entry
+}
+
+/**
+ * Re-accounts the memory of the touched entry and evicts least recently used
+ * entries until the store is within its budget.
+ *
+ * @return the evicted entries, to be spilled once the lock is released.
+ */
+func (this *Store) update(entry *storeEntry) []*storeEntry {
+	entry.touched = this.now()
+	this.lru.MoveToFront(this.entries[entry.key])
+
+	size := entry.hll.SizeInBytes() + STORE_ENTRY_OVERHEAD
+	this.bytes = this.bytes - entry.size + size
+	entry.size = size
+
+	var evicted []*storeEntry
+	if this.config.MaxBytes > 0 {
+		for this.bytes > this.config.MaxBytes && this.lru.Len() > 0 {
+			evicted = append(evicted, this.remove(this.lru.Back()))
+		}
+	}
+	return evicted
+}
+
+func (this *Store) expired(entry *storeEntry) bool {
+	return this.config.TTL > 0 && this.now().Sub(entry.touched) > this.config.TTL
+}
+
+func (this *Store) expire() []*storeEntry {
+	var evicted []*storeEntry
+	if this.config.TTL <= 0 {
+		return evicted
+	}
+	// NOTE:  the list is ordered by touch time so expired entries are at the
+	//        back
+	for element := this.lru.Back(); element != nil; element = this.lru.Back() {
+		entry := element.Value.(*storeEntry)
+		if !this.expired(entry) {
+			break
+		}
+		evicted = append(evicted, this.remove(element))
+	}
+	return evicted
+}
+
+func (this *Store) remove(element *list.Element) *storeEntry {
+	entry := this.lru.Remove(element).(*storeEntry)
+	delete(this.entries, entry.key)
+	this.bytes -= entry.size
+	return entry
+}
+
+func (this *Store) spill(evicted []*storeEntry) {
+	if this.config.Spill == nil {
+		return
+	}
+	for _, entry := range evicted {
+		this.config.Spill(entry.key, entry.hll.ToBytes())
+	}
+}
@@ -0,0 +1,103 @@
+/**
+ * Copyright 2016 l0vest0rm.hll authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"): you may
+ * not use this file except in compliance with the License. You may obtain
+ * a copy of the License at
+ *
+ *     http: *www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package hll
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStoreEviction(t *testing.T) {
+	spilled := make(map[string][]byte)
+	store, err := NewStore(StoreConfig{
+		Log2m:     11,
+		Regwidth:  5,
+		Expthresh: -1,
+		Sparseon:  true,
+		MaxBytes:  3 * (STORE_ENTRY_OVERHEAD + 2048),
+		Spill: func(key string, data []byte) {
+			spilled[key] = data
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		for _, v := range randClientids(2000) {
+			store.Add(key, v)
+		}
+	}
+
+	if store.Bytes() > 3*(STORE_ENTRY_OVERHEAD+2048) {
+		t.Errorf("store exceeds budget: %d", store.Bytes())
+	}
+	if len(spilled)+store.Len() != 10 {
+		t.Errorf("spilled %d and kept %d keys, expected 10 in total", len(spilled), store.Len())
+	}
+	if _, ok := spilled["key0"]; !ok {
+		t.Errorf("least recently used key was not spilled")
+	}
+	for key, data := range spilled {
+		h, err := NewHllFromBytes(data)
+		if err != nil || h.Cardinality() == 0 {
+			t.Errorf("bad spilled sketch for %s: %v", key, err)
+		}
+	}
+}
+
+func TestStoreTTL(t *testing.T) {
+	var spilled []string
+	store, _ := NewStore(StoreConfig{
+		Log2m:     11,
+		Regwidth:  5,
+		Expthresh: -1,
+		Sparseon:  true,
+		TTL:       time.Minute,
+		Spill: func(key string, data []byte) {
+			spilled = append(spilled, key)
+		},
+	})
+	now := time.Unix(0, 0)
+	store.now = func() time.Time { return now }
+
+	if !store.Add("a", 1<<20) || store.Add("a", 1<<20) {
+		t.Errorf("unexpected PFADD semantics")
+	}
+	now = now.Add(30 * time.Second)
+	store.Add("b", 1<<20)
+	now = now.Add(45 * time.Second)
+	store.Expire()
+
+	if len(spilled) != 1 || spilled[0] != "a" {
+		t.Errorf("expected only a to expire, spilled %v", spilled)
+	}
+	if _, ok := store.Get("b"); !ok {
+		t.Errorf("b expired too early")
+	}
+
+	// rejected sketches create no entry
+	incompatible, _ := NewHll(12, 5)
+	if store.Merge("x", incompatible) == nil || store.Set("y", incompatible) == nil {
+		t.Errorf("expected incompatible sketches to be rejected")
+	}
+	if keys := store.Keys(); len(keys) != 1 || keys[0] != "b" {
+		t.Errorf("expected b only, got %v", keys)
+	}
+}
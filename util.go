@@ -77,6 +77,12 @@ var(
     TWO_TO_L = [(MAXIMUM_REGWIDTH_PARAM + 1) * (MAXIMUM_LOG2M_PARAM + 1)]float64{}
 )
 
+// NOTE:  TWO_TO_L is filled once here rather than by every constructor, which
+//        would be a data race with concurrent readers
+func init() {
+    initTwoToL()
+}
+
 func initTwoToL(){
     for regWidth := MINIMUM_REGWIDTH_PARAM; regWidth <= MAXIMUM_REGWIDTH_PARAM; regWidth++ {
         for log2m := MINIMUM_LOG2M_PARAM ; log2m <= MAXIMUM_LOG2M_PARAM; log2m++ {
@@ -268,52 +274,3 @@ func murmur3Hash32(x uint32) uint32 {
     x ^= x >> 16;
     return x;
 }
-
-/** Returns the maximum number of entries that can be filled before rehashing.
-	 *
-	 * @param n the size of the backing array.
-	 * @param f the load factor.
-	 * @return the maximum number of entries before rehashing.
-	 */
-func maxFill(n uint, f float64) uint {
-    /* We must guarantee that there is always at least
-     * one free entry (even with pathological load factors). */
-    return uint(math.Min(math.Ceil( float64(n) * f ), float64(n - 1) ))
-}
-
-/** Returns the least power of two smaller than or equal to 2<sup>30</sup> and larger than or equal to <code>Math.ceil( expected / f )</code>.
-	 *
-	 * @param expected the expected number of elements in a hash table.
-	 * @param f the load factor.
-	 * @return the minimum possible size for a backing array.
-	 * @throws IllegalArgumentException if the necessary size is larger than 2<sup>30</sup>.
-	 */
-func arraySize(expected uint, f float64) uint {
-    s := uint(math.Max( float64(2), float64(nextPowerOfTwo(uint64(math.Ceil( float64(expected) / f ) )) )))
-    if s > (1 << 30) {
-        panic(fmt.Sprintf("Too large (%d expected elements with load factor %f)", expected, f))
-        return 0
-    }
-    return s
-}
-
-/** Return the least power of two greater than or equal to the specified value.
-	 *
-	 * <p>Note that this function will return 1 when the argument is 0.
-	 *
-	 * @param x a long integer smaller than or equal to 2<sup>62</sup>.
-	 * @return the least power of two greater than or equal to the specified value.
-	 */
-func nextPowerOfTwo( x uint64) uint64 {
-    if ( x == 0 ) {
-        return 1
-    }
-
-    x--;
-    x |= x >> 1;
-    x |= x >> 2;
-    x |= x >> 4;
-    x |= x >> 8;
-    x |= x >> 16;
-    return ( x | x >> 32 ) + 1
-}